@@ -9,6 +9,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 const (
 	infoUrl          = "https://api.bilibili.com/x/space/acc/info"
 	roomInfoUrl      = "https://api.live.bilibili.com/xlive/web-room/v1/index/getInfoByRoom"
+	roomStatusUrl    = "https://api.live.bilibili.com/room/v1/Room/get_status_info_by_uids"
 	liveUrlPrefix    = "https://live.bilibili.com/"
 	spaceUrl         = "https://api.bilibili.com/x/polymer/web-dynamic/v1/feed/space"
 	dynamicUrlPrefix = "https://t.bilibili.com/"
@@ -26,10 +28,15 @@ const (
 	musicUrlPrefix   = "https://www.bilibili.com/audio/au"
 	interval         = 10 * time.Second
 	waitInterval     = 100 * time.Millisecond
+	defaultMaxPages  = 3 //获取动态时最多翻的页数
+	biliApiHost      = "api.bilibili.com"
+	liveApiHost      = "api.live.bilibili.com"
 )
 
 var (
 	ErrEmptyRespData = errors.New("empty data") //http响应体为空
+	//请求b站接口的客户端，负责wbi签名和风控退避
+	biliClient = req.NewBili(10)
 	//liveInfo对象池
 	liveInfoPool = &sync.Pool{
 		New: func() any {
@@ -45,11 +52,25 @@ var (
 )
 
 var _ Source = (*BiliLiveSource)(nil)
+var _ Named = (*BiliLiveSource)(nil)
 
 // BiliLiveSource 获取b站直播间是否开播状态
 type BiliLiveSource struct {
-	room   []int
-	living map[int]bool
+	*liveTracker
+	health
+	room      []int
+	infoWatch map[int]bool          //需要推送直播间信息变更的房间
+	lastInfo  map[int]*roomSnapshot //上一次获取到的直播间信息
+	roomUid   map[int]int64         //房间号对应的主播uid，用于批量获取开播状态
+	lock      sync.Mutex            //保护lastInfo和roomUid
+	sched     *scheduler
+}
+
+// roomSnapshot 直播间的标题、分区和封面，用于判断直播间信息是否变更
+type roomSnapshot struct {
+	title string
+	area  string
+	cover string
 }
 
 // LiveInfo 直播间信息
@@ -60,9 +81,12 @@ type LiveInfo struct {
 	LiveStatus bool   //是否开播
 	RoomId     int    //房间号
 	RoomIdStr  string
-	Title      string //房间标题
-	Area       string //直播间分区
-	Cover      string //封面
+	Title      string    //房间标题
+	Area       string    //直播间分区
+	Cover      string    //封面
+	Link       string    //直播间链接
+	Online     int64     //人气或在线人数，为0时表示没有获取到
+	StartTime  time.Time //开播时间，为零值时表示没有获取到
 }
 
 func (l *LiveInfo) Reset() {
@@ -73,7 +97,11 @@ func (l *LiveInfo) Reset() {
 	l.RoomId = 0
 	l.RoomIdStr = ""
 	l.Title = ""
+	l.Area = ""
 	l.Cover = ""
+	l.Link = ""
+	l.Online = 0
+	l.StartTime = time.Time{}
 }
 
 func NewBiliLiveSource(room []int) *BiliLiveSource {
@@ -81,11 +109,49 @@ func NewBiliLiveSource(room []int) *BiliLiveSource {
 		"room": room,
 	}).Info("[BiliLive]监控b站开播状态")
 	return &BiliLiveSource{
-		room:   append([]int{}, room...),
-		living: make(map[int]bool),
+		liveTracker: newLiveTracker("[BiliLive]", "", BiliLiveMsg),
+		room:        append([]int{}, room...),
+		infoWatch:   make(map[int]bool),
+		lastInfo:    make(map[int]*roomSnapshot),
+		roomUid:     make(map[int]int64),
+		sched:       newScheduler("[BiliLive]", liveApiHost, interval),
+	}
+}
+
+// WatchInfoChange 推送房间的标题、分区、封面变更消息，必须在 Send方法之前调用
+func (b *BiliLiveSource) WatchInfoChange(room ...int) {
+	logger.WithFields(logrus.Fields{
+		"room": room,
+	}).Info("[BiliLive]监控b站直播间信息变更")
+	for _, id := range room {
+		b.infoWatch[id] = true
+	}
+}
+
+// SetBiliCookies 设置请求b站接口时使用的cookies，例如登录后的"SESSDATA"
+func SetBiliCookies(cookies map[string]string) {
+	for k, v := range cookies {
+		if v != "" {
+			biliClient.SetCookies(k, v)
+		}
 	}
 }
 
+// SetInterval 设置轮询间隔，rooms为单独设置间隔的房间号，必须在Send方法之前调用
+func (b *BiliLiveSource) SetInterval(d time.Duration, rooms map[string]time.Duration) {
+	b.sched.setInterval(d, rooms)
+}
+
+// SetAdaptive 在主播通常开播的时间前后使用更短的轮询间隔，必须在Send方法之前调用
+func (b *BiliLiveSource) SetAdaptive(fast, window time.Duration) {
+	b.sched.setAdaptive(b.adaptiveInterval(fast, window))
+}
+
+// SetInterval 设置轮询间隔，users为单独设置间隔的uid，必须在Send方法之前调用
+func (b *BiliDynamicSource) SetInterval(d time.Duration, users map[string]time.Duration) {
+	b.sched.setInterval(d, users)
+}
+
 func checkResp(buf *bytes.Buffer) (result *gjson.Result, err error) {
 	if buf == nil || buf.Len() == 0 {
 		return nil, ErrEmptyRespData
@@ -109,7 +175,7 @@ func checkBiliData(r *gjson.Result) (data *gjson.Result, code int, msg string) {
 
 // 获取直播间信息,此处的id为直播间号,可以是短号
 func getRoomInfo(roomId int) (info *LiveInfo, err error) {
-	body, err := req.Get(roomInfoUrl, req.D{{"room_id", roomId}})
+	body, err := biliClient.Get(roomInfoUrl, req.D{{"room_id", roomId}}, false)
 	if err != nil {
 		return nil, err
 	}
@@ -159,9 +225,7 @@ func getRoomInfo(roomId int) (info *LiveInfo, err error) {
 	info.Uname = uname.String()
 	info.LiveStatus = status.Int() == 1
 	info.RoomId = roomId
-	if !info.LiveStatus {
-		return info, nil
-	}
+	info.Link = fmt.Sprintf("%s%d", liveUrlPrefix, roomId)
 	info.Title = roomInfo.Get("title").String()
 	info.Area = fmt.Sprintf("%s-%s",
 		roomInfo.Get("parent_area_name").String(),
@@ -174,120 +238,263 @@ func getRoomInfo(roomId int) (info *LiveInfo, err error) {
 		}).Warn("获取直播间分区失败")
 	}
 	info.Cover = roomInfo.Get("cover").String()
+	info.Online = roomInfo.Get("online").Int()
+	if start := roomInfo.Get("live_start_time").Int(); start != 0 {
+		info.StartTime = time.Unix(start, 0)
+	}
 	return info, nil
 }
 
+// 通过主播uid批量获取开播状态，返回以uid为key的直播间信息
+func getRoomInfoByUids(uids []int64) (infos map[int64]*LiveInfo, err error) {
+	params := make(req.D, 0, len(uids))
+	for _, uid := range uids {
+		params = append(params, req.E{Name: "uids[]", Value: uid})
+	}
+	body, err := biliClient.Get(roomStatusUrl, params, false)
+	if err != nil {
+		return nil, err
+	}
+	result, err := checkResp(body)
+	if err != nil {
+		return nil, errors.Wrap(err, "read bili resp data fail")
+	}
+	data, code, msg := checkBiliData(result)
+	if code != 0 {
+		return nil, errors.New(fmt.Sprintf("code=%d,msg=%s", code, msg))
+	}
+	return parseRoomStatus(data), nil
+}
+
+func parseRoomStatus(data *gjson.Result) map[int64]*LiveInfo {
+	infos := make(map[int64]*LiveInfo)
+	//没有数据时data为空数组
+	if !data.IsObject() {
+		return infos
+	}
+	data.ForEach(func(_, room gjson.Result) bool {
+		info := liveInfoPool.Get().(*LiveInfo)
+		info.Mid = room.Get("uid").Int()
+		info.Uname = room.Get("uname").String()
+		//2为轮播，不算开播
+		info.LiveStatus = room.Get("live_status").Int() == 1
+		info.RoomId = int(room.Get("room_id").Int())
+		info.Title = room.Get("title").String()
+		parent, area := room.Get("area_v2_parent_name").String(), room.Get("area_v2_name").String()
+		if parent != "" && area != "" {
+			info.Area = fmt.Sprintf("%s-%s", parent, area)
+		}
+		info.Cover = room.Get("cover_from_user").String()
+		info.Online = room.Get("online").Int()
+		if start := room.Get("live_time").Int(); start != 0 {
+			info.StartTime = time.Unix(start, 0)
+		}
+		infos[info.Mid] = info
+		return true
+	})
+	return infos
+}
+
+// 批量获取开播状态，已经知道uid的房间使用一次请求获取，其余房间或者批量获取失败时并发逐个获取
+func (b *BiliLiveSource) poll(ctx context.Context, rooms []int, now time.Time, ch chan<- *push.Msg) {
+	b.lock.Lock()
+	uids := make([]int64, 0, len(rooms))
+	roomUid := make(map[int]int64, len(rooms))
+	for _, id := range rooms {
+		if uid, ok := b.roomUid[id]; ok {
+			uids = append(uids, uid)
+			roomUid[id] = uid
+		}
+	}
+	b.lock.Unlock()
+	var infos map[int64]*LiveInfo
+	if len(uids) != 0 {
+		//批量请求同样受主机请求间隔和并发数量的限制
+		if !b.sched.acquire(ctx) {
+			return
+		}
+		var err error
+		infos, err = getRoomInfoByUids(uids)
+		b.sched.release()
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"len(uids)": len(uids),
+				"err":       err,
+			}).Warn("[BiliLive]批量获取开播状态失败，逐个获取")
+		}
+	}
+	var rest []int
+	for _, id := range rooms {
+		uid, ok := roomUid[id]
+		info := infos[uid]
+		if !ok || info == nil {
+			rest = append(rest, id)
+			continue
+		}
+		//批量接口中的房间号是长号，统一使用配置中的房间号
+		info.RoomId = id
+		info.Link = fmt.Sprintf("%s%d", liveUrlPrefix, id)
+		delete(infos, uid)
+		b.succeed(id)
+		b.handleInfo(id, info, now, ch)
+	}
+	each(ctx, b.sched, rest, func(id int) {
+		b.sendInfo(id, now, ch)
+	})
+	//没有使用的批量结果放回对象池
+	for _, info := range infos {
+		info.Reset()
+		liveInfoPool.Put(info)
+	}
+}
+
 func (b *BiliLiveSource) sendInfo(id int, now time.Time, ch chan<- *push.Msg) bool {
 	info, err := getRoomInfo(id)
 	if err != nil {
+		b.fail(id, err)
 		logger.WithFields(logrus.Fields{
 			"uid": id,
 			"err": err,
 		}).Error("[BiliLive]获取开播状态失败")
 		return false
 	}
-	//当前开播状态和已经记录的开播状态相同，说明已经发送过消息
-	if info.LiveStatus == b.living[id] {
-		logger.WithFields(logrus.Fields{
-			"id":     info.Mid,
-			"living": info.LiveStatus,
-		}).Debug("[BiliLive]开播状态未改变")
-		info.Reset()
-		liveInfoPool.Put(info)
-		return false
-	}
-	msg := &push.Msg{
-		Times:  now,
-		Flag:   BiliLiveMsg,
-		Author: info.Uname,
+	b.succeed(id)
+	if info.Mid != 0 {
+		b.lock.Lock()
+		b.roomUid[id] = info.Mid
+		b.lock.Unlock()
 	}
+	return b.handleInfo(id, info, now, ch)
+}
 
-	b.living[id] = info.LiveStatus
-	if info.LiveStatus {
-		//开播
-		msg.Title = "开播了"
-		if info.Area != "" {
-			msg.Text = fmt.Sprintf("标题：\"%s\"\n分区：\"%s\"", info.Title, info.Area)
-		} else {
-			msg.Text = fmt.Sprintf("标题：\"%s\"", info.Title)
+// 处理获取到的直播间信息，有消息发送时返回true
+func (b *BiliLiveSource) handleInfo(id int, info *LiveInfo, now time.Time, ch chan<- *push.Msg) bool {
+	var sent bool
+	if b.infoWatch[id] {
+		if msg := b.infoChanged(id, info, now); msg != nil {
+			ch <- msg
+			sent = true
 		}
-		msg.Img = []string{info.Cover}
-		msg.Src = fmt.Sprintf("%s%d", liveUrlPrefix, info.RoomId)
-		logger.WithFields(logrus.Fields{
-			"id":   id,
-			"name": info.Uname,
-		}).Debug("[BiliLive]b站直播间开播")
-	} else {
-		//下播
-		msg.Title = "下播了"
-		msg.Text = "😭😭😭"
-		logger.WithFields(logrus.Fields{
-			"id":   id,
-			"name": info.Uname,
-		}).Debug("[BiliLive]b站直播间下播")
 	}
-	ch <- msg
+	msg := b.update(strconv.Itoa(id), info, now)
 	info.Reset()
 	liveInfoPool.Put(info)
+	if msg == nil {
+		return sent
+	}
+	ch <- msg
 	return true
 }
 
-func (b *BiliLiveSource) Send(ctx context.Context, ch chan<- *push.Msg) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			logger.Info("[BiliLive]停止监控b站直播间")
-			return
-		case now := <-ticker.C:
-			for _, id := range b.room {
-				if !b.sendInfo(id, now, ch) {
-					continue
-				}
-				time.Sleep(waitInterval)
-			}
-		}
+// 比较直播间的标题、分区、封面，发生变更时返回需要推送的消息，第一次获取时只做记录
+func (b *BiliLiveSource) infoChanged(id int, info *LiveInfo, now time.Time) *push.Msg {
+	current := &roomSnapshot{
+		title: info.Title,
+		area:  info.Area,
+		cover: info.Cover,
+	}
+	b.lock.Lock()
+	last := b.lastInfo[id]
+	b.lastInfo[id] = current
+	b.lock.Unlock()
+	if last == nil || *last == *current {
+		return nil
+	}
+	text := strings.Builder{}
+	if last.title != current.title {
+		text.WriteString(fmt.Sprintf("标题：\"%s\" -> \"%s\"\n", last.title, current.title))
 	}
+	if last.area != current.area {
+		text.WriteString(fmt.Sprintf("分区：\"%s\" -> \"%s\"\n", last.area, current.area))
+	}
+	msg := &push.Msg{
+		Times:  now,
+		Flag:   BiliLiveInfoMsg,
+		Author: info.Uname,
+		Title:  "直播间信息变更",
+		Src:    info.Link,
+	}
+	if last.cover != current.cover {
+		text.WriteString("封面已更新\n")
+		msg.Img = []string{current.cover}
+	}
+	msg.Text = strings.TrimSuffix(text.String(), "\n")
+	logger.WithFields(logrus.Fields{
+		"id":   id,
+		"name": info.Uname,
+		"text": msg.Text,
+	}).Debug("[BiliLive]b站直播间信息变更")
+	return msg
+}
+
+func (b *BiliLiveSource) Name() string {
+	return "[BiliLive]"
+}
+
+func (b *BiliLiveSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	//每个房间按自己的间隔轮询，同时到时间的房间一次请求获取
+	batch(ctx, b.sched, b.room, func(rooms []int, now time.Time) {
+		b.poll(ctx, rooms, now, ch)
+	})
+	logger.Info("[BiliLive]停止监控b站直播间")
 }
 
 const (
-	DynamicTypeForward  = "DYNAMIC_TYPE_FORWARD"   //转发动态
-	DynamicTypeDraw     = "DYNAMIC_TYPE_DRAW"      //带图片动态
-	DynamicTypeAV       = "DYNAMIC_TYPE_AV"        //视频
-	DynamicTypeWord     = "DYNAMIC_TYPE_WORD"      //纯文本
-	DynamicTypeArticle  = "DYNAMIC_TYPE_ARTICLE"   //专栏
-	DynamicTypeMusic    = "DYNAMIC_TYPE_MUSIC"     //音频
-	DynamicTypePGC      = "DYNAMIC_TYPE_PGC"       //分享番剧
-	DynamicTypeLiveRCMD = "DYNAMIC_TYPE_LIVE_RCMD" //开播推送的动态，不做处理
-	DynamicTypeLive     = "DYNAMIC_TYPE_LIVE"      //分享直播间
+	DynamicTypeForward  = "DYNAMIC_TYPE_FORWARD"         //转发动态
+	DynamicTypeDraw     = "DYNAMIC_TYPE_DRAW"            //带图片动态
+	DynamicTypeAV       = "DYNAMIC_TYPE_AV"              //视频
+	DynamicTypeWord     = "DYNAMIC_TYPE_WORD"            //纯文本
+	DynamicTypeArticle  = "DYNAMIC_TYPE_ARTICLE"         //专栏
+	DynamicTypeMusic    = "DYNAMIC_TYPE_MUSIC"           //音频
+	DynamicTypePGC      = "DYNAMIC_TYPE_PGC"             //番剧更新
+	DynamicTypePGCUnion = "DYNAMIC_TYPE_PGC_UNION"       //番剧、影视更新
+	DynamicTypeLiveRCMD = "DYNAMIC_TYPE_LIVE_RCMD"       //开播推送的动态，不做处理
+	DynamicTypeLive     = "DYNAMIC_TYPE_LIVE"            //分享直播间
+	DynamicTypeUGC      = "DYNAMIC_TYPE_UGC_SEASON"      //合集更新
+	DynamicTypeCourses  = "DYNAMIC_TYPE_COURSES_SEASON"  //课程
+	DynamicTypeCommon   = "DYNAMIC_TYPE_COMMON_SQUARE"   //装扮、游戏、商品等通用卡片
+	DynamicTypeCommonV  = "DYNAMIC_TYPE_COMMON_VERTICAL" //竖版通用卡片
+	DynamicTypeMedia    = "DYNAMIC_TYPE_MEDIALIST"       //收藏夹
+	DynamicTypeNone     = "DYNAMIC_TYPE_NONE"            //源动态已被删除
 )
 
 // 让编译器检查*BiliDynamicSource实现了Source接口
 var _ Source = (*BiliDynamicSource)(nil)
+var _ Named = (*BiliDynamicSource)(nil)
 
 type BiliDynamicSource struct {
+	health
 	uid       []int64
-	lastTable map[int64]int64
+	maxPages  int
+	lastTable map[int64]int64            //每个用户已经推送的动态中最新的发布时间
+	seenTable map[int64]map[string]int64 //每个用户已经推送的动态id_str和发布时间，用于过滤同一秒发布的动态
+	lock      sync.Mutex                 //保护lastTable和seenTable
+	sched     *scheduler
 }
 
 type DynamicInfo struct {
 	types  string    //动态类型
+	dynId  string    //动态的id_str
+	pinned bool      //是否是置顶动态
 	id     string    //动态的id，如果是视频，则是bv号
 	text   string    //动态内容
 	img    []string  //动态中的图片
 	author string    //动态作者
 	src    string    //动态链接
+	orig   string    //转发的原动态链接
 	times  time.Time //动态发布时间
 }
 
 func (d *DynamicInfo) Reset() {
 	d.types = ""
+	d.dynId = ""
+	d.pinned = false
 	d.id = ""
 	d.text = ""
 	d.img = nil
 	d.author = ""
 	d.src = ""
+	d.orig = ""
 }
 
 func NewBiliDynamicSource(uid []int64) *BiliDynamicSource {
@@ -296,77 +503,187 @@ func NewBiliDynamicSource(uid []int64) *BiliDynamicSource {
 	}).Info("[BiliDyn]监控b站动态更新")
 	return &BiliDynamicSource{
 		uid:       uid,
+		maxPages:  defaultMaxPages,
 		lastTable: make(map[int64]int64),
+		seenTable: make(map[int64]map[string]int64),
+		sched:     newScheduler("[BiliDyn]", biliApiHost, interval),
+	}
+}
+
+// SetMaxPages 设置获取动态时最多翻的页数
+func (b *BiliDynamicSource) SetMaxPages(n int) {
+	if n <= 0 {
+		logger.WithField("maxPages", n).Warn("[BiliDyn]错误的最大页数，使用默认值")
+		n = defaultMaxPages
 	}
+	b.maxPages = n
+}
+
+func (b *BiliDynamicSource) Name() string {
+	return "[BiliDyn]"
 }
 
 func (b *BiliDynamicSource) Send(ctx context.Context, ch chan<- *push.Msg) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			logger.Info("[BiliDyn]停止b站动态监控")
-			return
-		case now := <-ticker.C:
-			for _, id := range b.uid {
-				infos, err := b.space(id, now)
-				if err != nil {
-					logger.WithFields(logrus.Fields{
-						"id":  id,
-						"err": err,
-					}).Error("[BiliDyn]获取b站动态失败")
-					continue
-				}
-				if len(infos) == 0 {
-					logger.WithFields(logrus.Fields{
-						"id": id,
-					}).Debug("[BiliDyn]无新动态")
-				}
-				for _, info := range infos {
-					logger.WithFields(logrus.Fields{
-						"id":    id,
-						"name":  info.author,
-						"title": info.types,
-						"src":   info.src,
-					}).Debug("[BiliDyn]更新动态")
-					msg := &push.Msg{
-						Flag:   BiliDynMsg,
-						Times:  info.times,
-						Author: info.author,
-						Title:  info.types,
-						Text:   info.text,
-						Img:    info.img,
-						Src:    info.src,
-					}
-					ch <- msg
-					info.Reset()
-					dynInfoPool.Put(info)
-				}
-				time.Sleep(waitInterval)
-			}
+	schedule(ctx, b.sched, b.uid, func(id int64, now time.Time) {
+		b.poll(id, now, ch)
+	})
+	logger.Info("[BiliDyn]停止b站动态监控")
+}
+
+// 获取一个用户的新动态并推送
+func (b *BiliDynamicSource) poll(id int64, now time.Time, ch chan<- *push.Msg) {
+	infos, err := b.space(id, now)
+	if err != nil {
+		b.fail(id, err)
+		logger.WithFields(logrus.Fields{
+			"id":  id,
+			"err": err,
+		}).Error("[BiliDyn]获取b站动态失败")
+		return
+	}
+	b.succeed(id)
+	if len(infos) == 0 {
+		logger.WithFields(logrus.Fields{
+			"id": id,
+		}).Debug("[BiliDyn]无新动态")
+	}
+	for _, info := range infos {
+		logger.WithFields(logrus.Fields{
+			"id":    id,
+			"name":  info.author,
+			"title": info.types,
+			"src":   info.src,
+		}).Debug("[BiliDyn]更新动态")
+		msg := &push.Msg{
+			Flag:   BiliDynMsg,
+			Times:  info.times,
+			Author: info.author,
+			Title:  info.types,
+			Text:   info.text,
+			Img:    info.img,
+			Src:    info.src,
 		}
+		if info.orig != "" {
+			msg.Refs = []string{info.orig}
+		}
+		ch <- msg
+		info.Reset()
+		dynInfoPool.Put(info)
 	}
 }
 
-// 获取动态
+// 获取动态，从第一页开始翻页，直到遇到已经推送过的动态或者达到最大页数
 func (b *BiliDynamicSource) space(id int64, now time.Time) (infos []*DynamicInfo, err error) {
-	resp, err := req.Get(spaceUrl, req.D{
-		{"offset", ""},
+	//同一个id不会同时轮询，seen只会被一个goroutine使用
+	b.lock.Lock()
+	last := b.lastTable[id]
+	seen := b.seenTable[id]
+	if seen == nil {
+		seen = make(map[string]int64)
+		b.seenTable[id] = seen
+	}
+	b.lock.Unlock()
+	if last == 0 {
+		last = now.Unix() - int64(interval/time.Second)
+	}
+	var newest int64
+	offset := ""
+	for page := 0; page < b.maxPages; page++ {
+		items, next, hasMore, err := b.spacePage(id, offset)
+		if err != nil {
+			if page == 0 {
+				return nil, err
+			}
+			logger.WithFields(logrus.Fields{
+				"mid":  id,
+				"page": page,
+				"err":  err,
+			}).Warn("[BiliDyn]翻页获取动态失败")
+			break
+		}
+		pageInfos, pageNewest, reached := b.filter(id, items, last, seen)
+		infos = append(infos, pageInfos...)
+		newest = max(newest, pageNewest)
+		if reached || !hasMore || next == "" {
+			break
+		}
+		offset = next
+	}
+	last = max(last, newest)
+	b.lock.Lock()
+	b.lastTable[id] = last
+	b.lock.Unlock()
+	//只需要保留和最新发布时间同一秒的动态
+	for k, v := range seen {
+		if v < last {
+			delete(seen, k)
+		}
+	}
+	return infos, nil
+}
+
+// 过滤一页中已经推送过的动态，返回新动态和其中最新的发布时间，reached表示是否遇到了已经推送过的动态
+func (b *BiliDynamicSource) filter(id int64, items []gjson.Result, last int64,
+	seen map[string]int64) (infos []*DynamicInfo, newest int64, reached bool) {
+	for _, item := range items {
+		info := parseDynamic(&item)
+		if info == nil {
+			logger.WithFields(logrus.Fields{
+				"id": id,
+			}).Warn("[BiliDyn]解析的动态为nil")
+			continue
+		}
+		second := info.times.Unix()
+		//置顶动态的发布时间可能很早，不作为停止翻页的依据
+		if !info.pinned && (second < last || seen[info.dynId] != 0) {
+			reached = true
+		}
+		if info.types == DynamicTypeLiveRCMD {
+			logger.WithFields(logrus.Fields{
+				"mid":    id,
+				"author": info.author,
+				"types":  info.types,
+			}).Debug("[BiliDyn]忽略开播动态")
+			info.Reset()
+			dynInfoPool.Put(info)
+			continue
+		}
+		if second >= last && seen[info.dynId] == 0 {
+			newest = max(newest, second)
+			seen[info.dynId] = second
+			infos = append(infos, info)
+		} else {
+			logger.WithFields(logrus.Fields{
+				"mid":    id,
+				"src":    info.src,
+				"pinned": info.pinned,
+			}).Debug("[BiliDyn]过滤动态")
+			info.Reset()
+			dynInfoPool.Put(info)
+		}
+	}
+	return infos, newest, reached
+}
+
+// 获取一页动态，返回动态列表和下一页的offset
+func (b *BiliDynamicSource) spacePage(id int64, offset string) (items []gjson.Result, next string, hasMore bool, err error) {
+	resp, err := biliClient.Get(spaceUrl, req.D{
+		{"offset", offset},
 		{"host_mid", id},
 		{"timezone_offset", "-480"},
-	})
+		{"features", "itemOpusStyle"},
+	}, true)
 	if err != nil {
-		return nil, err
+		return nil, "", false, err
 	}
 
 	result, err := checkResp(resp)
 	if err != nil {
-		return nil, errors.Wrap(err, "read bili resp data")
+		return nil, "", false, errors.Wrap(err, "read bili resp data")
 	}
 	data, code, msg := checkBiliData(result)
 	if code != 0 {
-		return nil, errors.New(msg)
+		return nil, "", false, errors.New(msg)
 	}
 	dyns := data.Get("items")
 	if !dyns.Exists() || !dyns.IsArray() {
@@ -374,48 +691,9 @@ func (b *BiliDynamicSource) space(id int64, now time.Time) (infos []*DynamicInfo
 			"mid":  id,
 			"resp": data.String(),
 		}).Error("[BiliDyn]获取items失败")
-		return nil, errors.New("不存在data.items字段")
+		return nil, "", false, errors.New("不存在data.items字段")
 	}
-	items := dyns.Array()
-
-	infos = make([]*DynamicInfo, 0, len(items))
-	var newest int64
-	last := b.lastTable[id]
-	if last == 0 {
-		last = now.Unix() - int64(interval/time.Second)
-	}
-	for _, item := range items {
-		info := parseDynamic(&item)
-		if info != nil {
-			if info.types == DynamicTypeLiveRCMD {
-				logger.WithFields(logrus.Fields{
-					"mid":    id,
-					"author": info.author,
-					"types":  info.types,
-				}).Debug("[BiliDyn]忽略开播动态")
-				continue
-			}
-			second := info.times.Unix()
-			newest = max(newest, second)
-			if second > last {
-				infos = append(infos, info)
-			} else {
-				logger.WithFields(logrus.Fields{
-					"mid": id,
-					"src": info.src,
-				}).Debug("[BiliDyn]过滤动态")
-				info.Reset()
-				dynInfoPool.Put(info)
-			}
-		} else {
-			logger.WithFields(logrus.Fields{
-				"id": id,
-			}).Warn("[BiliDyn]解析的动态为nil")
-		}
-	}
-	last = max(last, newest)
-	b.lastTable[id] = last
-	return infos, nil
+	return dyns.Array(), data.Get("offset").String(), data.Get("has_more").Bool(), nil
 }
 
 func max[T int64 | int | int32 | int8 | int16](a, b T) T {
@@ -429,7 +707,9 @@ func parseDynamic(item *gjson.Result) *DynamicInfo {
 	types := item.Get("type").String()
 	info := dynInfoPool.Get().(*DynamicInfo)
 	info.id = item.Get("id_str").String()
+	info.dynId = info.id
 	info.src = dynamicUrlPrefix + info.id
+	info.pinned = item.Get("modules.module_tag.text").String() == "置顶"
 
 	author := item.Get("modules.module_author")
 	info.author = author.Get("name").String()
@@ -441,12 +721,12 @@ func parseDynamic(item *gjson.Result) *DynamicInfo {
 		return nil
 	}
 	switch types {
-	case DynamicTypeWord:
-		info.types = "发布动态"
-		info.text = dynamic.Get("desc.text").String()
-	case DynamicTypeDraw:
+	case DynamicTypeWord, DynamicTypeDraw:
 		info.types = "发布动态"
-		info.text = dynamic.Get("desc.text").String()
+		if parseOpus(&dynamic, info) {
+			break
+		}
+		info.text = dynamicDesc(&dynamic)
 		img := dynamic.Get("major.draw.items").Array()
 		for i := range img {
 			info.img = append(info.img, img[i].Get("src").String())
@@ -460,10 +740,13 @@ func parseDynamic(item *gjson.Result) *DynamicInfo {
 		desc := archive.Get("desc").String()
 		title := archive.Get("title").String()
 		info.text = fmt.Sprintf("%s\n%s", title, desc)
+		if text := dynamicDesc(&dynamic); text != "" {
+			info.text = fmt.Sprintf("%s\n%s", text, info.text)
+		}
 		info.img = []string{archive.Get("cover").String()}
 	case DynamicTypeForward:
 		info.types = "转发动态"
-		text := dynamic.Get("desc.text").String()
+		text := dynamicDesc(&dynamic)
 		orig := item.Get("orig")
 		origInfo := parseDynamic(&orig)
 		if origInfo == nil {
@@ -472,13 +755,25 @@ func parseDynamic(item *gjson.Result) *DynamicInfo {
 		if origInfo.types == DynamicTypeLiveRCMD || origInfo.types == DynamicTypeLive {
 			info.types = "分享直播间"
 			info.text = fmt.Sprintf("%s\n分享\"%s\"的直播间\n%s", text, origInfo.author, origInfo.text)
+		} else if origInfo.types == DynamicTypeNone {
+			info.text = fmt.Sprintf("%s \n%s", text, origInfo.text)
 		} else {
 			info.text = fmt.Sprintf("%s \n转发自：@%s\n%s", text, origInfo.author, origInfo.text)
 		}
+		//原动态的链接用于和原动态去重
+		info.orig = origInfo.src
 		info.img = origInfo.img
+		origInfo.Reset()
+		dynInfoPool.Put(origInfo)
 	case DynamicTypeArticle:
 		info.types = "投稿专栏"
 		article := dynamic.Get("major.article")
+		if !article.Exists() && parseOpus(&dynamic, info) {
+			if link := dynamic.Get("major.opus.jump_url").String(); link != "" {
+				info.src = biliUrl(link)
+			}
+			break
+		}
 		info.id = strconv.FormatInt(article.Get("id").Int(), 10)
 		info.src = articleUrlPrefix + info.id
 		desc := article.Get("desc").String()
@@ -492,10 +787,24 @@ func parseDynamic(item *gjson.Result) *DynamicInfo {
 		info.src = musicUrlPrefix + info.id
 		info.text = music.Get("title").String()
 		info.img = []string{music.Get("cover").String()}
-	case DynamicTypePGC:
+	case DynamicTypePGC, DynamicTypePGCUnion:
 		pgc := dynamic.Get("major.pgc")
-		info.text = pgc.Get("title").String()
-		info.img = []string{pgc.Get("cover").String()}
+		parseCard(pgc, info, "番剧更新")
+	case DynamicTypeUGC:
+		info.text = dynamicDesc(&dynamic)
+		parseCard(dynamic.Get("major.ugc_season"), info, "合集更新")
+	case DynamicTypeCourses:
+		info.text = dynamicDesc(&dynamic)
+		parseCard(dynamic.Get("major.courses"), info, "发布课程")
+	case DynamicTypeCommon, DynamicTypeCommonV:
+		info.text = dynamicDesc(&dynamic)
+		parseCard(dynamic.Get("major.common"), info, "发布动态")
+	case DynamicTypeMedia:
+		info.text = dynamicDesc(&dynamic)
+		parseCard(dynamic.Get("major.medialist"), info, "分享收藏夹")
+	case DynamicTypeNone:
+		info.types = DynamicTypeNone
+		info.text = dynamic.Get("major.none.tips").String()
 	case DynamicTypeLiveRCMD:
 		info.types = DynamicTypeLiveRCMD
 		content := dynamic.Get("major.live_rcmd.content").String()
@@ -505,6 +814,10 @@ func parseDynamic(item *gjson.Result) *DynamicInfo {
 		liveInfo := gjson.Get(content, "live_play_info")
 		info.text = fmt.Sprintf("标题：\"%s\"", liveInfo.Get("title").String())
 		info.img = []string{liveInfo.Get("cover").String()}
+		if id := liveInfo.Get("room_id").Int(); id != 0 {
+			info.text = fmt.Sprintf("%s\n%s%d", info.text, liveUrlPrefix, id)
+		}
+		return info
 	case DynamicTypeLive:
 		info.types = DynamicTypeLive
 		live := dynamic.Get("major.live")
@@ -513,6 +826,9 @@ func parseDynamic(item *gjson.Result) *DynamicInfo {
 		}
 		info.text = fmt.Sprintf(`标题："%s"`, live.Get("title").String())
 		info.img = []string{live.Get("cover").String()}
+		if id := live.Get("id").Int(); id != 0 {
+			info.text = fmt.Sprintf("%s\n%s%d", info.text, liveUrlPrefix, id)
+		}
 	default:
 		info.types = "发布动态"
 		info.text = "未处理的动态类型"
@@ -520,5 +836,8 @@ func parseDynamic(item *gjson.Result) *DynamicInfo {
 			"resp": item.String(),
 		}).Warn("[BiliDyn]未处理的动态类型")
 	}
+	if text := parseAdditional(&dynamic); text != "" {
+		info.text = fmt.Sprintf("%s\n%s", info.text, text)
+	}
 	return info
 }
@@ -1 +1,158 @@
 package forwardBot
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBiliLiveSource_InfoChanged(t *testing.T) {
+	b := NewBiliLiveSource([]int{1})
+	b.WatchInfoChange(1)
+	now := time.Now()
+	info := &LiveInfo{Uname: "up", Title: "杂谈", Area: "娱乐-视频唱见", Cover: "a.jpg"}
+	assert.Nil(t, b.infoChanged(1, info, now), "第一次获取只做记录")
+	assert.Nil(t, b.infoChanged(1, info, now))
+
+	info.Title = "玩游戏"
+	info.Area = "网游-英雄联盟"
+	msg := b.infoChanged(1, info, now)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, BiliLiveInfoMsg, msg.Flag)
+		assert.Equal(t, "直播间信息变更", msg.Title)
+		assert.Equal(t, "标题：\"杂谈\" -> \"玩游戏\"\n分区：\"娱乐-视频唱见\" -> \"网游-英雄联盟\"", msg.Text)
+		assert.Empty(t, msg.Img)
+	}
+
+	info.Cover = "b.jpg"
+	msg = b.infoChanged(1, info, now)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "封面已更新", msg.Text)
+		assert.Equal(t, []string{"b.jpg"}, msg.Img)
+	}
+}
+
+func TestParseRoomStatus(t *testing.T) {
+	data := gjson.Parse(`{
+		"672342685": {"title": "杂谈", "room_id": 22625027, "uid": 672342685, "live_status": 1,
+			"uname": "up", "area_v2_name": "虚拟主播", "area_v2_parent_name": "虚拟主播", "cover_from_user": "a.jpg"},
+		"672353429": {"title": "", "room_id": 22632424, "uid": 672353429, "live_status": 2,
+			"uname": "up2", "area_v2_name": "", "area_v2_parent_name": ""}
+	}`)
+	infos := parseRoomStatus(&data)
+	if assert.Len(t, infos, 2) {
+		assert.True(t, infos[672342685].LiveStatus)
+		assert.Equal(t, "虚拟主播-虚拟主播", infos[672342685].Area)
+		assert.Equal(t, "a.jpg", infos[672342685].Cover)
+		assert.False(t, infos[672353429].LiveStatus, "轮播不算开播")
+		assert.Equal(t, "", infos[672353429].Area)
+	}
+	empty := gjson.Parse(`[]`)
+	assert.Empty(t, parseRoomStatus(&empty))
+}
+
+func TestBiliDynamicSource_Filter(t *testing.T) {
+	b := NewBiliDynamicSource([]int64{1})
+	item := func(id string, ts int64, pinned bool) gjson.Result {
+		tag := ""
+		if pinned {
+			tag = `"module_tag": {"text": "置顶"},`
+		}
+		return gjson.Parse(fmt.Sprintf(`{"id_str": "%s", "type": "DYNAMIC_TYPE_WORD", "modules": {%s
+			"module_author": {"name": "up", "pub_ts": %d},
+			"module_dynamic": {"desc": {"text": "%s"}}}}`, id, tag, ts, id))
+	}
+	seen := make(map[string]int64)
+	infos, newest, reached := b.filter(1, []gjson.Result{
+		item("old-pinned", 100, true),
+		item("b", 1000, false),
+		item("a", 1000, false),
+		item("old", 900, false),
+	}, 1000, seen)
+	assert.True(t, reached)
+	assert.Equal(t, int64(1000), newest)
+	if assert.Len(t, infos, 2) {
+		assert.Equal(t, "b", infos[0].dynId)
+		assert.Equal(t, "a", infos[1].dynId)
+	}
+
+	//同一秒发布的新动态不会被过滤
+	infos, _, reached = b.filter(1, []gjson.Result{
+		item("old-pinned", 100, true),
+		item("c", 1000, false),
+		item("b", 1000, false),
+	}, 1000, seen)
+	assert.True(t, reached)
+	if assert.Len(t, infos, 1) {
+		assert.Equal(t, "c", infos[0].dynId)
+	}
+
+	//置顶的旧动态不会停止翻页
+	infos, _, reached = b.filter(1, []gjson.Result{
+		item("old-pinned", 100, true),
+		item("d", 1001, false),
+	}, 1000, seen)
+	assert.False(t, reached)
+	assert.Len(t, infos, 1)
+}
+
+// testdata/bili中的数据是按照web-dynamic接口的结构手工编写的，不是接口的真实响应，
+// 需要替换为裁剪后的真实响应
+func TestParseDynamic(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		types  string
+		text   string
+		img    []string
+		src    string
+		pinned bool
+	}{
+		{"case rich text", "rich_text.json", "发布动态",
+			"#虚拟主播#\n今天和@嘉然今天吃什么 一起玩游戏[doge]\n#A-SOUL#网页链接(https://www.bilibili.com/blackboard/activity.html)",
+			nil, "https://t.bilibili.com/715012345678901234", false},
+		{"case opus", "opus.json", "发布动态", "周末\n周末的照片[微笑]",
+			[]string{"https://i0.hdslb.com/bfs/new_dyn/1.jpg", "https://i0.hdslb.com/bfs/new_dyn/2.jpg"},
+			"https://t.bilibili.com/715012345678901235", true},
+		{"case reserve", "reserve.json", "发布动态",
+			"明天晚上见\n预约：直播预约：生日会\n10-16 20:00 直播\n1234人预约\nhttps://space.bilibili.com/672342685",
+			nil, "https://t.bilibili.com/715012345678901236", false},
+		{"case vote", "vote.json", "发布动态",
+			"大家来投票下次直播玩什么\n投票：下次直播玩什么（256人参与）\n截止时间：" +
+				time.Unix(1666008000, 0).Format("2006-01-02 15:04"),
+			nil, "https://t.bilibili.com/715012345678901237", false},
+		{"case ugc season", "ugc_season.json", "合集更新", "直播切片合集\n第10集",
+			[]string{"http://i0.hdslb.com/bfs/archive/cover.jpg"}, "https://www.bilibili.com/video/av345678901/", false},
+		{"case courses", "courses.json", "发布课程", "唱歌入门\n从零开始的唱歌课\n更新至第3期",
+			[]string{"http://i0.hdslb.com/bfs/bangumi/course.jpg"}, "https://m.bilibili.com/cheese/play/ss1234", false},
+		{"case common square", "common_square.json", "发布动态",
+			"新装扮上线啦\n乃琳生日装扮\n生日装扮\nUP主的推荐\n生日纪念徽章 39.90",
+			[]string{"https://i0.hdslb.com/bfs/garb/cover.png"},
+			"https://www.bilibili.com/h5/mall/suit/detail?id=12345", false},
+		{"case pgc", "pgc.json", "番剧更新", "第3话 新的开始",
+			[]string{"http://i0.hdslb.com/bfs/archive/pgc.jpg"}, "https://www.bilibili.com/bangumi/play/ep654321", false},
+		{"case forward deleted", "forward_deleted.json", "转发动态", "哈哈哈 \n源动态已被作者删除",
+			nil, "https://t.bilibili.com/715012345678901242", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/bili/" + test.file)
+			if !assert.Nil(t, err) {
+				return
+			}
+			item := gjson.ParseBytes(data)
+			info := parseDynamic(&item)
+			if !assert.NotNil(t, info) {
+				return
+			}
+			assert.Equal(t, test.types, info.types)
+			assert.Equal(t, test.text, info.text)
+			assert.Equal(t, test.img, info.img)
+			assert.Equal(t, test.src, info.src)
+			assert.Equal(t, test.pinned, info.pinned)
+		})
+	}
+}
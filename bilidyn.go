@@ -0,0 +1,160 @@
+package forwardBot
+
+import (
+	"fmt"
+	"github.com/tidwall/gjson"
+	"strings"
+	"time"
+)
+
+const (
+	richTextAt      = "RICH_TEXT_NODE_TYPE_AT"      //@用户
+	richTextWeb     = "RICH_TEXT_NODE_TYPE_WEB"     //网页链接
+	richTextBV      = "RICH_TEXT_NODE_TYPE_BV"      //视频
+	richTextLottery = "RICH_TEXT_NODE_TYPE_LOTTERY" //互动抽奖
+	richTextVote    = "RICH_TEXT_NODE_TYPE_VOTE"    //投票
+	richTextGoods   = "RICH_TEXT_NODE_TYPE_GOODS"   //商品
+)
+
+const (
+	additionalReserve = "ADDITIONAL_TYPE_RESERVE" //直播、视频预约
+	additionalVote    = "ADDITIONAL_TYPE_VOTE"    //投票
+	additionalUGC     = "ADDITIONAL_TYPE_UGC"     //视频
+	additionalGoods   = "ADDITIONAL_TYPE_GOODS"   //商品
+	additionalCommon  = "ADDITIONAL_TYPE_COMMON"  //游戏、装扮等
+)
+
+// 将b站返回的"//www.bilibili.com"形式的链接补全为https链接
+func biliUrl(link string) string {
+	if strings.HasPrefix(link, "//") {
+		return "https:" + link
+	}
+	return link
+}
+
+// 将rich_text_nodes渲染为文本，不存在rich_text_nodes时使用text字段
+// 表情和话题的text已经是"[doge]"、"#话题#"形式的文字，和普通文字一样处理
+func richText(r gjson.Result) string {
+	nodes := r.Get("rich_text_nodes")
+	if !nodes.IsArray() || len(nodes.Array()) == 0 {
+		return r.Get("text").String()
+	}
+	text := strings.Builder{}
+	for _, node := range nodes.Array() {
+		s := node.Get("text").String()
+		switch node.Get("type").String() {
+		case richTextAt:
+			if !strings.HasPrefix(s, "@") {
+				text.WriteByte('@')
+			}
+			text.WriteString(s)
+		case richTextWeb, richTextBV, richTextLottery, richTextVote, richTextGoods:
+			text.WriteString(s)
+			if link := node.Get("jump_url").String(); link != "" {
+				text.WriteString(fmt.Sprintf("(%s)", biliUrl(link)))
+			}
+		default:
+			text.WriteString(s)
+		}
+	}
+	return text.String()
+}
+
+// 动态的文字部分，包括话题和正文
+func dynamicDesc(dynamic *gjson.Result) string {
+	text := richText(dynamic.Get("desc"))
+	if topic := dynamic.Get("topic.name").String(); topic != "" {
+		text = fmt.Sprintf("#%s#\n%s", topic, text)
+	}
+	return text
+}
+
+// 解析图文动态major.opus，不存在时返回false
+func parseOpus(dynamic *gjson.Result, info *DynamicInfo) bool {
+	opus := dynamic.Get("major.opus")
+	if !opus.Exists() || !opus.IsObject() {
+		return false
+	}
+	text := richText(opus.Get("summary"))
+	if title := opus.Get("title").String(); title != "" {
+		text = fmt.Sprintf("%s\n%s", title, text)
+	}
+	info.text = text
+	for _, pic := range opus.Get("pics").Array() {
+		info.img = append(info.img, pic.Get("url").String())
+	}
+	return true
+}
+
+// 解析major中带有title、desc、cover和jump_url的卡片，例如合集、课程
+func parseCard(card gjson.Result, info *DynamicInfo, types string) {
+	info.types = types
+	text := card.Get("title").String()
+	if sub := card.Get("sub_title").String(); sub != "" {
+		text = fmt.Sprintf("%s\n%s", text, sub)
+	}
+	if desc := card.Get("desc").String(); desc != "" {
+		text = fmt.Sprintf("%s\n%s", text, desc)
+	}
+	if info.text != "" {
+		info.text = fmt.Sprintf("%s\n%s", info.text, text)
+	} else {
+		info.text = text
+	}
+	if cover := card.Get("cover").String(); cover != "" {
+		info.img = []string{cover}
+	}
+	if link := card.Get("jump_url").String(); link != "" {
+		info.src = biliUrl(link)
+	}
+}
+
+// 解析动态中的附加卡片，例如预约、投票，返回需要附加到动态内容后的文本
+func parseAdditional(dynamic *gjson.Result) string {
+	additional := dynamic.Get("additional")
+	if !additional.Exists() || !additional.IsObject() {
+		return ""
+	}
+	switch additional.Get("type").String() {
+	case additionalReserve:
+		reserve := additional.Get("reserve")
+		text := fmt.Sprintf("预约：%s", reserve.Get("title").String())
+		for _, desc := range []string{"desc1.text", "desc2.text"} {
+			if s := reserve.Get(desc).String(); s != "" {
+				text = fmt.Sprintf("%s\n%s", text, s)
+			}
+		}
+		if link := reserve.Get("jump_url").String(); link != "" {
+			text = fmt.Sprintf("%s\n%s", text, biliUrl(link))
+		}
+		return text
+	case additionalVote:
+		vote := additional.Get("vote")
+		text := fmt.Sprintf("投票：%s（%d人参与）", vote.Get("desc").String(), vote.Get("join_num").Int())
+		if end := vote.Get("end_time").Int(); end != 0 {
+			text = fmt.Sprintf("%s\n截止时间：%s", text, time.Unix(end, 0).Format("2006-01-02 15:04"))
+		}
+		return text
+	case additionalUGC:
+		ugc := additional.Get("ugc")
+		return fmt.Sprintf("视频：%s\n%s", ugc.Get("title").String(), biliUrl(ugc.Get("jump_url").String()))
+	case additionalGoods:
+		goods := additional.Get("goods")
+		text := strings.Builder{}
+		text.WriteString(goods.Get("head_text").String())
+		for _, item := range goods.Get("items").Array() {
+			text.WriteString(fmt.Sprintf("\n%s %s", item.Get("name").String(), item.Get("price").String()))
+		}
+		return text.String()
+	case additionalCommon:
+		common := additional.Get("common")
+		text := common.Get("title").String()
+		for _, desc := range []string{"desc1", "desc2"} {
+			if s := common.Get(desc).String(); s != "" {
+				text = fmt.Sprintf("%s\n%s", text, s)
+			}
+		}
+		return text
+	}
+	return ""
+}
@@ -0,0 +1,326 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"forwardBot/push"
+	"forwardBot/req"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	videoListUrl   = "https://api.bilibili.com/x/space/wbi/arc/search"
+	videoDetailUrl = "https://api.bilibili.com/x/web-interface/view/detail"
+	videoPageSize  = 30
+)
+
+var _ Source = (*BiliVideoSource)(nil)
+var _ Named = (*BiliVideoSource)(nil)
+
+// BiliVideoSource 获取b站用户的视频投稿，推送新视频和播放量里程碑
+type BiliVideoSource struct {
+	health
+	uid        []int64
+	milestones []int64                    //播放量里程碑，升序
+	lastTable  map[int64]int64            //每个用户已经推送的最新投稿时间
+	seenTable  map[int64]map[string]int64 //每个用户已经推送的bvid和投稿时间，用于过滤同一秒投稿的视频
+	tracked    map[string]*trackedVideo   //最近投稿的视频，用于判断播放量里程碑
+	lock       sync.Mutex                 //保护lastTable、seenTable和tracked
+	sched      *scheduler
+	//获取视频详情，测试时可以替换
+	videoInfo func(bvid string) (*VideoInfo, error)
+}
+
+// trackedVideo 记录视频已经达到的播放量里程碑
+type trackedVideo struct {
+	mid     int64
+	title   string
+	author  string
+	reached int //已经达到的里程碑数量
+}
+
+// VideoInfo 视频信息
+type VideoInfo struct {
+	Bvid     string
+	Title    string
+	Desc     string
+	Cover    string
+	Author   string
+	Created  time.Time
+	Duration int64    //时长，单位秒
+	Area     string   //分区
+	Tags     []string //标签
+	Season   string   //所属合集
+	View     int64    //播放量
+}
+
+func NewBiliVideoSource(uid []int64, milestones []int64) *BiliVideoSource {
+	logger.WithFields(logrus.Fields{
+		"uid":        uid,
+		"milestones": milestones,
+	}).Info("[BiliVideo]监控b站视频投稿")
+	m := append([]int64{}, milestones...)
+	sort.Slice(m, func(i, j int) bool {
+		return m[i] < m[j]
+	})
+	return &BiliVideoSource{
+		uid:        uid,
+		milestones: m,
+		lastTable:  make(map[int64]int64),
+		seenTable:  make(map[int64]map[string]int64),
+		tracked:    make(map[string]*trackedVideo),
+		sched:      newScheduler("[BiliVideo]", biliApiHost, interval),
+		videoInfo:  getVideoInfo,
+	}
+}
+
+func (b *BiliVideoSource) Name() string {
+	return "[BiliVideo]"
+}
+
+func (b *BiliVideoSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	schedule(ctx, b.sched, b.uid, func(id int64, now time.Time) {
+		msgs, err := b.videos(id, now)
+		if err != nil {
+			b.fail(id, err)
+			logger.WithFields(logrus.Fields{
+				"id":  id,
+				"err": err,
+			}).Error("[BiliVideo]获取b站视频投稿失败")
+			return
+		}
+		b.succeed(id)
+		for _, msg := range msgs {
+			ch <- msg
+		}
+	})
+	logger.Info("[BiliVideo]停止b站视频投稿监控")
+}
+
+// SetInterval 设置轮询间隔，users为单独设置间隔的uid，必须在Send方法之前调用
+func (b *BiliVideoSource) SetInterval(d time.Duration, users map[string]time.Duration) {
+	b.sched.setInterval(d, users)
+}
+
+// 获取用户最近投稿的视频，返回新投稿和达到播放量里程碑的消息
+func (b *BiliVideoSource) videos(id int64, now time.Time) (msgs []*push.Msg, err error) {
+	resp, err := biliClient.Get(videoListUrl, req.D{
+		{"mid", id},
+		{"ps", videoPageSize},
+		{"pn", 1},
+		{"order", "pubdate"},
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+	result, err := checkResp(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "read bili resp data fail")
+	}
+	data, code, msg := checkBiliData(result)
+	if code != 0 {
+		return nil, errors.New(fmt.Sprintf("code=%d,msg=%s", code, msg))
+	}
+	vlist := data.Get("list.vlist")
+	if !vlist.IsArray() {
+		logger.WithFields(logrus.Fields{
+			"mid":  id,
+			"resp": data.String(),
+		}).Error("[BiliVideo]获取list.vlist失败")
+		return nil, errors.New("不存在data.list.vlist字段")
+	}
+	return b.filter(id, vlist.Array(), now), nil
+}
+
+// 过滤已经推送过的视频，返回新投稿和达到播放量里程碑的消息
+func (b *BiliVideoSource) filter(id int64, vlist []gjson.Result, now time.Time) (msgs []*push.Msg) {
+	//同一个id不会同时轮询，seen只会被一个goroutine使用
+	b.lock.Lock()
+	last, inited := b.lastTable[id]
+	seen := b.seenTable[id]
+	if seen == nil {
+		seen = make(map[string]int64)
+		b.seenTable[id] = seen
+	}
+	b.lock.Unlock()
+	newest := last
+	current := make(map[string]bool)
+	for _, item := range vlist {
+		bvid := item.Get("bvid").String()
+		current[bvid] = true
+		created := item.Get("created").Int()
+		view := item.Get("play").Int()
+		newest = max(newest, created)
+		if !inited || created < last || seen[bvid] != 0 {
+			if created >= last {
+				seen[bvid] = created
+			}
+			if m := b.milestone(id, bvid, item.Get("title").String(), item.Get("author").String(), view, now); m != nil {
+				msgs = append(msgs, m)
+			}
+			continue
+		}
+		seen[bvid] = created
+		info, err := b.videoInfo(bvid)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"bvid": bvid,
+				"err":  err,
+			}).Warn("[BiliVideo]获取视频详情失败，使用列表中的信息")
+			info = &VideoInfo{
+				Bvid:    bvid,
+				Title:   item.Get("title").String(),
+				Desc:    item.Get("description").String(),
+				Cover:   item.Get("pic").String(),
+				Author:  item.Get("author").String(),
+				Created: time.Unix(created, 0),
+				View:    view,
+			}
+		}
+		logger.WithFields(logrus.Fields{
+			"mid":   id,
+			"bvid":  bvid,
+			"title": info.Title,
+		}).Debug("[BiliVideo]新投稿")
+		msgs = append(msgs, videoMsg(info))
+		b.milestone(id, bvid, info.Title, info.Author, info.View, now)
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.lastTable[id] = newest
+	//只需要保留和最新投稿时间同一秒的视频
+	for bvid, created := range seen {
+		if created < newest {
+			delete(seen, bvid)
+		}
+	}
+	//只记录最近投稿的视频
+	for bvid, v := range b.tracked {
+		if v.mid == id && !current[bvid] {
+			delete(b.tracked, bvid)
+		}
+	}
+	return msgs
+}
+
+// 更新视频达到的播放量里程碑，第一次记录或者没有达到新的里程碑时返回nil
+func (b *BiliVideoSource) milestone(mid int64, bvid, title, author string, view int64, now time.Time) *push.Msg {
+	if len(b.milestones) == 0 {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	reached := sort.Search(len(b.milestones), func(i int) bool {
+		return b.milestones[i] > view
+	})
+	v, ok := b.tracked[bvid]
+	if !ok {
+		b.tracked[bvid] = &trackedVideo{
+			mid:     mid,
+			title:   title,
+			author:  author,
+			reached: reached,
+		}
+		return nil
+	}
+	if reached <= v.reached {
+		return nil
+	}
+	v.reached = reached
+	return &push.Msg{
+		Times:  now,
+		Flag:   BiliVideoMsg,
+		Author: v.author,
+		Title:  fmt.Sprintf("视频播放量突破%d", b.milestones[reached-1]),
+		Text:   fmt.Sprintf("%s\n当前播放量：%d", v.title, view),
+		Src:    videoUrlPrefix + bvid,
+	}
+}
+
+// 获取视频的详细信息，包括分区、标签和合集
+func getVideoInfo(bvid string) (*VideoInfo, error) {
+	resp, err := biliClient.Get(videoDetailUrl, req.D{{"bvid", bvid}}, false)
+	if err != nil {
+		return nil, err
+	}
+	result, err := checkResp(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "read bili resp data fail")
+	}
+	data, code, msg := checkBiliData(result)
+	if code != 0 {
+		return nil, errors.New(fmt.Sprintf("code=%d,msg=%s", code, msg))
+	}
+	return parseVideoDetail(data)
+}
+
+func parseVideoDetail(data *gjson.Result) (*VideoInfo, error) {
+	view := data.Get("View")
+	if !view.IsObject() {
+		return nil, errors.New("不存在data.View字段")
+	}
+	info := &VideoInfo{
+		Bvid:     view.Get("bvid").String(),
+		Title:    view.Get("title").String(),
+		Desc:     view.Get("desc").String(),
+		Cover:    view.Get("pic").String(),
+		Author:   view.Get("owner.name").String(),
+		Created:  time.Unix(view.Get("pubdate").Int(), 0),
+		Duration: view.Get("duration").Int(),
+		Area:     view.Get("tname").String(),
+		Season:   view.Get("ugc_season.title").String(),
+		View:     view.Get("stat.view").Int(),
+	}
+	for _, tag := range data.Get("Tags").Array() {
+		info.Tags = append(info.Tags, tag.Get("tag_name").String())
+	}
+	return info, nil
+}
+
+func videoMsg(info *VideoInfo) *push.Msg {
+	text := strings.Builder{}
+	text.WriteString(info.Title)
+	if info.Duration != 0 {
+		text.WriteString(fmt.Sprintf("\n时长：%s", formatSeconds(info.Duration)))
+	}
+	if info.Area != "" {
+		text.WriteString(fmt.Sprintf("\n分区：%s", info.Area))
+	}
+	if len(info.Tags) != 0 {
+		text.WriteString(fmt.Sprintf("\n标签：%s", strings.Join(info.Tags, "、")))
+	}
+	if info.Season != "" {
+		text.WriteString(fmt.Sprintf("\n合集：%s", info.Season))
+	}
+	if info.Desc != "" {
+		text.WriteString("\n")
+		text.WriteString(info.Desc)
+	}
+	msg := &push.Msg{
+		Times:  info.Created,
+		Flag:   BiliVideoMsg,
+		Author: info.Author,
+		Title:  "投稿视频",
+		Text:   text.String(),
+		Src:    videoUrlPrefix + info.Bvid,
+	}
+	if info.Cover != "" {
+		msg.Img = []string{info.Cover}
+	}
+	return msg
+}
+
+// 将秒数格式化为"时:分:秒"或"分:秒"
+func formatSeconds(sec int64) string {
+	h, m, s := sec/3600, sec%3600/60, sec%60
+	if h != 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
@@ -0,0 +1,72 @@
+package forwardBot
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVideoDetail(t *testing.T) {
+	data := gjson.Parse(`{
+		"View": {"bvid": "BV1xx411c7mD", "title": "生日会切片", "desc": "简介", "pic": "cover.jpg",
+			"owner": {"mid": 672342685, "name": "乃琳Queen"}, "pubdate": 1665835392, "duration": 3725,
+			"tname": "虚拟UP主", "ugc_season": {"id": 1, "title": "直播切片"}, "stat": {"view": 12345}},
+		"Tags": [{"tag_name": "虚拟主播"}, {"tag_name": "A-SOUL"}]
+	}`)
+	info, err := parseVideoDetail(&data)
+	if !assert.Nil(t, err) {
+		return
+	}
+	msg := videoMsg(info)
+	assert.Equal(t, BiliVideoMsg, msg.Flag)
+	assert.Equal(t, "乃琳Queen", msg.Author)
+	assert.Equal(t, "生日会切片\n时长：1:02:05\n分区：虚拟UP主\n标签：虚拟主播、A-SOUL\n合集：直播切片\n简介", msg.Text)
+	assert.Equal(t, []string{"cover.jpg"}, msg.Img)
+	assert.Equal(t, "https://www.bilibili.com/video/BV1xx411c7mD", msg.Src)
+}
+
+func TestBiliVideoSource_Milestone(t *testing.T) {
+	b := NewBiliVideoSource([]int64{1}, []int64{100000, 10000})
+	now := time.Now()
+	assert.Nil(t, b.milestone(1, "BV1", "title", "up", 5000, now), "第一次只做记录")
+	assert.Nil(t, b.milestone(1, "BV1", "title", "up", 9999, now))
+	msg := b.milestone(1, "BV1", "title", "up", 10001, now)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "视频播放量突破10000", msg.Title)
+	}
+	assert.Nil(t, b.milestone(1, "BV1", "title", "up", 20000, now))
+	msg = b.milestone(1, "BV1", "title", "up", 200000, now)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "视频播放量突破100000", msg.Title)
+	}
+}
+
+func TestBiliVideoSource_Filter(t *testing.T) {
+	b := NewBiliVideoSource([]int64{1}, nil)
+	b.videoInfo = func(bvid string) (*VideoInfo, error) {
+		return &VideoInfo{Bvid: bvid, Title: bvid, Author: "up"}, nil
+	}
+	now := time.Now()
+	tests := []struct {
+		name  string
+		vlist string
+		want  []string
+	}{
+		{"case init", `[{"bvid":"BV1","created":100}]`, nil},
+		{"case new", `[{"bvid":"BV2","created":200},{"bvid":"BV1","created":100}]`, []string{"BV2"}},
+		{"case same second", `[{"bvid":"BV3","created":200},{"bvid":"BV2","created":200},{"bvid":"BV1","created":100}]`,
+			[]string{"BV3"}},
+		{"case no update", `[{"bvid":"BV3","created":200},{"bvid":"BV2","created":200}]`, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bvids []string
+			for _, msg := range b.filter(1, gjson.Parse(test.vlist).Array(), now) {
+				bvids = append(bvids, strings.TrimPrefix(msg.Src, videoUrlPrefix))
+			}
+			assert.Equal(t, test.want, bvids)
+		})
+	}
+}
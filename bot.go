@@ -2,20 +2,75 @@ package forwardBot
 
 import (
 	"context"
+	"fmt"
 	"forwardBot/push"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
 )
 
 const (
 	BiliLiveMsg = iota
 	BiliDynMsg
 	TikTokLiveMsg
+	JSONPollMsg
+	WeiboMsg
+	DouyuLiveMsg
+	HuyaLiveMsg
+	BiliLiveInfoMsg
+	BiliSuperChatMsg
+	BiliGuardMsg
+	BiliVideoMsg
+	BiliFollowerMsg
+	TikTokPostMsg
+	BotAlertMsg
 )
 
+// 配置文件中使用的消息类型名称
+var flagNames = map[string]int{
+	"biliLive":      BiliLiveMsg,
+	"biliDynamic":   BiliDynMsg,
+	"tiktokLive":    TikTokLiveMsg,
+	"jsonPoll":      JSONPollMsg,
+	"weibo":         WeiboMsg,
+	"douyuLive":     DouyuLiveMsg,
+	"huyaLive":      HuyaLiveMsg,
+	"biliLiveInfo":  BiliLiveInfoMsg,
+	"biliSuperChat": BiliSuperChatMsg,
+	"biliGuard":     BiliGuardMsg,
+	"biliVideo":     BiliVideoMsg,
+	"biliFollower":  BiliFollowerMsg,
+	"tiktokPost":    TikTokPostMsg,
+	"botAlert":      BotAlertMsg,
+}
+
+// ParseFlags 把消息类型名称转换为消息类型
+func ParseFlags(names []string) ([]int, error) {
+	flags := make([]int, 0, len(names))
+	for _, name := range names {
+		flag, ok := flagNames[name]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("unknown msg type %s", name))
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
 type Bot struct {
-	sources []Source
-	sinks   []Sink
-	ch      chan *push.Msg
+	sources         []*sourceRunner
+	sinks           []*sinkWorker
+	ch              chan *push.Msg
+	lock            sync.Mutex
+	ctx             context.Context //Run的ctx，用于启动source，Run之前为nil
+	admin           Sink            //接收告警消息的sink，可以为nil
+	sinkPanics      map[string]int  //每个sink发生panic的次数
+	shutdownTimeout time.Duration   //停止时等待消息发送完成的时间
+	queueOpt        QueueOption     //AppendSink添加的sink使用的队列设置
+	dedup           *deduper        //为nil时不去重
 }
 
 func NewBot(buf int) *Bot {
@@ -23,14 +78,20 @@ func NewBot(buf int) *Bot {
 		"buf": buf,
 	}).Info("创建 bot")
 	return &Bot{
-		ch: make(chan *push.Msg, buf),
+		ch:              make(chan *push.Msg, buf),
+		sinkPanics:      make(map[string]int),
+		shutdownTimeout: defaultShutdownTimeout,
 	}
 }
 
 func (b *Bot) AppendSource(s ...Source) {
 	for _, source := range s {
 		if source != nil {
-			b.sources = append(b.sources, source)
+			r := newSourceRunner(uniqueName(nameOf(source), func(name string) bool {
+				return b.runner(name) != nil
+			}), source)
+			r.onPanic = b.alert
+			b.sources = append(b.sources, r)
 		} else {
 			logger.Warn("添加的Source为nil")
 		}
@@ -41,10 +102,11 @@ func (b *Bot) AppendSource(s ...Source) {
 	}).Debug("添加Source")
 }
 
+// AppendSink 添加sink，使用SetQueueOption设置的队列
 func (b *Bot) AppendSink(s ...Sink) {
 	for _, sink := range s {
 		if sink != nil {
-			b.sinks = append(b.sinks, sink)
+			b.appendSink(sink, b.queueOpt)
 		} else {
 			logger.Warn("添加的Sink为nil")
 		}
@@ -55,32 +117,221 @@ func (b *Bot) AppendSink(s ...Sink) {
 	}).Debug("添加Sink")
 }
 
+// AppendSinkWithQueue 添加使用单独的队列设置的sink
+func (b *Bot) AppendSinkWithQueue(s Sink, opt QueueOption) {
+	if s == nil {
+		logger.Warn("添加的Sink为nil")
+		return
+	}
+	b.appendSink(s, opt)
+}
+
+func (b *Bot) appendSink(s Sink, opt QueueOption) {
+	name := uniqueName(nameOf(s), func(name string) bool {
+		return b.sinkWorker(name) != nil
+	})
+	logger.WithFields(logrus.Fields{
+		"name":     name,
+		"capacity": opt.Capacity,
+		"overflow": opt.Overflow,
+	}).Debug("创建sink队列")
+	b.sinks = append(b.sinks, newSinkWorker(name, s, opt))
+}
+
+// SetQueueOption 设置AppendSink添加的sink使用的队列，必须在AppendSink之前调用
+func (b *Bot) SetQueueOption(opt QueueOption) {
+	b.queueOpt = opt
+}
+
+// EnableDedup 启用消息去重，必须在 Run方法之前调用
+func (b *Bot) EnableDedup(opt DedupOption) {
+	logger.WithFields(logrus.Fields{
+		"window": opt.Window,
+		"keys":   opt.Keys,
+	}).Info("启用消息去重")
+	b.dedup = newDeduper(opt)
+}
+
+// SinkStatus 所有sink的队列状态
+func (b *Bot) SinkStatus() []SinkStatus {
+	status := make([]SinkStatus, 0, len(b.sinks))
+	for _, w := range b.sinks {
+		status = append(status, w.snapshot())
+	}
+	return status
+}
+
+// SetAdminSink 设置接收告警消息的sink，source或sink发生panic时发送告警，必须在 Run方法之前调用
+func (b *Bot) SetAdminSink(s Sink) {
+	b.admin = s
+}
+
+// SetShutdownTimeout 设置停止时等待消息发送完成的时间，超时后没有发送的消息保存到持久化存储中
+func (b *Bot) SetShutdownTimeout(d time.Duration) {
+	if d > 0 {
+		b.shutdownTimeout = d
+	}
+}
+
+// SinkPanics 每个sink发生panic的次数
+func (b *Bot) SinkPanics() map[string]int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	panics := make(map[string]int, len(b.sinkPanics))
+	for name, n := range b.sinkPanics {
+		panics[name] = n
+	}
+	return panics
+}
+
+// 向管理员发送告警消息，告警的发送失败或者panic时只记录日志
+func (b *Bot) alert(name string, v any) {
+	if b.admin == nil {
+		return
+	}
+	go func() {
+		defer recoverPanic(context.Background(), "admin sink")
+		if err := b.admin.Receive(alertMsg(name, v)); err != nil {
+			logger.WithField("err", err).Error("发送告警消息失败")
+		}
+	}()
+}
+
+// 调用sink的Receive方法，发生panic时恢复并记录
+func (b *Bot) receive(w *sinkWorker, msg *push.Msg) {
+	defer func() {
+		if v := recover(); v != nil {
+			b.lock.Lock()
+			b.sinkPanics[w.name]++
+			b.lock.Unlock()
+			reportPanic(context.Background(), w.name, v, debug.Stack())
+			b.alert(w.name, v)
+		}
+	}()
+	err := w.sink.Receive(msg)
+	if err != nil {
+		logger.WithField("error", err).Error("bot发送消息失败")
+	}
+}
+
+// 名称重复时添加序号
+func uniqueName(name string, exists func(name string) bool) string {
+	unique := name
+	for i := 2; exists(unique); i++ {
+		unique = fmt.Sprintf("%s#%d", name, i)
+	}
+	return unique
+}
+
+func (b *Bot) sinkWorker(name string) *sinkWorker {
+	for _, w := range b.sinks {
+		if w.name == name {
+			return w
+		}
+	}
+	return nil
+}
+
+func (b *Bot) runner(name string) *sourceRunner {
+	for _, r := range b.sources {
+		if r.name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// StartSource 启动被停止的source，必须在Run方法之后调用
+func (b *Bot) StartSource(name string) error {
+	b.lock.Lock()
+	ctx := b.ctx
+	b.lock.Unlock()
+	if ctx == nil {
+		return errors.New("bot is not running")
+	}
+	r := b.runner(name)
+	if r == nil {
+		return errors.New(fmt.Sprintf("source %s not found", name))
+	}
+	return r.start(ctx, b.ch)
+}
+
+// StopSource 停止source并等待退出
+func (b *Bot) StopSource(name string) error {
+	r := b.runner(name)
+	if r == nil {
+		return errors.New(fmt.Sprintf("source %s not found", name))
+	}
+	return r.stop()
+}
+
+// Status 所有source的运行状态
+func (b *Bot) Status() []SourceStatus {
+	status := make([]SourceStatus, 0, len(b.sources))
+	for _, r := range b.sources {
+		status = append(status, r.snapshot())
+	}
+	return status
+}
+
+// liveRecorder 记录直播记录的source，内嵌liveTracker的source都实现了该接口
+type liveRecorder interface {
+	Sessions(id string) []LiveSession
+	Current(id string) (LiveSession, bool)
+}
+
+// LiveSessions 所有source中直播间id的直播记录，按开始时间升序，正在进行的直播End为零值
+func (b *Bot) LiveSessions(id string) []LiveSession {
+	var sessions []LiveSession
+	for _, r := range b.sources {
+		l, ok := r.source.(liveRecorder)
+		if !ok {
+			continue
+		}
+		sessions = append(sessions, l.Sessions(id)...)
+		if s, ok := l.Current(id); ok {
+			sessions = append(sessions, s)
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].Start.Before(sessions[j].Start)
+	})
+	return sessions
+}
+
+// Run 启动所有source并分发消息，ctx结束后停止source，等待消息发送完成后返回
 func (b *Bot) Run(ctx context.Context) {
 	logger.Info("启动bot")
-	for _, s := range b.sources {
-		go s.Send(ctx, b.ch)
+	b.lock.Lock()
+	b.ctx = ctx
+	b.lock.Unlock()
+	for _, w := range b.sinks {
+		go w.run(b)
+		if r, ok := w.sink.(SinkRunner); ok {
+			w := w
+			go r.Run(ctx, func(target string) {
+				w.push(wakeMsg(target))
+			})
+		}
+	}
+	//上次没有发送的消息比source产生的新消息早，先加入队列
+	b.restore()
+	for _, r := range b.sources {
+		if err := r.start(ctx, b.ch); err != nil {
+			logger.WithFields(logrus.Fields{
+				"name": r.name,
+				"err":  err,
+			}).Error("启动source失败")
+		}
 	}
 	for {
 		select {
 		case <-ctx.Done():
+			b.shutdown()
 			logger.Info("bot退出")
 			return
 		case msg := <-b.ch:
-			logger.WithFields(logrus.Fields{
-				"author":   msg.Author,
-				"title":    msg.Title,
-				"src":      msg.Src,
-				"len(img)": len(msg.Img),
-				"flag":     msg.Flag,
-			}).Info("接收到msg")
-			for _, s := range b.sinks {
-				go func(s Sink) {
-					err := s.Receive(msg)
-					if err != nil {
-						logger.WithField("error", err).Error("bot发送消息失败")
-					}
-				}(s)
-			}
+			b.dispatch(msg)
 		}
 	}
 }
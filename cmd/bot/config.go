@@ -4,37 +4,183 @@ import (
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
 	"io"
+	"time"
 )
 
+type SchedulerCfg struct {
+	Workers      int                      `yaml:"workers"`
+	Jitter       time.Duration            `yaml:"jitter"`
+	HostInterval map[string]time.Duration `yaml:"hostInterval"`
+}
+
+type LiveDebounceCfg struct {
+	Confirm   int           `yaml:"confirm"`
+	Grace     time.Duration `yaml:"grace"`
+	Cooldown  time.Duration `yaml:"cooldown"`
+	Reconnect bool          `yaml:"reconnect"`
+}
+
+type IntervalCfg struct {
+	Interval time.Duration            `yaml:"interval"`
+	Accounts map[string]time.Duration `yaml:"accounts"`
+}
+
+type IntervalsCfg struct {
+	BiliLive    IntervalCfg `yaml:"biliLive"`
+	BiliDynamic IntervalCfg `yaml:"biliDynamic"`
+	BiliVideo   IntervalCfg `yaml:"biliVideo"`
+	TiktokLive  IntervalCfg `yaml:"tiktokLive"`
+	TiktokPost  IntervalCfg `yaml:"tiktokPost"`
+	Douyu       IntervalCfg `yaml:"douyu"`
+	Huya        IntervalCfg `yaml:"huya"`
+	Weibo       IntervalCfg `yaml:"weibo"`
+}
+
+type AdaptiveCfg struct {
+	Fast   time.Duration `yaml:"fast"`
+	Window time.Duration `yaml:"window"`
+}
+
+type BiliDanmakuCfg struct {
+	Room      []int `yaml:"room"`
+	SuperChat bool  `yaml:"superChat"`
+	Guard     bool  `yaml:"guard"`
+}
+
+type BiliVideoCfg struct {
+	Uid        []int64 `yaml:"uid"`
+	Milestones []int64 `yaml:"milestones"`
+}
+
+type BiliFollowerCfg struct {
+	Uid       []int64       `yaml:"uid"`
+	Rooms     map[int64]int `yaml:"rooms"`
+	Step      int64         `yaml:"step"`
+	GuardStep int64         `yaml:"guardStep"`
+	Percent   float64       `yaml:"percent"`
+	Interval  time.Duration `yaml:"interval"`
+}
+
 type BiliCfg struct {
-	Live    []int   `yaml:"live"`
-	Dynamic []int64 `yaml:"dynamic"`
+	Cookies    map[string]string `yaml:"cookies"`
+	Live       []int             `yaml:"live"`
+	InfoChange []int             `yaml:"infoChange"`
+	Dynamic    []int64           `yaml:"dynamic"`
+	MaxPages   int               `yaml:"maxPages"`
+	Danmaku    BiliDanmakuCfg    `yaml:"danmaku"`
+	Video      BiliVideoCfg      `yaml:"video"`
+	Follower   BiliFollowerCfg   `yaml:"follower"`
 }
 
 type TiktokCfg struct {
+	Backend   string   `yaml:"backend"`
 	Nonce     string   `yaml:"nonce"`
 	Signature string   `yaml:"signature"`
 	Users     []string `yaml:"users"`
+	Posts     []string `yaml:"posts"`
+}
+
+type DouyuCfg struct {
+	Live []int `yaml:"live"`
+}
+
+type HuyaCfg struct {
+	Live []string `yaml:"live"`
+}
+
+type WeiboCfg struct {
+	Cookies map[string]string `yaml:"cookies"`
+	Users   []int64           `yaml:"users"`
+}
+
+type JSONPathsCfg struct {
+	List    string `yaml:"list"`
+	Id      string `yaml:"id"`
+	Time    string `yaml:"time"`
+	Author  string `yaml:"author"`
+	Title   string `yaml:"title"`
+	Text    string `yaml:"text"`
+	Image   string `yaml:"image"`
+	Link    string `yaml:"link"`
+	State   string `yaml:"state"`
+	StateOn string `yaml:"stateOn"`
+}
+
+type JSONPollCfg struct {
+	Name     string            `yaml:"name"`
+	Url      string            `yaml:"url"`
+	Ids      []string          `yaml:"ids"`
+	Headers  map[string]string `yaml:"headers"`
+	Cookies  map[string]string `yaml:"cookies"`
+	Interval time.Duration     `yaml:"interval"`
+	Paths    JSONPathsCfg      `yaml:"paths"`
+}
+
+type QueueCfg struct {
+	Capacity int    `yaml:"capacity"`
+	Overflow string `yaml:"overflow"`
+}
+
+type DedupCfg struct {
+	Enable bool          `yaml:"enable"`
+	Window time.Duration `yaml:"window"`
+	Keys   []string      `yaml:"keys"`
+}
+
+type DigestCfg struct {
+	Enable      bool          `yaml:"enable"`
+	Every       time.Duration `yaml:"every"`
+	At          []string      `yaml:"at"`
+	Threshold   int           `yaml:"threshold"`
+	Passthrough []string      `yaml:"passthrough"`
+}
+
+type QuietCfg struct {
+	Enable bool     `yaml:"enable"`
+	Start  string   `yaml:"start"`
+	End    string   `yaml:"end"`
+	Urgent []string `yaml:"urgent"`
 }
 
 type DingTalkCfg struct {
-	Webhook string `yaml:"webhook"`
-	Secret  string `yaml:"secret"`
+	Webhook string    `yaml:"webhook"`
+	Secret  string    `yaml:"secret"`
+	Queue   QueueCfg  `yaml:"queue"`
+	Digest  DigestCfg `yaml:"digest"`
+	Quiet   QuietCfg  `yaml:"quiet"`
 }
 
 type CQBotCfg struct {
-	Host    string `yaml:"host"`
-	Token   string `yaml:"token"`
-	BufSize int    `yaml:"bufSize"`
+	Host    string    `yaml:"host"`
+	Token   string    `yaml:"token"`
+	BufSize int       `yaml:"bufSize"`
+	Admins  []uint64  `yaml:"admins"`
+	Queue   QueueCfg  `yaml:"queue"`
+	Digest  DigestCfg `yaml:"digest"`
+	Quiet   QuietCfg  `yaml:"quiet"`
 }
 
 type Config struct {
-	MsgBuf   int         `yaml:"msgBuf"`
-	LogLevel string      `yaml:"logLevel"`
-	Bili     BiliCfg     `yaml:"bili"`
-	Tiktok   TiktokCfg   `yaml:"tiktok"`
-	DingTalk DingTalkCfg `yaml:"dingTalk,omitempty"`
-	CQBot    CQBotCfg    `yaml:"cqBot,omitempty"`
+	MsgBuf          int             `yaml:"msgBuf"`
+	LogLevel        string          `yaml:"logLevel"`
+	Timezone        string          `yaml:"timezone"`
+	Alert           string          `yaml:"alert"`
+	Queue           QueueCfg        `yaml:"queue"`
+	Dedup           DedupCfg        `yaml:"dedup"`
+	ShutdownTimeout time.Duration   `yaml:"shutdownTimeout"`
+	DataDir         string          `yaml:"dataDir"`
+	Debounce        LiveDebounceCfg `yaml:"liveDebounce"`
+	Schedule        SchedulerCfg    `yaml:"scheduler"`
+	Intervals       IntervalsCfg    `yaml:"intervals"`
+	Adaptive        AdaptiveCfg     `yaml:"adaptive"`
+	Bili            BiliCfg         `yaml:"bili"`
+	Tiktok          TiktokCfg       `yaml:"tiktok"`
+	Douyu           DouyuCfg        `yaml:"douyu"`
+	Huya            HuyaCfg         `yaml:"huya"`
+	Weibo           WeiboCfg        `yaml:"weibo"`
+	JSONPoll        []JSONPollCfg   `yaml:"jsonPoll"`
+	DingTalk        DingTalkCfg     `yaml:"dingTalk,omitempty"`
+	CQBot           CQBotCfg        `yaml:"cqBot,omitempty"`
 }
 
 func ReadCfg(reader io.Reader) (*Config, error) {
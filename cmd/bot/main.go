@@ -12,7 +12,9 @@ import (
 	"os/signal"
 	"path"
 	"runtime"
+	"syscall"
 	"time"
+	_ "time/tzdata"
 )
 
 const (
@@ -46,38 +48,101 @@ func main() {
 	logWriter := bufio.NewWriter(logFile)
 	SetUpLogger(os.Stdout, logWriter)
 	forwardBot.SetLogger(logger)
+	if cfg.DataDir != "" {
+		store, err := forwardBot.NewFileStore(cfg.DataDir)
+		if err != nil {
+			logger.WithField("err", err).Error("创建数据目录失败，不保存持久化数据")
+		} else {
+			forwardBot.SetStore(store)
+		}
+	} else {
+		logger.Warn("未配置数据目录，不保存持久化数据")
+	}
+	forwardBot.SetBiliCookies(cfg.Bili.Cookies)
+	forwardBot.SetSchedulerOption(forwardBot.SchedulerOption{
+		Workers: cfg.Schedule.Workers,
+		Jitter:  cfg.Schedule.Jitter,
+	})
+	for host, gap := range cfg.Schedule.HostInterval {
+		forwardBot.SetHostInterval(host, gap)
+	}
 	bot := forwardBot.NewBot(cfg.MsgBuf)
+	bot.SetShutdownTimeout(cfg.ShutdownTimeout)
+	if cfg.Dedup.Enable {
+		bot.EnableDedup(forwardBot.DedupOption{
+			Window: cfg.Dedup.Window,
+			Keys:   cfg.Dedup.Keys,
+		})
+	}
 	bot.AppendSource(
 		BiliLiveSource(),
 		BiliDynamicSource(),
+		BiliDanmakuSource(),
+		BiliVideoSource(),
+		BiliFollowerSource(),
 		TikTokLiveSource(),
+		TiktokPostSource(),
+		DouyuLiveSource(),
+		HuyaLiveSource(),
+		WeiboSource(),
 	)
+	bot.AppendSource(JSONPollSources()...)
 	bot.EnableTestSource()
-	bot.AppendSink(DingTalkSink())
+	dingTalk := DingTalkSink()
+	if dingTalk != nil {
+		sink := quietSink(dingTalk, "dingTalk", cfg.DingTalk.Quiet)
+		bot.AppendSinkWithQueue(digestSink(sink, "dingTalk", cfg.DingTalk.Digest), queueOption(cfg.DingTalk.Queue))
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
 	var cqBot *forwardBot.CQBotSink
 	if cfg.CQBot.Host == "" {
 		logger.Warn("未配置CQBot, 不推送消息至QQ")
 	} else {
 		cqBot = forwardBot.NewCQBotSink(cfg.CQBot.Host, cfg.CQBot.Token, cfg.CQBot.BufSize)
-		bot.AppendSink(cqBot)
+		//频道通过指令设置的免打扰使用同样的紧急消息类型
+		urgent, err := urgentFlags(cfg.CQBot.Quiet)
+		if err != nil {
+			logger.WithField("err", err).Error("解析紧急消息类型失败，使用默认设置")
+		}
+		cqBot.SetQuietOption(location(), urgent)
+		cqBot.SetBot(bot)
+		cqBot.SetAdmins(cfg.CQBot.Admins)
+		sink := quietSink(cqBot, "cqBot", cfg.CQBot.Quiet)
+		bot.AppendSinkWithQueue(digestSink(sink, "cqBot", cfg.CQBot.Digest), queueOption(cfg.CQBot.Queue))
+	}
+	switch {
+	case cfg.Alert == "dingTalk" && dingTalk != nil:
+		bot.SetAdminSink(dingTalk)
+	case cfg.Alert == "cqBot" && cqBot != nil:
+		bot.SetAdminSink(cqBot)
+	case cfg.Alert != "":
+		logger.WithField("alert", cfg.Alert).Warn("告警消息的sink未配置，不发送告警")
 	}
 
+	//bot停止时还需要通过CQBot发送剩余的消息，bot退出后再停止监听
+	listenCtx, listenCancel := context.WithCancel(context.Background())
 	go func() {
 		if cqBot != nil {
-			err := cqBot.Listen(ctx)
+			err := cqBot.Listen(listenCtx)
 			if err != nil {
 				logger.WithField("err", err).Error("CQBot出现错误")
 			}
 		}
 	}()
 
-	go bot.Run(ctx)
-	exits := make(chan os.Signal)
-	signal.Notify(exits, os.Interrupt, os.Kill)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		bot.Run(ctx)
+		close(done)
+	}()
+	exits := make(chan os.Signal, 1)
+	signal.Notify(exits, os.Interrupt, syscall.SIGTERM)
 	<-exits
+	logger.Info("收到退出信号，等待消息发送完成")
 	cancel()
+	<-done
+	listenCancel()
 	logger.Info("程序退出")
 	_ = logWriter.Flush()
 	_ = logFile.Close()
@@ -124,7 +189,14 @@ func BiliLiveSource() forwardBot.Source {
 		logger.Warn("不监控B站开播状态")
 		return nil
 	}
-	return forwardBot.NewBiliLiveSource(cfg.Bili.Live)
+	source := forwardBot.NewBiliLiveSource(cfg.Bili.Live)
+	source.SetDebounce(forwardBot.LiveDebounce(cfg.Debounce))
+	setInterval(source, cfg.Intervals.BiliLive)
+	setAdaptive(source)
+	if len(cfg.Bili.InfoChange) != 0 {
+		source.WatchInfoChange(cfg.Bili.InfoChange...)
+	}
+	return source
 }
 
 func BiliDynamicSource() forwardBot.Source {
@@ -132,7 +204,55 @@ func BiliDynamicSource() forwardBot.Source {
 		logger.Warn("不监控B站动态")
 		return nil
 	}
-	return forwardBot.NewBiliDynamicSource(cfg.Bili.Dynamic)
+	source := forwardBot.NewBiliDynamicSource(cfg.Bili.Dynamic)
+	setInterval(source, cfg.Intervals.BiliDynamic)
+	if cfg.Bili.MaxPages != 0 {
+		source.SetMaxPages(cfg.Bili.MaxPages)
+	}
+	return source
+}
+
+func BiliDanmakuSource() forwardBot.Source {
+	if len(cfg.Bili.Danmaku.Room) == 0 {
+		logger.Warn("不连接B站弹幕服务器")
+		return nil
+	}
+	source := forwardBot.NewBiliDanmakuSource(cfg.Bili.Danmaku.Room,
+		cfg.Bili.Danmaku.SuperChat, cfg.Bili.Danmaku.Guard)
+	//弹幕服务器只推送一次下播消息，无法多次确认，只使用冷却时间
+	source.SetDebounce(forwardBot.LiveDebounce{
+		Cooldown:  cfg.Debounce.Cooldown,
+		Reconnect: cfg.Debounce.Reconnect,
+	})
+	//同时在bili.live中的房间由BiliLiveSource推送开播、下播
+	source.SkipLiveStatus(cfg.Bili.Live...)
+	return source
+}
+
+func BiliVideoSource() forwardBot.Source {
+	if len(cfg.Bili.Video.Uid) == 0 {
+		logger.Warn("不监控B站视频投稿")
+		return nil
+	}
+	source := forwardBot.NewBiliVideoSource(cfg.Bili.Video.Uid, cfg.Bili.Video.Milestones)
+	setInterval(source, cfg.Intervals.BiliVideo)
+	return source
+}
+
+func BiliFollowerSource() forwardBot.Source {
+	if len(cfg.Bili.Follower.Uid) == 0 {
+		logger.Warn("不监控B站粉丝数")
+		return nil
+	}
+	c := cfg.Bili.Follower
+	return forwardBot.NewBiliFollowerSource(forwardBot.BiliFollowerOption{
+		Uid:       c.Uid,
+		Rooms:     c.Rooms,
+		Step:      c.Step,
+		GuardStep: c.GuardStep,
+		Percent:   c.Percent,
+		Interval:  c.Interval,
+	})
 }
 
 func TikTokLiveSource() forwardBot.Source {
@@ -140,7 +260,198 @@ func TikTokLiveSource() forwardBot.Source {
 		logger.Warn("不监控抖音开播状态")
 		return nil
 	}
-	return forwardBot.NewTiktokLiveSource(cfg.Tiktok.Nonce, cfg.Tiktok.Signature, cfg.Tiktok.Users)
+	source := forwardBot.NewTiktokLiveSource(cfg.Tiktok.Nonce, cfg.Tiktok.Signature, cfg.Tiktok.Users)
+	source.SetDebounce(forwardBot.LiveDebounce(cfg.Debounce))
+	setInterval(source, cfg.Intervals.TiktokLive)
+	setAdaptive(source)
+	if cfg.Tiktok.Backend != "" {
+		source.SetBackend(cfg.Tiktok.Backend)
+	}
+	return source
+}
+
+func TiktokPostSource() forwardBot.Source {
+	if len(cfg.Tiktok.Posts) == 0 {
+		logger.Warn("不监控抖音作品")
+		return nil
+	}
+	source := forwardBot.NewTiktokPostSource(cfg.Tiktok.Posts)
+	setInterval(source, cfg.Intervals.TiktokPost)
+	return source
+}
+
+func DouyuLiveSource() forwardBot.Source {
+	if len(cfg.Douyu.Live) == 0 {
+		logger.Warn("不监控斗鱼开播状态")
+		return nil
+	}
+	source := forwardBot.NewDouyuLiveSource(cfg.Douyu.Live)
+	source.SetDebounce(forwardBot.LiveDebounce(cfg.Debounce))
+	setInterval(source, cfg.Intervals.Douyu)
+	setAdaptive(source)
+	return source
+}
+
+func HuyaLiveSource() forwardBot.Source {
+	if len(cfg.Huya.Live) == 0 {
+		logger.Warn("不监控虎牙开播状态")
+		return nil
+	}
+	source := forwardBot.NewHuyaLiveSource(cfg.Huya.Live)
+	source.SetDebounce(forwardBot.LiveDebounce(cfg.Debounce))
+	setInterval(source, cfg.Intervals.Huya)
+	setAdaptive(source)
+	return source
+}
+
+func WeiboSource() forwardBot.Source {
+	if len(cfg.Weibo.Users) == 0 {
+		logger.Warn("不监控微博")
+		return nil
+	}
+	source := forwardBot.NewWeiboSource(cfg.Weibo.Users, cfg.Weibo.Cookies)
+	setInterval(source, cfg.Intervals.Weibo)
+	return source
+}
+
+// 设置source的轮询间隔，没有配置时使用默认值
+func setInterval(source interface {
+	SetInterval(d time.Duration, accounts map[string]time.Duration)
+}, c IntervalCfg) {
+	if c.Interval == 0 && len(c.Accounts) == 0 {
+		return
+	}
+	source.SetInterval(c.Interval, c.Accounts)
+}
+
+// 在主播通常开播的时间附近缩短轮询间隔
+func setAdaptive(source interface {
+	SetAdaptive(fast, window time.Duration)
+}) {
+	if cfg.Adaptive.Fast <= 0 || cfg.Adaptive.Window <= 0 {
+		return
+	}
+	source.SetAdaptive(cfg.Adaptive.Fast, cfg.Adaptive.Window)
+}
+
+func JSONPollSources() []forwardBot.Source {
+	sources := make([]forwardBot.Source, 0, len(cfg.JSONPoll))
+	for _, c := range cfg.JSONPoll {
+		if c.Url == "" {
+			logger.WithField("name", c.Name).Warn("json接口未配置url")
+			continue
+		}
+		sources = append(sources, forwardBot.NewJSONPollSource(forwardBot.JSONPollOption{
+			Name:     c.Name,
+			Url:      c.Url,
+			Ids:      c.Ids,
+			Headers:  c.Headers,
+			Cookies:  c.Cookies,
+			Interval: c.Interval,
+			Paths:    forwardBot.JSONPaths(c.Paths),
+		}))
+	}
+	return sources
+}
+
+// sink的队列设置，没有单独设置时使用全局设置
+func queueOption(c QueueCfg) forwardBot.QueueOption {
+	if c.Capacity == 0 && c.Overflow == "" {
+		c = cfg.Queue
+	}
+	opt := forwardBot.QueueOption{
+		Capacity: c.Capacity,
+		Overflow: c.Overflow,
+	}
+	if cfg.DataDir != "" {
+		opt.SpillDir = path.Join(cfg.DataDir, "queue")
+	}
+	return opt
+}
+
+// 配置的时区，未配置或者加载失败时使用本地时区
+func location() *time.Location {
+	if cfg.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"timezone": cfg.Timezone,
+			"err":      err,
+		}).Warn("加载时区失败，使用本地时区")
+		return time.Local
+	}
+	return loc
+}
+
+// 免打扰时段内仍然发送的消息类型，未配置时返回nil使用默认设置
+func urgentFlags(c QuietCfg) ([]int, error) {
+	if len(c.Urgent) == 0 {
+		return nil, nil
+	}
+	return forwardBot.ParseFlags(c.Urgent)
+}
+
+// 开启免打扰时用QuietSink包装sink
+func quietSink(sink forwardBot.Sink, name string, c QuietCfg) forwardBot.Sink {
+	if !c.Enable {
+		return sink
+	}
+	urgent, err := urgentFlags(c)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"name": name,
+			"err":  err,
+		}).Error("解析免打扰配置失败，不启用免打扰")
+		return sink
+	}
+	quiet, err := forwardBot.NewQuietSink(sink, forwardBot.QuietOption{
+		Name:     name,
+		Start:    c.Start,
+		End:      c.End,
+		Location: location(),
+		Urgent:   urgent,
+	})
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"name": name,
+			"err":  err,
+		}).Error("创建免打扰sink失败，不启用免打扰")
+		return sink
+	}
+	return quiet
+}
+
+// 开启汇总时用DigestSink包装sink
+func digestSink(sink forwardBot.Sink, name string, c DigestCfg) forwardBot.Sink {
+	if !c.Enable {
+		return sink
+	}
+	passthrough, err := forwardBot.ParseFlags(c.Passthrough)
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"name": name,
+			"err":  err,
+		}).Error("解析汇总配置失败，不汇总消息")
+		return sink
+	}
+	digest, err := forwardBot.NewDigestSink(sink, forwardBot.DigestOption{
+		Name:        name,
+		Every:       c.Every,
+		At:          c.At,
+		Location:    location(),
+		Threshold:   c.Threshold,
+		Passthrough: passthrough,
+	})
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"name": name,
+			"err":  err,
+		}).Error("创建汇总sink失败，不汇总消息")
+		return sink
+	}
+	return digest
 }
 
 func DingTalkSink() forwardBot.Sink {
@@ -0,0 +1,421 @@
+package forwardBot
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"forwardBot/push"
+	"forwardBot/req"
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"io"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	danmuInfoUrl     = "https://api.live.bilibili.com/xlive/web-room/v1/index/getDanmuInfo"
+	danmuDefaultHost = "wss://broadcastlv.chat.bilibili.com/sub"
+	danmuHeartbeat   = 30 * time.Second
+	danmuReadTimeout = 70 * time.Second //超过该时间没有收到任何消息则重连
+	danmuMinBackoff  = 5 * time.Second
+	danmuMaxBackoff  = 2 * time.Minute
+)
+
+// 弹幕服务器数据包的协议版本
+const (
+	danmuVerPlain  uint16 = 0 //json
+	danmuVerInt    uint16 = 1 //心跳回复，内容为人气值
+	danmuVerZlib   uint16 = 2 //zlib压缩的数据包
+	danmuVerBrotli uint16 = 3 //brotli压缩的数据包
+)
+
+// 弹幕服务器数据包的操作码
+const (
+	danmuOpHeartbeat      uint32 = 2
+	danmuOpHeartbeatReply uint32 = 3
+	danmuOpMessage        uint32 = 5
+	danmuOpAuth           uint32 = 7
+	danmuOpAuthReply      uint32 = 8
+)
+
+const danmuHeaderLen = 16
+
+// 弹幕服务器推送的消息类型
+const (
+	danmuCmdLive      = "LIVE"
+	danmuCmdPreparing = "PREPARING"
+	danmuCmdSuperChat = "SUPER_CHAT_MESSAGE"
+	danmuCmdGuardBuy  = "GUARD_BUY"
+)
+
+// danmuPacket 弹幕服务器的数据包，头部为16字节：
+// 包长度(4) 头部长度(2) 协议版本(2) 操作码(4) 序列号(4)
+type danmuPacket struct {
+	ver  uint16
+	op   uint32
+	body []byte
+}
+
+// 编码数据包
+func encodeDanmuPacket(ver uint16, op uint32, body []byte) []byte {
+	buf := make([]byte, danmuHeaderLen+len(body))
+	binary.BigEndian.PutUint32(buf[0:], uint32(len(buf)))
+	binary.BigEndian.PutUint16(buf[4:], danmuHeaderLen)
+	binary.BigEndian.PutUint16(buf[6:], ver)
+	binary.BigEndian.PutUint32(buf[8:], op)
+	binary.BigEndian.PutUint32(buf[12:], 1)
+	copy(buf[danmuHeaderLen:], body)
+	return buf
+}
+
+// 解码数据，一次websocket消息中可能包含多个数据包，压缩的数据包解压后递归解码
+func decodeDanmuPackets(data []byte) ([]danmuPacket, error) {
+	var packets []danmuPacket
+	for len(data) != 0 {
+		if len(data) < danmuHeaderLen {
+			return packets, errors.New("packet too short")
+		}
+		size := binary.BigEndian.Uint32(data[0:])
+		headerLen := binary.BigEndian.Uint16(data[4:])
+		if size < uint32(headerLen) || int(size) > len(data) || headerLen < danmuHeaderLen {
+			return packets, errors.New(fmt.Sprintf("wrong packet size=%d, headerLen=%d, len(data)=%d",
+				size, headerLen, len(data)))
+		}
+		p := danmuPacket{
+			ver:  binary.BigEndian.Uint16(data[6:]),
+			op:   binary.BigEndian.Uint32(data[8:]),
+			body: data[headerLen:size],
+		}
+		data = data[size:]
+		var reader io.Reader
+		switch p.ver {
+		case danmuVerZlib:
+			r, err := zlib.NewReader(bytes.NewReader(p.body))
+			if err != nil {
+				return packets, errors.Wrap(err, "zlib reader")
+			}
+			reader = r
+		case danmuVerBrotli:
+			reader = brotli.NewReader(bytes.NewReader(p.body))
+		default:
+			packets = append(packets, p)
+			continue
+		}
+		body, err := io.ReadAll(reader)
+		if err != nil {
+			return packets, errors.Wrap(err, "decompress packet")
+		}
+		inner, err := decodeDanmuPackets(body)
+		packets = append(packets, inner...)
+		if err != nil {
+			return packets, err
+		}
+	}
+	return packets, nil
+}
+
+var _ Source = (*BiliDanmakuSource)(nil)
+var _ Named = (*BiliDanmakuSource)(nil)
+
+// BiliDanmakuSource 连接b站直播间的弹幕服务器，实时获取开播、下播、醒目留言和上舰消息
+type BiliDanmakuSource struct {
+	*liveTracker
+	health
+	room      []int
+	superChat bool         //是否推送醒目留言
+	guard     bool         //是否推送上舰消息
+	skipLive  map[int]bool //不处理开播、下播的房间，由BiliLiveSource推送
+	//获取直播间信息，测试时可以替换
+	roomInfo func(roomId int) (*LiveInfo, error)
+	//获取弹幕服务器地址和认证用的token，测试时可以替换
+	danmuInfo func(roomId int) (host, token string, err error)
+}
+
+func NewBiliDanmakuSource(room []int, superChat, guard bool) *BiliDanmakuSource {
+	logger.WithFields(logrus.Fields{
+		"room":      room,
+		"superChat": superChat,
+		"guard":     guard,
+	}).Info("[BiliDanmaku]监控b站直播间弹幕服务器")
+	return &BiliDanmakuSource{
+		liveTracker: newLiveTracker("[BiliDanmaku]", "", BiliLiveMsg),
+		room:        append([]int{}, room...),
+		superChat:   superChat,
+		guard:       guard,
+		skipLive:    make(map[int]bool),
+		roomInfo:    getRoomInfo,
+		danmuInfo:   getDanmuInfo,
+	}
+}
+
+// SkipLiveStatus 不推送这些房间的开播、下播消息，用于已经由BiliLiveSource监控的房间，避免重复推送，必须在Send方法之前调用
+func (b *BiliDanmakuSource) SkipLiveStatus(room ...int) {
+	for _, id := range room {
+		b.skipLive[id] = true
+	}
+}
+
+func (b *BiliDanmakuSource) Name() string {
+	return "[BiliDanmaku]"
+}
+
+func (b *BiliDanmakuSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	wg := sync.WaitGroup{}
+	for _, id := range b.room {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			b.watch(ctx, id, ch)
+		}(id)
+	}
+	wg.Wait()
+	logger.Info("[BiliDanmaku]停止监控b站直播间弹幕服务器")
+}
+
+// 获取弹幕服务器地址和token
+func getDanmuInfo(roomId int) (host, token string, err error) {
+	body, err := biliClient.Get(danmuInfoUrl, req.D{{"id", roomId}, {"type", 0}}, true)
+	if err != nil {
+		return "", "", err
+	}
+	result, err := checkResp(body)
+	if err != nil {
+		return "", "", errors.Wrap(err, "read bili resp data fail")
+	}
+	data, code, msg := checkBiliData(result)
+	if code != 0 {
+		return "", "", errors.New(fmt.Sprintf("code=%d,msg=%s", code, msg))
+	}
+	host = danmuDefaultHost
+	if h := data.Get("host_list.0"); h.Exists() {
+		host = fmt.Sprintf("wss://%s:%d/sub", h.Get("host").String(), h.Get("wss_port").Int())
+	}
+	return host, data.Get("token").String(), nil
+}
+
+// 监听一个直播间，连接断开后退避重连
+func (b *BiliDanmakuSource) watch(ctx context.Context, id int, ch chan<- *push.Msg) {
+	backoff := danmuMinBackoff
+	for {
+		start := time.Now()
+		err := b.connect(ctx, id, ch)
+		if ctx.Err() != nil {
+			return
+		}
+		//连接保持了一段时间，说明不是连续失败，重置退避时间
+		if time.Since(start) > danmuMaxBackoff {
+			backoff = danmuMinBackoff
+		}
+		b.fail(id, err)
+		logger.WithFields(logrus.Fields{
+			"roomId":  id,
+			"err":     err,
+			"backoff": backoff,
+		}).Warn("[BiliDanmaku]弹幕服务器连接断开，稍后重连")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > danmuMaxBackoff {
+			backoff = danmuMaxBackoff
+		}
+	}
+}
+
+// 连接弹幕服务器并读取消息，直到连接断开或者ctx结束，发生panic时作为连接断开处理
+func (b *BiliDanmakuSource) connect(ctx context.Context, id int, ch chan<- *push.Msg) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			reportPanic(ctx, "[BiliDanmaku]", v, debug.Stack())
+			err = errors.New(fmt.Sprintf("panic: %v", v))
+		}
+	}()
+	//连接前先获取一次开播状态和主播昵称，开播状态由BiliLive推送的房间只取昵称
+	uname := ""
+	if info, err := b.roomInfo(id); err != nil {
+		logger.WithFields(logrus.Fields{
+			"roomId": id,
+			"err":    err,
+		}).Warn("[BiliDanmaku]获取直播间信息失败")
+	} else {
+		uname = info.Uname
+		if !b.skipLive[id] {
+			if msg := b.update(strconv.Itoa(id), info, time.Now()); msg != nil {
+				ch <- msg
+			}
+		}
+		info.Reset()
+		liveInfoPool.Put(info)
+	}
+	host, token, err := b.danmuInfo(id)
+	if err != nil {
+		return errors.Wrap(err, "get danmu info fail")
+	}
+	conn, _, err := new(websocket.Dialer).DialContext(ctx, host, nil)
+	if err != nil {
+		return errors.Wrap(err, "connect danmu server fail")
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	auth := req.D{
+		{"uid", 0},
+		{"roomid", id},
+		{"protover", int(danmuVerBrotli)},
+		{"platform", "web"},
+		{"type", 2},
+		{"key", token},
+	}
+	lock := sync.Mutex{}
+	write := func(op uint32, body []byte) error {
+		lock.Lock()
+		defer lock.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, encodeDanmuPacket(danmuVerInt, op, body))
+	}
+	if err = write(danmuOpAuth, []byte(auth.Json())); err != nil {
+		return errors.Wrap(err, "send auth packet fail")
+	}
+	logger.WithField("roomId", id).Info("[BiliDanmaku]连接弹幕服务器")
+	//心跳包
+	go func() {
+		ticker := time.NewTicker(danmuHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := write(danmuOpHeartbeat, nil); err != nil {
+					logger.WithFields(logrus.Fields{
+						"roomId": id,
+						"err":    err,
+					}).Warn("[BiliDanmaku]发送心跳包失败")
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(danmuReadTimeout))
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return errors.Wrap(err, "read danmu message fail")
+		}
+		packets, err := decodeDanmuPackets(data)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"roomId": id,
+				"err":    err,
+			}).Warn("[BiliDanmaku]解析数据包失败")
+		}
+		for _, p := range packets {
+			switch p.op {
+			case danmuOpAuthReply:
+				if code := gjson.GetBytes(p.body, "code").Int(); code != 0 {
+					return errors.New(fmt.Sprintf("auth fail, code=%d", code))
+				}
+				b.succeed(id)
+				logger.WithField("roomId", id).Debug("[BiliDanmaku]弹幕服务器认证成功")
+			case danmuOpHeartbeatReply:
+				b.succeed(id)
+				logger.WithField("roomId", id).Trace("[BiliDanmaku]收到心跳回复")
+				//心跳回复的内容为4字节的人气值
+				if len(p.body) >= 4 {
+					b.observeOnline(strconv.Itoa(id), int64(binary.BigEndian.Uint32(p.body)))
+				}
+			case danmuOpMessage:
+				if msg := b.handle(id, uname, p.body); msg != nil {
+					ch <- msg
+				}
+			}
+		}
+	}
+}
+
+// 处理弹幕服务器推送的消息，返回需要推送的消息
+func (b *BiliDanmakuSource) handle(id int, uname string, body []byte) *push.Msg {
+	r := gjson.ParseBytes(body)
+	cmd := r.Get("cmd").String()
+	//部分消息的cmd带有后缀，例如"DANMU_MSG:4:0:2:2:2:0"
+	if i := strings.IndexByte(cmd, ':'); i >= 0 {
+		cmd = cmd[:i]
+	}
+	now := time.Now()
+	if (cmd == danmuCmdLive || cmd == danmuCmdPreparing) && b.skipLive[id] {
+		logger.WithFields(logrus.Fields{
+			"roomId": id,
+			"cmd":    cmd,
+		}).Debug("[BiliDanmaku]房间的开播状态由BiliLive推送")
+		return nil
+	}
+	switch cmd {
+	case danmuCmdLive:
+		info, err := b.roomInfo(id)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"roomId": id,
+				"err":    err,
+			}).Warn("[BiliDanmaku]获取直播间信息失败")
+			info = liveInfoPool.Get().(*LiveInfo)
+			info.Uname = uname
+			info.RoomId = id
+			info.Link = fmt.Sprintf("%s%d", liveUrlPrefix, id)
+		}
+		info.LiveStatus = true
+		msg := b.update(strconv.Itoa(id), info, now)
+		info.Reset()
+		liveInfoPool.Put(info)
+		return msg
+	case danmuCmdPreparing:
+		info := &LiveInfo{Uname: uname, RoomId: id}
+		return b.update(strconv.Itoa(id), info, now)
+	case danmuCmdSuperChat:
+		if !b.superChat {
+			return nil
+		}
+		data := r.Get("data")
+		return &push.Msg{
+			Times:  now,
+			Flag:   BiliSuperChatMsg,
+			Author: uname,
+			Title:  "醒目留言",
+			Text: fmt.Sprintf("￥%d %s：%s", data.Get("price").Int(),
+				data.Get("user_info.uname").String(), data.Get("message").String()),
+			Src: fmt.Sprintf("%s%d", liveUrlPrefix, id),
+		}
+	case danmuCmdGuardBuy:
+		if !b.guard {
+			return nil
+		}
+		data := r.Get("data")
+		return &push.Msg{
+			Times:  now,
+			Flag:   BiliGuardMsg,
+			Author: uname,
+			Title:  "上舰",
+			Text: fmt.Sprintf("%s 开通了 %s×%d", data.Get("username").String(),
+				data.Get("gift_name").String(), data.Get("num").Int()),
+			Src: fmt.Sprintf("%s%d", liveUrlPrefix, id),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package forwardBot
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"forwardBot/push"
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func compressDanmu(t *testing.T, ver uint16, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	switch ver {
+	case danmuVerZlib:
+		w := zlib.NewWriter(buf)
+		_, _ = w.Write(data)
+		assert.Nil(t, w.Close())
+	case danmuVerBrotli:
+		w := brotli.NewWriter(buf)
+		_, _ = w.Write(data)
+		assert.Nil(t, w.Close())
+	}
+	return encodeDanmuPacket(ver, danmuOpMessage, buf.Bytes())
+}
+
+func TestDecodeDanmuPackets(t *testing.T) {
+	live := encodeDanmuPacket(danmuVerPlain, danmuOpMessage, []byte(`{"cmd":"LIVE"}`))
+	sc := encodeDanmuPacket(danmuVerPlain, danmuOpMessage, []byte(`{"cmd":"SUPER_CHAT_MESSAGE"}`))
+	inner := append(append([]byte{}, live...), sc...)
+	tests := []struct {
+		name string
+		in   []byte
+		ops  []uint32
+	}{
+		{"case heartbeat reply", encodeDanmuPacket(danmuVerInt, danmuOpHeartbeatReply, []byte{0, 0, 0, 1}),
+			[]uint32{danmuOpHeartbeatReply}},
+		{"case plain", inner, []uint32{danmuOpMessage, danmuOpMessage}},
+		{"case zlib", compressDanmu(t, danmuVerZlib, inner), []uint32{danmuOpMessage, danmuOpMessage}},
+		{"case brotli", compressDanmu(t, danmuVerBrotli, inner), []uint32{danmuOpMessage, danmuOpMessage}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			packets, err := decodeDanmuPackets(test.in)
+			assert.Nil(t, err)
+			ops := make([]uint32, 0, len(packets))
+			for _, p := range packets {
+				ops = append(ops, p.op)
+			}
+			assert.Equal(t, test.ops, ops)
+		})
+	}
+	_, err := decodeDanmuPackets(live[:10])
+	assert.NotNil(t, err)
+}
+
+// 使用本地的websocket服务器模拟弹幕服务器
+func TestBiliDanmakuSource(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		packets, err := decodeDanmuPackets(data)
+		if err != nil || len(packets) != 1 || packets[0].op != danmuOpAuth {
+			return
+		}
+		if gjson.GetBytes(packets[0].body, "key").String() != "token" {
+			return
+		}
+		_ = conn.WriteMessage(websocket.BinaryMessage,
+			encodeDanmuPacket(danmuVerInt, danmuOpAuthReply, []byte(`{"code":0}`)))
+		msgs := append(encodeDanmuPacket(danmuVerPlain, danmuOpMessage, []byte(`{"cmd":"LIVE","roomid":1}`)),
+			encodeDanmuPacket(danmuVerPlain, danmuOpMessage, []byte(`{"cmd":"SUPER_CHAT_MESSAGE",
+				"data":{"price":30,"message":"你好","user_info":{"uname":"观众"}}}`))...)
+		_ = conn.WriteMessage(websocket.BinaryMessage, compressDanmu(t, danmuVerBrotli, msgs))
+		_ = conn.WriteMessage(websocket.BinaryMessage, encodeDanmuPacket(danmuVerPlain, danmuOpMessage,
+			[]byte(`{"cmd":"GUARD_BUY","data":{"username":"观众","gift_name":"舰长","num":1}}`)))
+		_ = conn.WriteMessage(websocket.BinaryMessage, encodeDanmuPacket(danmuVerPlain, danmuOpMessage,
+			[]byte(`{"cmd":"PREPARING","roomid":"1"}`)))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	b := NewBiliDanmakuSource([]int{1}, true, false)
+	var calls int32
+	b.roomInfo = func(roomId int) (*LiveInfo, error) {
+		//第一次获取时未开播
+		living := atomic.AddInt32(&calls, 1) > 1
+		return &LiveInfo{Uname: "up", RoomId: roomId, LiveStatus: living, Title: "title",
+			Link: "https://live.bilibili.com/1"}, nil
+	}
+	b.danmuInfo = func(roomId int) (string, string, error) {
+		return "ws" + strings.TrimPrefix(server.URL, "http"), "token", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch := make(chan *push.Msg, 10)
+	go b.Send(ctx, ch)
+
+	var titles []string
+	for len(titles) < 3 {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("timeout, got %v", titles)
+		case msg := <-ch:
+			titles = append(titles, msg.Title)
+			if msg.Flag == BiliSuperChatMsg {
+				assert.Equal(t, "￥30 观众：你好", msg.Text)
+			}
+		}
+	}
+	assert.Equal(t, []string{"开播了", "醒目留言", "下播了"}, titles)
+}
+
+func TestBiliDanmakuSource_SkipLiveStatus(t *testing.T) {
+	b := NewBiliDanmakuSource([]int{1, 2}, true, false)
+	b.SkipLiveStatus(1)
+	b.roomInfo = func(roomId int) (*LiveInfo, error) {
+		return &LiveInfo{Uname: "up", RoomId: roomId, Link: "https://live.bilibili.com/2"}, nil
+	}
+	live := []byte(`{"cmd":"LIVE"}`)
+	//第一次获取状态时只记录
+	assert.Nil(t, b.handle(2, "up", []byte(`{"cmd":"PREPARING"}`)))
+	assert.Nil(t, b.handle(1, "up", live))
+	if msg := b.handle(2, "up", live); assert.NotNil(t, msg) {
+		assert.Equal(t, "开播了", msg.Title)
+	}
+	//跳过的房间仍然推送醒目留言
+	assert.NotNil(t, b.handle(1, "up", []byte(`{"cmd":"SUPER_CHAT_MESSAGE","data":{"price":30}}`)))
+}
+
+// 开播状态由BiliLive推送的房间，重连时不能推送开播、下播消息
+func TestBiliDanmakuSource_ReconnectSkipped(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _, _ = conn.ReadMessage()
+		_ = conn.WriteMessage(websocket.BinaryMessage,
+			encodeDanmuPacket(danmuVerInt, danmuOpAuthReply, []byte(`{"code":0}`)))
+	}))
+	defer server.Close()
+
+	b := NewBiliDanmakuSource([]int{1}, true, false)
+	b.SkipLiveStatus(1)
+	var calls int32
+	b.roomInfo = func(roomId int) (*LiveInfo, error) {
+		//第一次连接时开播，重连时已下播
+		living := atomic.AddInt32(&calls, 1) == 1
+		return &LiveInfo{Uname: "up", RoomId: roomId, LiveStatus: living, Title: "title",
+			Link: "https://live.bilibili.com/1"}, nil
+	}
+	b.danmuInfo = func(roomId int) (string, string, error) {
+		return "ws" + strings.TrimPrefix(server.URL, "http"), "token", nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ch := make(chan *push.Msg, 10)
+	for i := 0; i < 3; i++ {
+		assert.NotNil(t, b.connect(ctx, 1, ch))
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Empty(t, ch)
+	_, living := b.Current("1")
+	assert.False(t, living)
+	assert.Empty(t, b.Sessions("1"))
+}
@@ -0,0 +1,192 @@
+package forwardBot
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"forwardBot/push"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DedupKeyURL     = "url"     //消息的链接
+	DedupKeyBV      = "bv"      //链接和内容中的BV号
+	DedupKeyLive    = "live"    //链接和内容中的b站直播间号
+	DedupKeyContent = "content" //内容的哈希
+
+	dedupStoreName     = "dedup"
+	defaultDedupWindow = 6 * time.Hour
+	dedupMinContent    = 20          //内容的字数少于该值时不计算内容哈希，避免误判
+	dedupSaveInterval  = time.Minute //两次持久化之间的最小间隔，停止时保存最新的记录
+)
+
+var (
+	//参与去重的消息类型，直播间信息变更、醒目留言等同一直播间会连续产生的消息不参与去重
+	dedupFlags = map[int]bool{
+		BiliLiveMsg:   true,
+		BiliDynMsg:    true,
+		TikTokLiveMsg: true,
+		JSONPollMsg:   true,
+		WeiboMsg:      true,
+		DouyuLiveMsg:  true,
+		HuyaLiveMsg:   true,
+		BiliVideoMsg:  true,
+		TikTokPostMsg: true,
+	}
+	bvRegexp       = regexp.MustCompile(`BV1[0-9A-Za-z]{9}`)
+	liveRoomRegexp = regexp.MustCompile(`live\.bilibili\.com/(\d+)`)
+)
+
+// DedupOption 消息去重的设置
+type DedupOption struct {
+	Window time.Duration //在该时间内指纹相同的消息视为重复，为0时使用默认值
+	Keys   []string      //使用的指纹，为空时使用全部
+}
+
+// dedupEntry 记录的消息
+type dedupEntry struct {
+	Flag   int       `json:"flag"`
+	Author string    `json:"author"`
+	Title  string    `json:"title"`
+	Time   time.Time `json:"time"`
+}
+
+// deduper 根据指纹过滤不同source发送的重复消息，例如开播消息和分享直播间的动态、
+// 多个账号转发的同一个视频
+type deduper struct {
+	window  time.Duration
+	keys    []string
+	lock    sync.Mutex
+	entries map[string][]dedupEntry //指纹对应的消息
+	saved   time.Time               //最近一次持久化的时间
+	dirty   bool                    //是否有没有持久化的记录
+}
+
+func newDeduper(opt DedupOption) *deduper {
+	if opt.Window <= 0 {
+		opt.Window = defaultDedupWindow
+	}
+	if len(opt.Keys) == 0 {
+		opt.Keys = []string{DedupKeyURL, DedupKeyBV, DedupKeyLive, DedupKeyContent}
+	}
+	d := &deduper{
+		window:  opt.Window,
+		keys:    opt.Keys,
+		entries: make(map[string][]dedupEntry),
+	}
+	loadState(dedupStoreName, &d.entries)
+	return d
+}
+
+// 计算消息的指纹，链接和引用的链接都参与计算
+func (d *deduper) fingerprints(msg *push.Msg) []string {
+	var prints []string
+	links := append([]string{msg.Src}, msg.Refs...)
+	text := strings.Join(links, "\n") + "\n" + msg.Text
+	for _, key := range d.keys {
+		switch key {
+		case DedupKeyURL:
+			for _, link := range links {
+				if link = strings.TrimRight(link, "/"); link != "" {
+					prints = append(prints, "url:"+link)
+				}
+			}
+		case DedupKeyBV:
+			for _, bv := range bvRegexp.FindAllString(text, -1) {
+				prints = append(prints, "bv:"+bv)
+			}
+		case DedupKeyLive:
+			for _, m := range liveRoomRegexp.FindAllStringSubmatch(text, -1) {
+				prints = append(prints, "live:"+m[1])
+			}
+		case DedupKeyContent:
+			content := strings.TrimSpace(msg.Text)
+			if utf8.RuneCountInString(content) >= dedupMinContent {
+				hash := md5.Sum([]byte(content))
+				prints = append(prints, "content:"+hex.EncodeToString(hash[:]))
+			}
+		default:
+			logger.WithField("key", key).Warn("[Dedup]不支持的指纹")
+		}
+	}
+	return prints
+}
+
+// 判断消息是否重复并记录消息，重复的消息也会被记录
+// 同一类型同一作者标题不同的消息是同一来源的不同事件，例如开播和下播、投稿和播放量里程碑，不视为重复
+func (d *deduper) duplicate(msg *push.Msg, now time.Time) bool {
+	if !dedupFlags[msg.Flag] {
+		return false
+	}
+	prints := d.fingerprints(msg)
+	if len(prints) == 0 {
+		return false
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.expire(now)
+	matched, followUp := false, false
+	for _, p := range prints {
+		for _, e := range d.entries[p] {
+			matched = true
+			if e.Flag == msg.Flag && e.Author == msg.Author && e.Title != msg.Title {
+				followUp = true
+			}
+		}
+	}
+	entry := dedupEntry{Flag: msg.Flag, Author: msg.Author, Title: msg.Title, Time: now}
+	for _, p := range prints {
+		d.entries[p] = append(d.entries[p], entry)
+	}
+	d.dirty = true
+	if now.Sub(d.saved) >= dedupSaveInterval {
+		d.save(now)
+	}
+	dup := matched && !followUp
+	if dup {
+		logger.WithFields(logrus.Fields{
+			"author":       msg.Author,
+			"title":        msg.Title,
+			"fingerprints": prints,
+		}).Info("[Dedup]忽略重复的消息")
+	}
+	return dup
+}
+
+// 持久化记录，必须持有锁
+func (d *deduper) save(now time.Time) {
+	saveState(dedupStoreName, d.entries)
+	d.saved = now
+	d.dirty = false
+}
+
+// 保存没有持久化的记录，在停止时调用
+func (d *deduper) flush() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.dirty {
+		d.save(time.Now())
+	}
+}
+
+// 删除超过时间窗口的记录
+func (d *deduper) expire(now time.Time) {
+	for p, entries := range d.entries {
+		n := 0
+		for _, e := range entries {
+			if now.Sub(e.Time) <= d.window {
+				entries[n] = e
+				n++
+			}
+		}
+		if n == 0 {
+			delete(d.entries, p)
+		} else {
+			d.entries[p] = entries[:n]
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package forwardBot
+
+import (
+	"forwardBot/push"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDeduper_Fingerprints(t *testing.T) {
+	d := &deduper{keys: []string{DedupKeyURL, DedupKeyBV, DedupKeyLive, DedupKeyContent}}
+	tests := []struct {
+		name string
+		msg  *push.Msg
+		want []string
+	}{
+		{"case video", &push.Msg{Src: "https://www.bilibili.com/video/BV1xx411c7mD/"},
+			[]string{"url:https://www.bilibili.com/video/BV1xx411c7mD", "bv:BV1xx411c7mD"}},
+		{"case live share", &push.Msg{Src: "https://t.bilibili.com/1", Text: "标题：\"a\"\nhttps://live.bilibili.com/21452505"},
+			[]string{"url:https://t.bilibili.com/1", "live:21452505", "content:82ce717d6fb2698065e799c065e8dac8"}},
+		{"case content", &push.Msg{Text: " 这是一条足够长的微博内容，用于计算内容的哈希值 "},
+			[]string{"content:7593a25ba1eb83998eb209b1149c9047"}},
+		{"case short content", &push.Msg{Text: "测试消息，flag=0"}, nil},
+		{"case refs", &push.Msg{Src: "https://t.bilibili.com/2", Refs: []string{"https://t.bilibili.com/1"}},
+			[]string{"url:https://t.bilibili.com/2", "url:https://t.bilibili.com/1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, d.fingerprints(test.msg))
+		})
+	}
+}
+
+func TestDeduper_Duplicate(t *testing.T) {
+	d := newDeduper(DedupOption{Window: time.Hour})
+	now := time.Date(2022, 10, 1, 20, 0, 0, 0, time.Local)
+	live := "https://live.bilibili.com/21452505"
+	video := "https://www.bilibili.com/video/BV1xx411c7mD"
+	tests := []struct {
+		name  string
+		msg   *push.Msg
+		after time.Duration
+		want  bool
+	}{
+		{"case live start", &push.Msg{Flag: BiliLiveMsg, Author: "a", Title: "B站开播了", Src: live}, 0, false},
+		{"case start from another source", &push.Msg{Flag: BiliLiveMsg, Author: "a", Title: "B站开播了", Src: live}, time.Second, true},
+		{"case live share dynamic", &push.Msg{Flag: BiliDynMsg, Author: "a", Title: "分享直播间",
+			Src: "https://t.bilibili.com/1", Text: live}, time.Minute, true},
+		{"case info change not deduped", &push.Msg{Flag: BiliLiveInfoMsg, Author: "a", Title: "直播间信息变更", Src: live}, time.Minute, false},
+		{"case live end", &push.Msg{Flag: BiliLiveMsg, Author: "a", Title: "B站下播了", Src: live}, 2 * time.Minute, false},
+		{"case video", &push.Msg{Flag: BiliDynMsg, Author: "a", Title: "投稿视频", Src: video}, 3 * time.Minute, false},
+		{"case video source", &push.Msg{Flag: BiliVideoMsg, Author: "a", Title: "投稿视频", Src: video}, 3 * time.Minute, true},
+		{"case repost", &push.Msg{Flag: BiliDynMsg, Author: "b", Title: "转发动态",
+			Src: "https://t.bilibili.com/2", Text: "转发自：@a", Refs: []string{video}}, 4 * time.Minute, true},
+		{"case milestone", &push.Msg{Flag: BiliVideoMsg, Author: "a", Title: "播放量突破10000", Src: video}, 5 * time.Minute, false},
+		{"case after window", &push.Msg{Flag: BiliDynMsg, Author: "c", Title: "转发动态",
+			Src: "https://t.bilibili.com/3", Text: video}, 2 * time.Hour, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, d.duplicate(test.msg, now.Add(test.after)))
+		})
+	}
+}
+
+func TestDeduper_Persist(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	SetStore(s)
+	defer SetStore(nil)
+	msg := &push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博", Src: "https://m.weibo.cn/status/1"}
+	now := time.Now()
+	assert.False(t, newDeduper(DedupOption{}).duplicate(msg, now))
+	//重启后仍然记得之前的消息
+	d := newDeduper(DedupOption{})
+	assert.True(t, d.duplicate(msg, now.Add(time.Minute)))
+
+	//距离上次持久化不到dedupSaveInterval时不保存，停止时保存
+	other := &push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博", Src: "https://m.weibo.cn/status/2"}
+	assert.False(t, d.duplicate(other, now.Add(time.Minute+time.Second)))
+	saved := make(map[string][]dedupEntry)
+	loadState(dedupStoreName, &saved)
+	assert.NotContains(t, saved, "url:https://m.weibo.cn/status/2")
+	d.flush()
+	loadState(dedupStoreName, &saved)
+	assert.Contains(t, saved, "url:https://m.weibo.cn/status/2")
+}
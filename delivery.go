@@ -0,0 +1,341 @@
+package forwardBot
+
+import (
+	"fmt"
+	"forwardBot/push"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pendingStoreName       = "pending_msgs"
+	defaultShutdownTimeout = 10 * time.Second //停止时等待消息发送完成的默认时间
+)
+
+// pendingMsg 停止时没有发送完成的消息，下次启动时重新发送
+type pendingMsg struct {
+	Sink string    `json:"sink"`
+	Msg  *push.Msg `json:"msg"`
+}
+
+// SinkStatus sink消息队列的状态
+type SinkStatus struct {
+	Name      string
+	Depth     int   //等待发送的消息数量，包括写入磁盘的消息
+	Spilled   int   //写入磁盘的消息数量
+	Dropped   int64 //队列满时丢弃的消息数量
+	Delivered int64 //发送完成的消息数量，包括发送失败的消息
+}
+
+// sinkWorker 按顺序向一个sink发送消息，每个sink有独立的队列
+type sinkWorker struct {
+	name    string
+	sink    Sink
+	opt     QueueOption
+	lock    sync.Mutex
+	cond    *sync.Cond  //队列、current或closed变化时通知
+	queue   []*push.Msg //内存中等待发送的消息
+	spill   *spillQueue //OverflowSpill时写入磁盘的消息，比queue中的消息新
+	current *push.Msg   //正在发送的消息
+	closed  bool        //停止后不再发送新的消息
+	status  SinkStatus
+}
+
+func newSinkWorker(name string, sink Sink, opt QueueOption) *sinkWorker {
+	if opt.Capacity <= 0 {
+		opt.Capacity = defaultQueueCapacity
+	}
+	switch opt.Overflow {
+	case "":
+		opt.Overflow = OverflowBlock
+	case OverflowBlock, OverflowDropOldest, OverflowSpill:
+	default:
+		logger.WithFields(logrus.Fields{
+			"name":     name,
+			"overflow": opt.Overflow,
+		}).Warn("不支持的队列溢出处理方式，使用block")
+		opt.Overflow = OverflowBlock
+	}
+	w := &sinkWorker{
+		name:   name,
+		sink:   sink,
+		opt:    opt,
+		status: SinkStatus{Name: name},
+	}
+	w.cond = sync.NewCond(&w.lock)
+	if opt.Overflow == OverflowSpill {
+		spill, err := newSpillQueue(opt.SpillDir, name)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"name": name,
+				"err":  err,
+			}).Error("创建磁盘队列失败，队列满时阻塞")
+			w.opt.Overflow = OverflowBlock
+		} else {
+			w.spill = spill
+		}
+	}
+	return w
+}
+
+// 把消息加入队列，队列满时按照设置阻塞、丢弃最早的消息或者写入磁盘，停止后只加入队列
+func (w *sinkWorker) push(msg *push.Msg) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for w.opt.Overflow == OverflowBlock && !w.closed && len(w.queue) >= w.opt.Capacity {
+		w.cond.Wait()
+	}
+	full := len(w.queue) >= w.opt.Capacity && !w.closed
+	switch {
+	case w.spill != nil && (full || w.spill.count != 0):
+		//磁盘中还有消息时新的消息也要写入磁盘，保证顺序
+		if err := w.spill.push(msg); err != nil {
+			logger.WithFields(logrus.Fields{
+				"name": w.name,
+				"err":  err,
+			}).Error("消息写入磁盘失败，加入内存队列")
+			w.queue = append(w.queue, msg)
+		}
+	case full && w.opt.Overflow == OverflowDropOldest:
+		logger.WithFields(logrus.Fields{
+			"name":  w.name,
+			"title": w.queue[0].Title,
+		}).Warn("sink队列已满，丢弃最早的消息")
+		w.queue[0] = nil
+		w.queue = append(w.queue[1:], msg)
+		w.status.Dropped++
+	default:
+		w.queue = append(w.queue, msg)
+	}
+	w.cond.Broadcast()
+}
+
+// 取出下一条消息，没有消息时等待，停止后返回false
+func (w *sinkWorker) next() (*push.Msg, bool) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for {
+		for !w.closed && len(w.queue) == 0 && (w.spill == nil || w.spill.count == 0) {
+			w.cond.Wait()
+		}
+		if w.closed {
+			return nil, false
+		}
+		var msg *push.Msg
+		if len(w.queue) != 0 {
+			msg = w.queue[0]
+			w.queue[0] = nil
+			w.queue = w.queue[1:]
+		} else {
+			var err error
+			if msg, err = w.spill.pop(); err != nil {
+				//读取文件失败时pop已经清空了磁盘队列，回到等待新消息
+				logger.WithFields(logrus.Fields{
+					"name": w.name,
+					"err":  err,
+				}).Error("从磁盘读取消息失败，丢弃消息")
+				continue
+			}
+		}
+		w.current = msg
+		w.cond.Broadcast()
+		return msg, true
+	}
+}
+
+// 当前消息发送结束，包括发送失败
+func (w *sinkWorker) done() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.current = nil
+	w.status.Delivered++
+	w.cond.Broadcast()
+}
+
+// 按顺序发送队列中的消息，直到停止
+func (w *sinkWorker) run(b *Bot) {
+	for {
+		msg, ok := w.next()
+		if !ok {
+			return
+		}
+		b.receive(w, msg)
+		w.done()
+	}
+}
+
+// 等待队列中的消息全部发送完成，或者停止
+func (w *sinkWorker) wait() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for !w.closed && (len(w.queue) != 0 || w.current != nil || (w.spill != nil && w.spill.count != 0)) {
+		w.cond.Wait()
+	}
+}
+
+// 停止发送新的消息，阻塞的push和wait会返回
+func (w *sinkWorker) close() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.closed = true
+	w.cond.Broadcast()
+}
+
+// 没有发送的消息，包括磁盘中的消息，必须在close之后调用
+// 正在发送的消息可能已经发送成功，不保存，避免下次启动时重复发送
+func (w *sinkWorker) pending() []*push.Msg {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.current != nil {
+		logger.WithFields(logrus.Fields{
+			"name":  w.name,
+			"title": w.current.Title,
+		}).Warn("停止时消息正在发送，不保存该消息")
+	}
+	msgs := append([]*push.Msg{}, w.queue...)
+	w.queue = nil
+	for w.spill != nil && w.spill.count != 0 {
+		msg, err := w.spill.pop()
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"name": w.name,
+				"err":  err,
+			}).Error("从磁盘读取消息失败，丢弃消息")
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func (w *sinkWorker) snapshot() SinkStatus {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	status := w.status
+	status.Depth = len(w.queue)
+	if w.spill != nil {
+		status.Spilled = w.spill.count
+		status.Depth += w.spill.count
+	}
+	return status
+}
+
+// 生成sink队列状态的文字说明，每个sink一行
+func sinkStatusText(status []SinkStatus) string {
+	text := strings.Builder{}
+	for i := range status {
+		s := &status[i]
+		if i != 0 {
+			text.WriteByte('\n')
+		}
+		text.WriteString(fmt.Sprintf("%s 等待发送%d条 已发送%d条", s.Name, s.Depth, s.Delivered))
+		if s.Spilled != 0 {
+			text.WriteString(fmt.Sprintf(" 磁盘中%d条", s.Spilled))
+		}
+		if s.Dropped != 0 {
+			text.WriteString(fmt.Sprintf(" 丢弃%d条", s.Dropped))
+		}
+	}
+	return text.String()
+}
+
+// 把消息加入所有sink的队列，启用去重时忽略重复的消息
+func (b *Bot) dispatch(msg *push.Msg) {
+	logger.WithFields(logrus.Fields{
+		"author":   msg.Author,
+		"title":    msg.Title,
+		"src":      msg.Src,
+		"len(img)": len(msg.Img),
+		"flag":     msg.Flag,
+	}).Info("接收到msg")
+	if b.dedup != nil && b.dedup.duplicate(msg, time.Now()) {
+		return
+	}
+	for _, w := range b.sinks {
+		w.push(msg)
+	}
+}
+
+// 重新发送上次停止时没有发送完成的消息
+func (b *Bot) restore() {
+	var pending []pendingMsg
+	loadState(pendingStoreName, &pending)
+	if len(pending) == 0 {
+		return
+	}
+	logger.WithField("len(pending)", len(pending)).Info("重新发送上次停止时未发送的消息")
+	for _, p := range pending {
+		w := b.sinkWorker(p.Sink)
+		if w == nil || p.Msg == nil {
+			logger.WithField("sink", p.Sink).Warn("未发送消息的sink不存在，丢弃消息")
+			continue
+		}
+		w.push(p.Msg)
+	}
+	saveState(pendingStoreName, []pendingMsg{})
+}
+
+// 停止所有source，在超时之前继续分发消息并等待sink队列中的消息发送完成，超时后保存没有发送的消息
+func (b *Bot) shutdown() {
+	logger.WithField("timeout", b.shutdownTimeout).Info("bot停止中")
+	//超时后停止所有sink，阻塞在队列上的分发和等待都会返回
+	timer := time.AfterFunc(b.shutdownTimeout, func() {
+		logger.Warn("bot停止超时")
+		for _, w := range b.sinks {
+			w.close()
+		}
+	})
+	defer timer.Stop()
+	stopped := make(chan struct{})
+	go func() {
+		for _, r := range b.sources {
+			_ = r.stop()
+		}
+		close(stopped)
+	}()
+	//source停止之前需要继续接收消息，避免source阻塞在发送消息上
+	timeout := time.NewTimer(b.shutdownTimeout)
+	defer timeout.Stop()
+	for waiting := true; waiting; {
+		select {
+		case msg := <-b.ch:
+			b.dispatch(msg)
+		case <-stopped:
+			waiting = false
+		case <-timeout.C:
+			logger.Warn("等待source停止超时")
+			waiting = false
+		}
+	}
+	for drained := false; !drained; {
+		select {
+		case msg := <-b.ch:
+			b.dispatch(msg)
+		default:
+			drained = true
+		}
+	}
+	for _, w := range b.sinks {
+		w.wait()
+	}
+	var pending []pendingMsg
+	for _, w := range b.sinks {
+		w.close()
+		for _, msg := range w.pending() {
+			//唤醒消息下次启动时由Run重新产生
+			if _, ok := wakeTarget(msg); ok {
+				continue
+			}
+			pending = append(pending, pendingMsg{Sink: w.name, Msg: msg})
+		}
+	}
+	if len(pending) != 0 {
+		logger.WithField("len(pending)", len(pending)).Warn("保存未发送的消息")
+		saveState(pendingStoreName, pending)
+	}
+	if b.dedup != nil {
+		b.dedup.flush()
+	}
+}
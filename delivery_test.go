@@ -0,0 +1,108 @@
+package forwardBot
+
+import (
+	"context"
+	"forwardBot/push"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// 发送固定消息后等待ctx结束的source
+type listSource struct {
+	msgs []*push.Msg
+}
+
+func (l *listSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	for _, msg := range l.msgs {
+		ch <- msg
+	}
+	<-ctx.Done()
+}
+
+// 每条消息需要等待一段时间才能发送完成的sink
+type slowSink struct {
+	delay time.Duration
+	ch    chan *push.Msg
+}
+
+func (s *slowSink) Receive(msg *push.Msg) error {
+	time.Sleep(s.delay)
+	s.ch <- msg
+	return nil
+}
+
+func TestBot_Shutdown(t *testing.T) {
+	msgs := []*push.Msg{{Title: "1"}, {Title: "2"}, {Title: "3"}}
+	sink := &slowSink{delay: 20 * time.Millisecond, ch: make(chan *push.Msg, 10)}
+	b := NewBot(0)
+	b.AppendSource(&listSource{msgs: msgs})
+	b.AppendSink(sink)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run没有返回")
+	}
+	//Run返回时所有消息都已经发送完成
+	assert.Len(t, sink.ch, len(msgs))
+	assert.False(t, b.Status()[0].Running)
+}
+
+func TestBot_Pending(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	SetStore(s)
+	defer SetStore(nil)
+
+	now := time.Now()
+	msgs := []*push.Msg{{Title: "1", Times: now}, {Title: "2", Times: now.Add(time.Second)},
+		{Title: "3", Times: now.Add(2 * time.Second)}}
+	slow := &slowSink{delay: 200 * time.Millisecond, ch: make(chan *push.Msg, 10)}
+	b := NewBot(0)
+	b.AppendSource(&listSource{msgs: msgs})
+	b.AppendSink(slow)
+	b.SetShutdownTimeout(50 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	b.Run(ctx)
+
+	//停止时正在发送的第一条消息可能已经发送成功，不保存
+	var pending []pendingMsg
+	assert.Nil(t, s.Load(pendingStoreName, &pending))
+	assert.Len(t, pending, len(msgs)-1)
+	for i, p := range pending {
+		assert.Equal(t, "*forwardBot.slowSink", p.Sink)
+		assert.Equal(t, msgs[i+1].Title, p.Msg.Title)
+	}
+
+	//下次启动时在source产生的新消息之前重新发送
+	fast := &slowSink{ch: make(chan *push.Msg, 10)}
+	b = NewBot(0)
+	b.AppendSource(&listSource{msgs: []*push.Msg{{Title: "new", Times: now.Add(time.Minute)}}})
+	b.AppendSink(fast)
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	b.Run(ctx)
+	var titles []string
+	for len(fast.ch) != 0 {
+		titles = append(titles, (<-fast.ch).Title)
+	}
+	assert.Equal(t, []string{"2", "3", "new"}, titles)
+	pending = nil
+	assert.Nil(t, s.Load(pendingStoreName, &pending))
+	assert.Len(t, pending, 0)
+}
@@ -0,0 +1,268 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"forwardBot/push"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	digestTextLen = 40 //汇总中每条消息内容保留的字数
+)
+
+// DigestOption DigestSink的设置
+type DigestOption struct {
+	Name        string         //名称，用于日志和持久化，多个DigestSink的名称不能相同
+	Every       time.Duration  //每隔一段时间发送一次汇总，为0时不按间隔发送
+	At          []string       //每天发送汇总的时间，格式为15:04
+	Location    *time.Location //At使用的时区，为nil时使用本地时区
+	Threshold   int            //缓存的消息数量达到该值时立即发送汇总，为0时不限制
+	Passthrough []int          //不汇总直接发送的消息类型，例如开播消息
+}
+
+var _ Sink = (*DigestSink)(nil)
+var _ SinkRunner = (*DigestSink)(nil)
+
+// DigestSink 缓存收到的消息，定时或者数量达到阈值时把消息合并成汇总发送给sink
+type DigestSink struct {
+	sink        Sink
+	opt         DigestOption
+	at          []time.Duration //每天发送汇总的时刻
+	passthrough map[int]bool
+	lock        sync.Mutex
+	buf         []*push.Msg
+	last        time.Time //上一次发送汇总的时间
+}
+
+func NewDigestSink(sink Sink, opt DigestOption) (*DigestSink, error) {
+	if opt.Name == "" {
+		opt.Name = "digest"
+	}
+	if opt.Location == nil {
+		opt.Location = time.Local
+	}
+	d := &DigestSink{
+		sink:        sink,
+		opt:         opt,
+		passthrough: make(map[int]bool, len(opt.Passthrough)),
+		last:        time.Now(),
+	}
+	for _, at := range opt.At {
+		t, err := time.Parse("15:04", at)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse digest time fail")
+		}
+		d.at = append(d.at, time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute)
+	}
+	sort.Slice(d.at, func(i, j int) bool {
+		return d.at[i] < d.at[j]
+	})
+	for _, flag := range opt.Passthrough {
+		d.passthrough[flag] = true
+	}
+	logger.WithFields(logrus.Fields{
+		"name":        opt.Name,
+		"every":       opt.Every,
+		"at":          opt.At,
+		"threshold":   opt.Threshold,
+		"passthrough": opt.Passthrough,
+	}).Info("[Digest]创建汇总sink")
+	loadState(d.storeName(), &d.buf)
+	return d, nil
+}
+
+func (d *DigestSink) Name() string {
+	return "[Digest]" + d.opt.Name
+}
+
+func (d *DigestSink) storeName() string {
+	return "digest_" + d.opt.Name
+}
+
+// Receive 直接发送的消息类型立即发送，其余消息缓存，数量达到阈值或者收到Run的唤醒消息时发送汇总
+func (d *DigestSink) Receive(msg *push.Msg) error {
+	if target, ok := wakeTarget(msg); ok {
+		if target != d.Name() {
+			return forwardWake(d.sink, msg)
+		}
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		//唤醒消息在队列中等待期间可能已经因为数量达到阈值发送过汇总
+		if due, ok := d.next(); ok && !due.After(msg.Times) {
+			return d.flush(time.Now())
+		}
+		return nil
+	}
+	if d.passthrough[msg.Flag] {
+		return d.sink.Receive(msg)
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.buf = append(d.buf, msg)
+	saveState(d.storeName(), d.buf)
+	if d.opt.Threshold > 0 && len(d.buf) >= d.opt.Threshold {
+		return d.flush(time.Now())
+	}
+	return nil
+}
+
+// Run 到设置的时间时唤醒sink发送汇总，汇总在sink的队列中发送，同时运行被包装的sink，ctx结束后返回
+func (d *DigestSink) Run(ctx context.Context, wake func(target string)) {
+	if r, ok := d.sink.(SinkRunner); ok {
+		go r.Run(ctx, wake)
+	}
+	var woken time.Time //上一次唤醒的时间，汇总发送之前不重复唤醒
+	for {
+		d.lock.Lock()
+		last := d.last
+		d.lock.Unlock()
+		if woken.After(last) {
+			last = woken
+		}
+		next, ok := d.nextAfter(last)
+		if !ok {
+			//只按数量发送汇总
+			<-ctx.Done()
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			woken = next
+			wake(d.Name())
+		}
+	}
+}
+
+// 下一次发送汇总的时间，没有设置间隔和每天的时间时返回false，必须持有锁
+func (d *DigestSink) next() (time.Time, bool) {
+	return d.nextAfter(d.last)
+}
+
+// last之后下一次发送汇总的时间
+func (d *DigestSink) nextAfter(last time.Time) (time.Time, bool) {
+	var next time.Time
+	if d.opt.Every > 0 {
+		next = last.Add(d.opt.Every)
+	}
+	if len(d.at) != 0 {
+		local := last.In(d.opt.Location)
+		day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, d.opt.Location)
+		for t := (time.Time{}); t.IsZero(); day = day.AddDate(0, 0, 1) {
+			for _, at := range d.at {
+				if c := day.Add(at); c.After(last) {
+					t = c
+					break
+				}
+			}
+			if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// 把缓存的消息按类型合并成汇总发送，必须持有锁
+// 发送失败的类型的消息留在缓存中，下次汇总时重新发送
+func (d *DigestSink) flush(now time.Time) error {
+	d.last = now
+	if len(d.buf) == 0 {
+		return nil
+	}
+	logger.WithFields(logrus.Fields{
+		"name":     d.opt.Name,
+		"len(buf)": len(d.buf),
+	}).Info("[Digest]发送汇总")
+	failed := make(map[int]bool)
+	var errs []string
+	for _, msg := range digestMsgs(d.buf, now) {
+		if err := d.sink.Receive(msg); err != nil {
+			failed[msg.Flag] = true
+			errs = append(errs, err.Error())
+		}
+	}
+	var rest []*push.Msg
+	for _, msg := range d.buf {
+		if failed[msg.Flag] {
+			rest = append(rest, msg)
+		}
+	}
+	d.buf = rest
+	saveState(d.storeName(), d.buf)
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// 把消息按类型分组，每组生成一条汇总消息，保证订阅了不同类型的频道只收到对应的消息
+// 已经是汇总的消息原样放在最前面，例如汇总后又在免打扰时段内被暂存的消息
+func digestMsgs(msgs []*push.Msg, now time.Time) []*push.Msg {
+	groups := make(map[int][]*push.Msg)
+	var flags []int
+	var digests []*push.Msg
+	for _, msg := range msgs {
+		if msg.Digest {
+			digests = append(digests, msg)
+			continue
+		}
+		if _, ok := groups[msg.Flag]; !ok {
+			flags = append(flags, msg.Flag)
+		}
+		groups[msg.Flag] = append(groups[msg.Flag], msg)
+	}
+	for _, flag := range flags {
+		group := groups[flag]
+		sort.SliceStable(group, func(i, j int) bool {
+			return group[i].Times.Before(group[j].Times)
+		})
+		digests = append(digests, &push.Msg{
+			Times:  now,
+			Flag:   flag,
+			Author: "Bot",
+			Title:  fmt.Sprintf("消息汇总（%d条）", len(group)),
+			Text:   renderDigest(group),
+			Digest: true,
+		})
+	}
+	return digests
+}
+
+// 每条消息渲染为时间、作者、标题和截断的内容、链接
+func renderDigest(msgs []*push.Msg) string {
+	text := strings.Builder{}
+	for i, msg := range msgs {
+		if i != 0 {
+			text.WriteByte('\n')
+		}
+		text.WriteString(fmt.Sprintf("%d. [%s] %s %s", i+1, msg.Times.Format("01-02 15:04"), msg.Author, msg.Title))
+		if content := truncate(strings.Join(strings.Fields(msg.Text), " "), digestTextLen); content != "" {
+			text.WriteString("\n   ")
+			text.WriteString(content)
+		}
+		if msg.Src != "" {
+			text.WriteString("\n   ")
+			text.WriteString(msg.Src)
+		}
+	}
+	return text.String()
+}
+
+// 截断到n个字，超出时添加省略号
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "…"
+}
@@ -0,0 +1,177 @@
+package forwardBot
+
+import (
+	"context"
+	"forwardBot/push"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDigestSink_Next(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	last := time.Date(2022, 10, 1, 12, 30, 0, 0, loc)
+	tests := []struct {
+		name  string
+		every time.Duration
+		at    []string
+		want  time.Time
+		ok    bool
+	}{
+		{"case none", 0, nil, time.Time{}, false},
+		{"case every", 2 * time.Hour, nil, last.Add(2 * time.Hour), true},
+		{"case at today", 0, []string{"20:00", "08:00"}, time.Date(2022, 10, 1, 20, 0, 0, 0, loc), true},
+		{"case at tomorrow", 0, []string{"08:00"}, time.Date(2022, 10, 2, 8, 0, 0, 0, loc), true},
+		{"case every before at", time.Hour, []string{"20:00"}, last.Add(time.Hour), true},
+		{"case at before every", 24 * time.Hour, []string{"20:00"}, time.Date(2022, 10, 1, 20, 0, 0, 0, loc), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := NewDigestSink(nil, DigestOption{Every: test.every, At: test.at, Location: loc})
+			assert.Nil(t, err)
+			d.last = last
+			next, ok := d.next()
+			assert.Equal(t, test.ok, ok)
+			assert.True(t, test.want.Equal(next), "want %v, got %v", test.want, next)
+		})
+	}
+	_, err := NewDigestSink(nil, DigestOption{At: []string{"8点"}})
+	assert.NotNil(t, err)
+}
+
+func TestDigestSink_Receive(t *testing.T) {
+	sink := &recordSink{ch: make(chan *push.Msg, 10)}
+	d, err := NewDigestSink(sink, DigestOption{Threshold: 3, Passthrough: []int{BiliLiveMsg}})
+	assert.Nil(t, err)
+	now := time.Date(2022, 10, 1, 20, 0, 0, 0, time.Local)
+	//开播消息直接发送
+	live := &push.Msg{Flag: BiliLiveMsg, Author: "a", Title: "B站开播了"}
+	assert.Nil(t, d.Receive(live))
+	assert.Equal(t, live, <-sink.ch)
+
+	assert.Nil(t, d.Receive(&push.Msg{Times: now.Add(time.Minute), Flag: BiliDynMsg, Author: "a", Title: "发布动态",
+		Text: "  这是一条很长很长很长很长很长很长很长很长很长很长很长很长很长\n很长的动态内容  ", Src: "https://t.bilibili.com/1"}))
+	assert.Nil(t, d.Receive(&push.Msg{Times: now, Flag: WeiboMsg, Author: "a", Title: "发布微博"}))
+	assert.Empty(t, sink.ch)
+	//数量达到阈值时按类型发送汇总
+	assert.Nil(t, d.Receive(&push.Msg{Times: now, Flag: BiliDynMsg, Author: "b", Title: "转发动态", Src: "https://t.bilibili.com/2"}))
+	assert.Len(t, sink.ch, 2)
+	dyn := <-sink.ch
+	assert.Equal(t, BiliDynMsg, dyn.Flag)
+	assert.Equal(t, "消息汇总（2条）", dyn.Title)
+	assert.Equal(t, "1. [10-01 20:00] b 转发动态\n"+
+		"   https://t.bilibili.com/2\n"+
+		"2. [10-01 20:01] a 发布动态\n"+
+		"   这是一条很长很长很长很长很长很长很长很长很长很长很长很长很长 很长的动态内容\n"+
+		"   https://t.bilibili.com/1", dyn.Text)
+	weibo := <-sink.ch
+	assert.Equal(t, WeiboMsg, weibo.Flag)
+	assert.Equal(t, "1. [10-01 20:00] a 发布微博", weibo.Text)
+	assert.Empty(t, d.buf)
+}
+
+func TestDigestSink_Persist(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	SetStore(s)
+	defer SetStore(nil)
+	sink := &recordSink{ch: make(chan *push.Msg, 10)}
+	d, err := NewDigestSink(sink, DigestOption{Name: "test"})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Receive(&push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博"}))
+	//重启后继续汇总之前缓存的消息
+	d, err = NewDigestSink(sink, DigestOption{Name: "test"})
+	assert.Nil(t, err)
+	assert.Len(t, d.buf, 1)
+	d.lock.Lock()
+	assert.Nil(t, d.flush(time.Now()))
+	d.lock.Unlock()
+	assert.Equal(t, "消息汇总（1条）", (<-sink.ch).Title)
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		n    int
+		want string
+	}{
+		{"case short", "你好", 2, "你好"},
+		{"case long", "你好世界", 2, "你好…"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, truncate(test.s, test.n))
+		})
+	}
+}
+
+// 发送指定类型的消息时返回错误的sink
+type failSink struct {
+	recordSink
+	flag int
+}
+
+func (f *failSink) Receive(msg *push.Msg) error {
+	if msg.Flag == f.flag {
+		return errors.New("send fail")
+	}
+	return f.recordSink.Receive(msg)
+}
+
+func TestDigestSink_FlushFail(t *testing.T) {
+	sink := &failSink{recordSink: recordSink{ch: make(chan *push.Msg, 10)}, flag: WeiboMsg}
+	d, err := NewDigestSink(sink, DigestOption{})
+	assert.Nil(t, err)
+	weibo := &push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博"}
+	assert.Nil(t, d.Receive(weibo))
+	assert.Nil(t, d.Receive(&push.Msg{Flag: BiliDynMsg, Author: "a", Title: "发布动态"}))
+	d.lock.Lock()
+	assert.NotNil(t, d.flush(time.Now()))
+	d.lock.Unlock()
+	//发送失败的消息留在缓存中
+	assert.Equal(t, "消息汇总（1条）", (<-sink.ch).Title)
+	assert.Equal(t, []*push.Msg{weibo}, d.buf)
+}
+
+func TestDigestSink_Wake(t *testing.T) {
+	sink := &recordSink{ch: make(chan *push.Msg, 10)}
+	d, err := NewDigestSink(sink, DigestOption{Every: 20 * time.Millisecond})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Receive(&push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博"}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	targets := make(chan string, 10)
+	go d.Run(ctx, func(target string) {
+		targets <- target
+	})
+	//Run只唤醒，不直接发送汇总
+	target := <-targets
+	assert.Equal(t, d.Name(), target)
+	assert.Empty(t, sink.ch)
+	assert.Nil(t, d.Receive(wakeMsg(target)))
+	assert.Equal(t, "消息汇总（1条）", (<-sink.ch).Title)
+	//其他sink的唤醒消息不会发送汇总
+	assert.Nil(t, d.Receive(wakeMsg("other")))
+	assert.Empty(t, sink.ch)
+}
+
+func TestBot_DigestPanic(t *testing.T) {
+	d, err := NewDigestSink(panicSink{}, DigestOption{Every: 10 * time.Millisecond})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Receive(&push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博"}))
+	b := NewBot(1)
+	b.AppendSink(d)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+	//定时发送的汇总在sink的队列中发送，panic被恢复
+	assert.Eventually(t, func() bool {
+		return b.SinkPanics()[d.Name()] >= 1
+	}, time.Second, 5*time.Millisecond)
+	//发送失败的消息没有丢失
+	d.lock.Lock()
+	assert.Len(t, d.buf, 1)
+	d.lock.Unlock()
+}
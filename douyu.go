@@ -0,0 +1,131 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"forwardBot/push"
+	"forwardBot/req"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"strconv"
+	"time"
+)
+
+const (
+	douyuRoomUrl  = "https://www.douyu.com/betard/"
+	douyuLiveUrl  = "https://www.douyu.com/"
+	douyuHost     = "www.douyu.com"
+	douyuLiveOn   = 1 //show_status为1时开播
+	douyuLoopPlay = 1 //videoLoop为1时是录播轮播
+)
+
+var _ Source = (*DouyuLiveSource)(nil)
+var _ Named = (*DouyuLiveSource)(nil)
+
+// DouyuLiveSource 获取斗鱼直播间开播状态
+type DouyuLiveSource struct {
+	*liveTracker
+	health
+	room  []int
+	sched *scheduler
+}
+
+func NewDouyuLiveSource(room []int) *DouyuLiveSource {
+	logger.WithFields(logrus.Fields{
+		"room": room,
+	}).Info("[Douyu]监控斗鱼开播状态")
+	return &DouyuLiveSource{
+		liveTracker: newLiveTracker("[Douyu]", "斗鱼", DouyuLiveMsg),
+		room:        append([]int{}, room...),
+		sched:       newScheduler("[Douyu]", douyuHost, interval),
+	}
+}
+
+func (d *DouyuLiveSource) Name() string {
+	return "[Douyu]"
+}
+
+func (d *DouyuLiveSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	schedule(ctx, d.sched, d.room, func(id int, now time.Time) {
+		d.poll(id, now, ch)
+	})
+	logger.Info("[Douyu]停止监控斗鱼直播间")
+}
+
+// SetInterval 设置轮询间隔，rooms为单独设置间隔的房间号，必须在Send方法之前调用
+func (d *DouyuLiveSource) SetInterval(i time.Duration, rooms map[string]time.Duration) {
+	d.sched.setInterval(i, rooms)
+}
+
+// SetAdaptive 在主播通常开播的时间前后使用更短的轮询间隔，必须在Send方法之前调用
+func (d *DouyuLiveSource) SetAdaptive(fast, window time.Duration) {
+	d.sched.setAdaptive(d.adaptiveInterval(fast, window))
+}
+
+// 获取一个直播间的开播状态并推送
+func (d *DouyuLiveSource) poll(id int, now time.Time, ch chan<- *push.Msg) {
+	info, err := getDouyuRoomInfo(id)
+	if err != nil {
+		d.fail(id, err)
+		logger.WithFields(logrus.Fields{
+			"id":  id,
+			"err": err,
+		}).Error("[Douyu]获取斗鱼开播状态失败")
+		return
+	}
+	d.succeed(id)
+	msg := d.update(strconv.Itoa(id), info, now)
+	info.Reset()
+	liveInfoPool.Put(info)
+	if msg != nil {
+		ch <- msg
+	}
+}
+
+// 获取斗鱼直播间信息
+func getDouyuRoomInfo(roomId int) (*LiveInfo, error) {
+	resp, err := req.Get(douyuRoomUrl+strconv.Itoa(roomId), nil)
+	if err != nil {
+		return nil, err
+	}
+	result, err := checkResp(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "read douyu resp data fail")
+	}
+	return parseDouyuRoom(roomId, result)
+}
+
+func parseDouyuRoom(roomId int, result *gjson.Result) (*LiveInfo, error) {
+	room := result.Get("room")
+	if !room.IsObject() {
+		logger.WithFields(logrus.Fields{
+			"roomId": roomId,
+			"resp":   result.String(),
+		}).Error("[Douyu]获取room失败")
+		return nil, errors.New("not exists room object")
+	}
+	status := room.Get("show_status")
+	if !status.Exists() {
+		logger.WithFields(logrus.Fields{
+			"roomId": roomId,
+			"room":   room.String(),
+		}).Error("[Douyu]获取room.show_status失败")
+		return nil, errors.New("not exists room.show_status")
+	}
+	info := liveInfoPool.Get().(*LiveInfo)
+	info.Uname = room.Get("nickname").String()
+	info.RoomId = roomId
+	info.Link = fmt.Sprintf("%s%d", douyuLiveUrl, roomId)
+	//videoLoop为1时是录播轮播，不算开播
+	info.LiveStatus = status.Int() == douyuLiveOn && room.Get("videoLoop").Int() != douyuLoopPlay
+	if info.LiveStatus {
+		info.Title = room.Get("room_name").String()
+		info.Area = room.Get("second_lvl_name").String()
+		info.Cover = room.Get("room_pic").String()
+		if start := room.Get("show_time").Int(); start != 0 {
+			info.StartTime = time.Unix(start, 0)
+		}
+	}
+	return info, nil
+}
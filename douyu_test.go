@@ -0,0 +1,37 @@
+package forwardBot
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+func TestParseDouyuRoom(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		living bool
+	}{
+		{"case living", `{"room":{"nickname":"up","show_status":1,"videoLoop":0,"room_name":"title","second_lvl_name":"英雄联盟","room_pic":"pic.jpg"}}`, true},
+		{"case loop play", `{"room":{"nickname":"up","show_status":1,"videoLoop":1}}`, false},
+		{"case offline", `{"room":{"nickname":"up","show_status":2,"videoLoop":0}}`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := gjson.Parse(test.in)
+			info, err := parseDouyuRoom(9999, &r)
+			if assert.Nil(t, err) {
+				assert.Equal(t, test.living, info.LiveStatus)
+				assert.Equal(t, "up", info.Uname)
+				assert.Equal(t, "https://www.douyu.com/9999", info.Link)
+				if test.living {
+					assert.Equal(t, "title", info.Title)
+					assert.Equal(t, "英雄联盟", info.Area)
+				}
+			}
+		})
+	}
+	r := gjson.Parse(`{"error":-1}`)
+	_, err := parseDouyuRoom(9999, &r)
+	assert.NotNil(t, err)
+}
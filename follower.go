@@ -0,0 +1,297 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"forwardBot/push"
+	"forwardBot/req"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	userCardUrl        = "https://api.bilibili.com/x/web-interface/card"
+	guardTopListUrl    = "https://api.live.bilibili.com/xlive/app-room/v2/guardTab/topList"
+	spaceUrlPrefix     = "https://space.bilibili.com/"
+	followerStoreName  = "bili_follower"
+	followerInterval   = 10 * time.Minute //默认轮询间隔，粉丝数不需要频繁获取
+	followerRecordGap  = time.Hour        //历史记录的最小间隔
+	followerHistoryAge = 30 * 24 * time.Hour
+	followerDayLayout  = "2006-01-02"
+)
+
+// BiliFollowerOption BiliFollowerSource的配置
+type BiliFollowerOption struct {
+	Uid       []int64
+	Rooms     map[int64]int //用户对应的直播间号，设置后同时获取舰长数
+	Step      int64         //粉丝数里程碑间隔，例如10000，为0时不推送
+	GuardStep int64         //舰长数里程碑间隔，为0时不推送
+	Percent   float64       //24小时内粉丝数变化超过该百分比时推送，为0时不推送
+	Interval  time.Duration //轮询间隔，为0时使用默认值
+}
+
+// FollowerRecord 某一时刻的粉丝数和舰长数
+type FollowerRecord struct {
+	Time     time.Time `json:"time"`
+	Follower int64     `json:"follower"`
+	Guard    int64     `json:"guard"`
+}
+
+// followerHistory 用户粉丝数的历史记录
+type followerHistory struct {
+	Records []FollowerRecord `json:"records"` //按时间升序，间隔不小于followerRecordGap
+	Last    FollowerRecord   `json:"last"`    //最近一次获取的数据
+	Alerted string           `json:"alerted"` //最近一次推送日变化的日期
+	//已经达到的最大的粉丝数和舰长数里程碑，数量回落后再次突破时不重复推送
+	FollowerReached int64 `json:"followerReached"`
+	GuardReached    int64 `json:"guardReached"`
+}
+
+var _ Source = (*BiliFollowerSource)(nil)
+var _ Named = (*BiliFollowerSource)(nil)
+
+// BiliFollowerSource 定时获取b站用户的粉丝数，推送里程碑和日变化
+type BiliFollowerSource struct {
+	health
+	opt     BiliFollowerOption
+	history map[int64]*followerHistory
+	lock    sync.Mutex //保护history
+	sched   *scheduler
+}
+
+func NewBiliFollowerSource(opt BiliFollowerOption) *BiliFollowerSource {
+	logger.WithFields(logrus.Fields{
+		"uid":       opt.Uid,
+		"rooms":     opt.Rooms,
+		"step":      opt.Step,
+		"guardStep": opt.GuardStep,
+		"percent":   opt.Percent,
+	}).Info("[BiliFollower]监控b站粉丝数")
+	if opt.Interval <= 0 {
+		opt.Interval = followerInterval
+	}
+	b := &BiliFollowerSource{
+		opt:     opt,
+		history: make(map[int64]*followerHistory),
+		sched:   newScheduler("[BiliFollower]", biliApiHost, opt.Interval),
+	}
+	loadState(followerStoreName, &b.history)
+	return b
+}
+
+// History 用户粉丝数的历史记录
+func (b *BiliFollowerSource) History(uid int64) []FollowerRecord {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	h, ok := b.history[uid]
+	if !ok {
+		return nil
+	}
+	return append([]FollowerRecord{}, h.Records...)
+}
+
+func (b *BiliFollowerSource) Name() string {
+	return "[BiliFollower]"
+}
+
+func (b *BiliFollowerSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	schedule(ctx, b.sched, b.opt.Uid, func(id int64, now time.Time) {
+		b.poll(id, now, ch)
+	})
+	logger.Info("[BiliFollower]停止监控b站粉丝数")
+}
+
+// 获取一个用户的粉丝数并推送
+func (b *BiliFollowerSource) poll(id int64, now time.Time, ch chan<- *push.Msg) {
+	name, record, err := b.stat(id)
+	if err != nil {
+		b.fail(id, err)
+		logger.WithFields(logrus.Fields{
+			"id":  id,
+			"err": err,
+		}).Error("[BiliFollower]获取b站粉丝数失败")
+		return
+	}
+	b.succeed(id)
+	record.Time = now
+	for _, msg := range b.check(id, name, record) {
+		ch <- msg
+	}
+	b.lock.Lock()
+	saveState(followerStoreName, b.history)
+	b.lock.Unlock()
+}
+
+// 获取用户名称、粉丝数，设置了直播间时同时获取舰长数
+func (b *BiliFollowerSource) stat(uid int64) (name string, record FollowerRecord, err error) {
+	resp, err := biliClient.Get(userCardUrl, req.D{{"mid", uid}}, false)
+	if err != nil {
+		return
+	}
+	result, err := checkResp(resp)
+	if err != nil {
+		err = errors.Wrap(err, "read bili resp data fail")
+		return
+	}
+	data, code, msg := checkBiliData(result)
+	if code != 0 {
+		err = errors.New(fmt.Sprintf("code=%d,msg=%s", code, msg))
+		return
+	}
+	follower := data.Get("follower")
+	if !follower.Exists() {
+		err = errors.New("不存在data.follower字段")
+		return
+	}
+	name = data.Get("card.name").String()
+	record.Follower = follower.Int()
+	if room, ok := b.opt.Rooms[uid]; ok {
+		record.Guard, err = getGuardNum(room, uid)
+		if err != nil {
+			//舰长数获取失败时不影响粉丝数
+			logger.WithFields(logrus.Fields{
+				"uid":  uid,
+				"room": room,
+				"err":  err,
+			}).Warn("[BiliFollower]获取舰长数失败")
+			record.Guard = b.last(uid).Guard
+			err = nil
+		}
+	}
+	return
+}
+
+// 获取直播间的舰长数
+func getGuardNum(roomId int, uid int64) (int64, error) {
+	resp, err := biliClient.Get(guardTopListUrl, req.D{
+		{"roomid", roomId},
+		{"ruid", uid},
+		{"page", 1},
+		{"page_size", 1},
+	}, false)
+	if err != nil {
+		return 0, err
+	}
+	result, err := checkResp(resp)
+	if err != nil {
+		return 0, errors.Wrap(err, "read bili resp data fail")
+	}
+	data, code, msg := checkBiliData(result)
+	if code != 0 {
+		return 0, errors.New(fmt.Sprintf("code=%d,msg=%s", code, msg))
+	}
+	num := data.Get("info.num")
+	if !num.Exists() {
+		return 0, errors.New("不存在data.info.num字段")
+	}
+	return num.Int(), nil
+}
+
+func (b *BiliFollowerSource) last(uid int64) FollowerRecord {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if h, ok := b.history[uid]; ok {
+		return h.Last
+	}
+	return FollowerRecord{}
+}
+
+// 记录新获取的数据，返回需要推送的消息，第一次获取时只记录
+func (b *BiliFollowerSource) check(uid int64, name string, record FollowerRecord) (msgs []*push.Msg) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	h, ok := b.history[uid]
+	if !ok {
+		h = &followerHistory{}
+		b.history[uid] = h
+	}
+	last := h.Last
+	h.Last = record
+	b.record(h, record)
+	if last.Time.IsZero() {
+		h.FollowerReached = milestone(record.Follower, b.opt.Step)
+		h.GuardReached = milestone(record.Guard, b.opt.GuardStep)
+		return nil
+	}
+	link := fmt.Sprintf("%s%d", spaceUrlPrefix, uid)
+	newMsg := func(title, text string) *push.Msg {
+		return &push.Msg{
+			Times:  record.Time,
+			Flag:   BiliFollowerMsg,
+			Author: name,
+			Title:  title,
+			Text:   text,
+			Src:    link,
+		}
+	}
+	if reached, ok := crossed(&h.FollowerReached, last.Follower, record.Follower, b.opt.Step); ok {
+		msgs = append(msgs, newMsg(fmt.Sprintf("粉丝数突破%d", reached),
+			fmt.Sprintf("当前粉丝数：%d", record.Follower)))
+	}
+	if _, watch := b.opt.Rooms[uid]; watch {
+		if reached, ok := crossed(&h.GuardReached, last.Guard, record.Guard, b.opt.GuardStep); ok {
+			msgs = append(msgs, newMsg(fmt.Sprintf("舰长数突破%d", reached),
+				fmt.Sprintf("当前舰长数：%d", record.Guard)))
+		}
+	}
+	if b.opt.Percent > 0 {
+		day := record.Time.Format(followerDayLayout)
+		base, ok := baseline(h.Records, record.Time.Add(-24*time.Hour))
+		if ok && base.Follower > 0 && h.Alerted != day {
+			diff := record.Follower - base.Follower
+			percent := float64(diff) / float64(base.Follower) * 100
+			if percent >= b.opt.Percent || -percent >= b.opt.Percent {
+				h.Alerted = day
+				msgs = append(msgs, newMsg(fmt.Sprintf("粉丝数24小时内变化%+.2f%%", percent),
+					fmt.Sprintf("24小时前：%d\n当前：%d\n变化：%+d", base.Follower, record.Follower, diff)))
+			}
+		}
+	}
+	return msgs
+}
+
+// 添加历史记录，距离上一条记录不足followerRecordGap时不记录，并删除过期的记录
+func (b *BiliFollowerSource) record(h *followerHistory, record FollowerRecord) {
+	if n := len(h.Records); n == 0 || record.Time.Sub(h.Records[n-1].Time) >= followerRecordGap {
+		h.Records = append(h.Records, record)
+	}
+	expired := 0
+	for expired < len(h.Records) && record.Time.Sub(h.Records[expired].Time) > followerHistoryAge {
+		expired++
+	}
+	h.Records = h.Records[expired:]
+}
+
+// 不超过n的最大的step的整数倍
+func milestone(n, step int64) int64 {
+	if step <= 0 || n <= 0 {
+		return 0
+	}
+	return n / step * step
+}
+
+// 判断cur是否突破了比reached更大的step的整数倍，突破时更新reached并返回突破的最大值，
+// 没有记录reached的旧数据使用上一次获取的数量last作为已经达到的里程碑
+func crossed(reached *int64, last, cur, step int64) (int64, bool) {
+	if *reached == 0 {
+		*reached = milestone(last, step)
+	}
+	m := milestone(cur, step)
+	if m <= *reached {
+		return 0, false
+	}
+	*reached = m
+	return m, true
+}
+
+// 获取不晚于t的最后一条记录作为比较的基准
+func baseline(records []FollowerRecord, t time.Time) (FollowerRecord, bool) {
+	for i := len(records) - 1; i >= 0; i-- {
+		if !records[i].Time.After(t) {
+			return records[i], true
+		}
+	}
+	return FollowerRecord{}, false
+}
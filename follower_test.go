@@ -0,0 +1,92 @@
+package forwardBot
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBiliFollowerSource_Check(t *testing.T) {
+	b := &BiliFollowerSource{
+		opt: BiliFollowerOption{
+			Rooms:     map[int64]int{1: 100},
+			Step:      10000,
+			GuardStep: 100,
+			Percent:   5,
+		},
+		history: make(map[int64]*followerHistory),
+	}
+	start := time.Date(2022, 10, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		after    time.Duration
+		follower int64
+		guard    int64
+		titles   []string
+	}{
+		{"case first", 0, 9990, 95, nil},
+		{"case follower milestone", time.Hour, 10010, 95, []string{"粉丝数突破10000"}},
+		{"case guard milestone", 2 * time.Hour, 10020, 101, []string{"舰长数突破100"}},
+		{"case decrease", 3 * time.Hour, 9990, 99, nil},
+		{"case cross again", 4 * time.Hour, 10010, 99, nil},
+		{"case daily change", 25 * time.Hour, 11000, 99, []string{"粉丝数24小时内变化+9.89%"}},
+		{"case alerted today", 26 * time.Hour, 20010, 99, []string{"粉丝数突破20000"}},
+		{"case daily decrease", 50 * time.Hour, 18000, 99, []string{"粉丝数24小时内变化-10.04%"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msgs := b.check(1, "up", FollowerRecord{
+				Time:     start.Add(test.after),
+				Follower: test.follower,
+				Guard:    test.guard,
+			})
+			var titles []string
+			for _, msg := range msgs {
+				assert.Equal(t, BiliFollowerMsg, msg.Flag)
+				assert.Equal(t, "up", msg.Author)
+				assert.Equal(t, "https://space.bilibili.com/1", msg.Src)
+				titles = append(titles, msg.Title)
+			}
+			assert.Equal(t, test.titles, titles)
+		})
+	}
+	assert.Len(t, b.History(1), len(tests))
+}
+
+// 粉丝数在里程碑附近来回波动时只推送一次
+func TestBiliFollowerSource_Oscillate(t *testing.T) {
+	b := &BiliFollowerSource{
+		opt:     BiliFollowerOption{Step: 100000},
+		history: make(map[int64]*followerHistory),
+	}
+	start := time.Date(2022, 10, 1, 12, 0, 0, 0, time.Local)
+	var titles []string
+	for i, follower := range []int64{99999, 100001, 99998, 100002, 99997, 200001} {
+		record := FollowerRecord{Time: start.Add(time.Duration(i) * time.Hour), Follower: follower}
+		for _, msg := range b.check(1, "up", record) {
+			titles = append(titles, msg.Title)
+		}
+	}
+	assert.Equal(t, []string{"粉丝数突破100000", "粉丝数突破200000"}, titles)
+	assert.Equal(t, int64(200000), b.history[1].FollowerReached)
+
+	//没有记录里程碑的旧数据从上一次的数量开始
+	b.history[2] = &followerHistory{Last: FollowerRecord{Time: start, Follower: 100001}}
+	assert.Empty(t, b.check(2, "up", FollowerRecord{Time: start.Add(time.Hour), Follower: 100002}))
+	assert.Equal(t, int64(100000), b.history[2].FollowerReached)
+}
+
+func TestFileStore(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if !assert.Nil(t, err) {
+		return
+	}
+	v := map[string]int{"a": 1}
+	assert.Nil(t, s.Load("missing", &v))
+	assert.Equal(t, map[string]int{"a": 1}, v)
+
+	assert.Nil(t, s.Save("test", map[string]int{"b": 2}))
+	var got map[string]int
+	assert.Nil(t, s.Load("test", &got))
+	assert.Equal(t, map[string]int{"b": 2}, got)
+}
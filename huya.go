@@ -0,0 +1,135 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"forwardBot/push"
+	"forwardBot/req"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"time"
+)
+
+const (
+	huyaRoomUrl = "https://mp.huya.com/cache.php"
+	huyaLiveUrl = "https://www.huya.com/"
+	huyaHost    = "mp.huya.com"
+	huyaLiveOn  = "ON"
+)
+
+var _ Source = (*HuyaLiveSource)(nil)
+var _ Named = (*HuyaLiveSource)(nil)
+
+// HuyaLiveSource 获取虎牙直播间开播状态
+type HuyaLiveSource struct {
+	*liveTracker
+	health
+	room  []string
+	sched *scheduler
+}
+
+func NewHuyaLiveSource(room []string) *HuyaLiveSource {
+	logger.WithFields(logrus.Fields{
+		"room": room,
+	}).Info("[Huya]监控虎牙开播状态")
+	return &HuyaLiveSource{
+		liveTracker: newLiveTracker("[Huya]", "虎牙", HuyaLiveMsg),
+		room:        append([]string{}, room...),
+		sched:       newScheduler("[Huya]", huyaHost, interval),
+	}
+}
+
+func (h *HuyaLiveSource) Name() string {
+	return "[Huya]"
+}
+
+func (h *HuyaLiveSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	schedule(ctx, h.sched, h.room, func(id string, now time.Time) {
+		h.poll(id, now, ch)
+	})
+	logger.Info("[Huya]停止监控虎牙直播间")
+}
+
+// SetInterval 设置轮询间隔，rooms为单独设置间隔的房间号，必须在Send方法之前调用
+func (h *HuyaLiveSource) SetInterval(d time.Duration, rooms map[string]time.Duration) {
+	h.sched.setInterval(d, rooms)
+}
+
+// SetAdaptive 在主播通常开播的时间前后使用更短的轮询间隔，必须在Send方法之前调用
+func (h *HuyaLiveSource) SetAdaptive(fast, window time.Duration) {
+	h.sched.setAdaptive(h.adaptiveInterval(fast, window))
+}
+
+// 获取一个直播间的开播状态并推送
+func (h *HuyaLiveSource) poll(id string, now time.Time, ch chan<- *push.Msg) {
+	info, err := getHuyaRoomInfo(id)
+	if err != nil {
+		h.fail(id, err)
+		logger.WithFields(logrus.Fields{
+			"id":  id,
+			"err": err,
+		}).Error("[Huya]获取虎牙开播状态失败")
+		return
+	}
+	h.succeed(id)
+	msg := h.update(id, info, now)
+	info.Reset()
+	liveInfoPool.Put(info)
+	if msg != nil {
+		ch <- msg
+	}
+}
+
+// 获取虎牙直播间信息，roomId可以是数字房间号或者自定义的房间名
+func getHuyaRoomInfo(roomId string) (*LiveInfo, error) {
+	resp, err := req.Get(huyaRoomUrl, req.D{
+		{"m", "Live"},
+		{"do", "profileRoom"},
+		{"roomid", roomId},
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, err := checkResp(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "read huya resp data fail")
+	}
+	return parseHuyaRoom(roomId, result)
+}
+
+func parseHuyaRoom(roomId string, result *gjson.Result) (*LiveInfo, error) {
+	if status := result.Get("status").Int(); status != 200 {
+		return nil, errors.New(fmt.Sprintf("status=%d,message=%s", status, result.Get("message").String()))
+	}
+	data := result.Get("data")
+	status := data.Get("liveStatus")
+	if !status.Exists() {
+		logger.WithFields(logrus.Fields{
+			"roomId": roomId,
+			"resp":   result.String(),
+		}).Error("[Huya]获取data.liveStatus失败")
+		return nil, errors.New("not exists data.liveStatus")
+	}
+	info := liveInfoPool.Get().(*LiveInfo)
+	info.Uname = data.Get("profileInfo.nick").String()
+	info.MidStr = data.Get("profileInfo.uid").String()
+	info.RoomIdStr = roomId
+	info.Link = huyaLiveUrl + roomId
+	//REPLAY为重播，不算开播
+	info.LiveStatus = status.String() == huyaLiveOn
+	if info.LiveStatus {
+		liveData := data.Get("liveData")
+		if info.Uname == "" {
+			info.Uname = liveData.Get("nick").String()
+		}
+		info.Title = liveData.Get("introduction").String()
+		info.Area = liveData.Get("gameFullName").String()
+		info.Cover = liveData.Get("screenshot").String()
+		info.Online = liveData.Get("userCount").Int()
+		if start := liveData.Get("startTime").Int(); start != 0 {
+			info.StartTime = time.Unix(start, 0)
+		}
+	}
+	return info, nil
+}
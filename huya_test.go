@@ -0,0 +1,38 @@
+package forwardBot
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+func TestParseHuyaRoom(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		living bool
+	}{
+		{"case living", `{"status":200,"data":{"liveStatus":"ON","profileInfo":{"nick":"up","uid":1},
+			"liveData":{"introduction":"title","gameFullName":"王者荣耀","screenshot":"pic.jpg"}}}`, true},
+		{"case replay", `{"status":200,"data":{"liveStatus":"REPLAY","profileInfo":{"nick":"up","uid":1}}}`, false},
+		{"case offline", `{"status":200,"data":{"liveStatus":"OFF","profileInfo":{"nick":"up","uid":1}}}`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := gjson.Parse(test.in)
+			info, err := parseHuyaRoom("kpl", &r)
+			if assert.Nil(t, err) {
+				assert.Equal(t, test.living, info.LiveStatus)
+				assert.Equal(t, "up", info.Uname)
+				assert.Equal(t, "https://www.huya.com/kpl", info.Link)
+				if test.living {
+					assert.Equal(t, "title", info.Title)
+					assert.Equal(t, "王者荣耀", info.Area)
+				}
+			}
+		})
+	}
+	r := gjson.Parse(`{"status":422,"message":"该主播不存在！"}`)
+	_, err := parseHuyaRoom("kpl", &r)
+	assert.NotNil(t, err)
+}
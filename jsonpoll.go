@@ -0,0 +1,326 @@
+package forwardBot
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"forwardBot/push"
+	"forwardBot/req"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jsonSeenTTL = 7 * 24 * time.Hour //条目超过该时间没有出现在列表中时不再记录
+	jsonSeenMax = 1000               //每个id最多记录的条目数量
+)
+
+// JSONPaths 使用gjson语法描述如何从响应中提取数据
+// 设置了List时，其余路径相对于列表中的每一项，否则相对于整个响应
+type JSONPaths struct {
+	List    string //条目列表
+	Id      string //条目id，用于判断是否是新条目，为空时使用Link，都为空时使用作者、标题、内容和时间的哈希
+	Time    string //发布时间，可以是秒、毫秒时间戳或RFC3339格式的字符串
+	Author  string //作者
+	Title   string //标题
+	Text    string //内容
+	Image   string //图片，可以是字符串或字符串数组
+	Link    string //链接
+	State   string //开播状态，设置后按直播间处理开播、下播
+	StateOn string //开播时状态的值，例如"2"，为空时按布尔值判断
+}
+
+// JSONPollOption JSONPollSource的配置
+type JSONPollOption struct {
+	Name     string            //名称，用于日志和消息标题
+	Url      string            //请求地址，其中的{id}会被替换为Ids中的值
+	Ids      []string          //轮询的id
+	Headers  map[string]string //请求头
+	Cookies  map[string]string //cookies
+	Interval time.Duration     //轮询间隔，为0时使用默认值
+	Paths    JSONPaths
+}
+
+var _ Source = (*JSONPollSource)(nil)
+var _ Named = (*JSONPollSource)(nil)
+
+// JSONPollSource 通用的json接口轮询source，通过配置的gjson路径解析响应
+type JSONPollSource struct {
+	health
+	opt     JSONPollOption
+	client  *req.C
+	headers []req.E
+	living  map[string]bool
+	seen    map[string]map[string]time.Time //每个id已经推送过的条目和最后一次出现的时间
+	lock    sync.Mutex                      //保护living和seen
+	sched   *scheduler
+}
+
+func NewJSONPollSource(opt JSONPollOption) *JSONPollSource {
+	logger.WithFields(logrus.Fields{
+		"name": opt.Name,
+		"url":  opt.Url,
+		"ids":  opt.Ids,
+	}).Info("[JSONPoll]监控json接口")
+	if len(opt.Ids) == 0 {
+		opt.Ids = []string{""}
+	}
+	if opt.Interval <= 0 {
+		opt.Interval = interval
+	}
+	//同一个主机的请求共用请求间隔，地址无法解析时单独限制
+	host := opt.Url
+	if u, err := url.Parse(opt.Url); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	j := &JSONPollSource{
+		opt:    opt,
+		client: req.New(10),
+		living: make(map[string]bool),
+		seen:   make(map[string]map[string]time.Time),
+		sched:  newScheduler("[JSONPoll]"+opt.Name, host, opt.Interval),
+	}
+	for k, v := range opt.Cookies {
+		j.client.SetCookies(k, v)
+	}
+	for k, v := range opt.Headers {
+		j.headers = append(j.headers, req.E{Name: k, Value: v})
+	}
+	return j
+}
+
+func (j *JSONPollSource) Name() string {
+	return "[JSONPoll]" + j.opt.Name
+}
+
+func (j *JSONPollSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	schedule(ctx, j.sched, j.opt.Ids, func(id string, now time.Time) {
+		msgs, err := j.poll(id, now)
+		if err != nil {
+			j.fail(id, err)
+			logger.WithFields(logrus.Fields{
+				"name": j.opt.Name,
+				"id":   id,
+				"err":  err,
+			}).Error("[JSONPoll]轮询json接口失败")
+			return
+		}
+		j.succeed(id)
+		for _, msg := range msgs {
+			ch <- msg
+		}
+	})
+	logger.WithField("name", j.opt.Name).Info("[JSONPoll]停止监控json接口")
+}
+
+// 请求一次接口，返回需要推送的消息
+func (j *JSONPollSource) poll(id string, now time.Time) ([]*push.Msg, error) {
+	link := strings.ReplaceAll(j.opt.Url, "{id}", id)
+	resp, err := j.client.Get(link, nil, nil, j.headers...)
+	if err != nil {
+		return nil, err
+	}
+	result, err := checkResp(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "read json resp data fail")
+	}
+	return j.parse(id, result, now)
+}
+
+func (j *JSONPollSource) parse(id string, result *gjson.Result, now time.Time) ([]*push.Msg, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	var msgs []*push.Msg
+	paths := &j.opt.Paths
+	if paths.State != "" {
+		state := result.Get(paths.State)
+		if !state.Exists() {
+			return nil, errors.New(fmt.Sprintf("not exists %s", paths.State))
+		}
+		living := state.Bool()
+		if paths.StateOn != "" {
+			living = state.String() == paths.StateOn
+		}
+		if msg := j.parseState(id, living, result, now); msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	if paths.List != "" {
+		list := result.Get(paths.List)
+		if !list.IsArray() {
+			return nil, errors.New(fmt.Sprintf("%s is not array", paths.List))
+		}
+		msgs = append(msgs, j.parseList(id, list.Array(), now)...)
+	}
+	return msgs, nil
+}
+
+// 直播间形式的接口，开播状态改变时产生消息
+func (j *JSONPollSource) parseState(id string, living bool, r *gjson.Result, now time.Time) *push.Msg {
+	if living == j.living[id] {
+		logger.WithFields(logrus.Fields{
+			"name":   j.opt.Name,
+			"id":     id,
+			"living": living,
+		}).Debug("[JSONPoll]开播状态未改变")
+		return nil
+	}
+	j.living[id] = living
+	msg := j.newMsg(r, now)
+	if living {
+		msg.Title = j.opt.Name + "开播了"
+		if title := j.get(r, j.opt.Paths.Title); title != "" {
+			msg.Text = fmt.Sprintf("标题：\"%s\"", title)
+		}
+	} else {
+		msg.Title = j.opt.Name + "下播了"
+		msg.Text = "😭😭😭"
+		msg.Img = nil
+		msg.Src = ""
+	}
+	return msg
+}
+
+// 列表形式的接口，第一次请求时只记录已有的条目
+// 已推送的条目合并记录，条目暂时不在列表中或者接口返回空列表时不会重复推送
+func (j *JSONPollSource) parseList(id string, items []gjson.Result, now time.Time) []*push.Msg {
+	seen, inited := j.seen[id]
+	if !inited {
+		if len(items) == 0 {
+			//空列表可能是错误页面，等到有条目时再记录
+			return nil
+		}
+		seen = make(map[string]time.Time, len(items))
+		j.seen[id] = seen
+	}
+	var msgs []*push.Msg
+	for i := range items {
+		key := j.key(&items[i])
+		if key == "" {
+			logger.WithFields(logrus.Fields{
+				"name": j.opt.Name,
+				"id":   id,
+			}).Warn("[JSONPoll]条目没有可以用于判断是否推送过的字段")
+			continue
+		}
+		_, ok := seen[key]
+		seen[key] = now
+		if !inited || ok {
+			continue
+		}
+		msg := j.newMsg(&items[i], now)
+		msg.Title = j.opt.Name
+		if title := j.get(&items[i], j.opt.Paths.Title); title != "" {
+			msg.Title = title
+		}
+		logger.WithFields(logrus.Fields{
+			"name": j.opt.Name,
+			"id":   id,
+			"key":  key,
+		}).Debug("[JSONPoll]新条目")
+		msgs = append(msgs, msg)
+	}
+	expireSeen(seen, now)
+	return msgs
+}
+
+// 条目的唯一标识，没有设置id和链接时使用稳定字段的哈希，避免点赞数等计数变化导致重复推送
+func (j *JSONPollSource) key(r *gjson.Result) string {
+	paths := &j.opt.Paths
+	if key := j.get(r, paths.Id); key != "" {
+		return key
+	}
+	if key := j.get(r, paths.Link); key != "" {
+		return key
+	}
+	fields := []string{j.get(r, paths.Author), j.get(r, paths.Title), j.get(r, paths.Text), j.get(r, paths.Time)}
+	if strings.Join(fields, "") == "" {
+		return ""
+	}
+	hash := md5.Sum([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+// 删除太久没有出现的条目，数量超过上限时删除最早出现的条目
+func expireSeen(seen map[string]time.Time, now time.Time) {
+	keys := make([]string, 0, len(seen))
+	for key, t := range seen {
+		if now.Sub(t) > jsonSeenTTL {
+			delete(seen, key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) <= jsonSeenMax {
+		return
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return seen[keys[i]].Before(seen[keys[j]])
+	})
+	for _, key := range keys[:len(keys)-jsonSeenMax] {
+		delete(seen, key)
+	}
+}
+
+func (j *JSONPollSource) newMsg(r *gjson.Result, now time.Time) *push.Msg {
+	paths := &j.opt.Paths
+	msg := &push.Msg{
+		Times:  now,
+		Flag:   JSONPollMsg,
+		Author: j.get(r, paths.Author),
+		Text:   j.get(r, paths.Text),
+		Src:    j.get(r, paths.Link),
+	}
+	if msg.Author == "" {
+		msg.Author = j.opt.Name
+	}
+	if paths.Time != "" {
+		if t, ok := parseJSONTime(r.Get(paths.Time)); ok {
+			msg.Times = t
+		}
+	}
+	if paths.Image != "" {
+		img := r.Get(paths.Image)
+		if img.IsArray() {
+			for _, v := range img.Array() {
+				msg.Img = append(msg.Img, v.String())
+			}
+		} else if img.String() != "" {
+			msg.Img = []string{img.String()}
+		}
+	}
+	return msg
+}
+
+func (j *JSONPollSource) get(r *gjson.Result, path string) string {
+	if path == "" {
+		return ""
+	}
+	return r.Get(path).String()
+}
+
+// 解析时间，数字按秒或毫秒时间戳处理，字符串按RFC3339格式处理
+func parseJSONTime(r gjson.Result) (time.Time, bool) {
+	switch r.Type {
+	case gjson.Number:
+		ts := r.Int()
+		if ts > 1e12 {
+			return time.UnixMilli(ts), true
+		}
+		return time.Unix(ts, 0), true
+	case gjson.String:
+		t, err := time.Parse(time.RFC3339, r.String())
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,166 @@
+package forwardBot
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJSONPollSource_ParseList(t *testing.T) {
+	j := NewJSONPollSource(JSONPollOption{
+		Name: "test",
+		Paths: JSONPaths{
+			List:   "data.items",
+			Id:     "id",
+			Time:   "ctime",
+			Author: "user.name",
+			Title:  "title",
+			Text:   "content",
+			Image:  "pics.#.url",
+			Link:   "url",
+		},
+	})
+	now := time.Now()
+	first := gjson.Parse(`{"data":{"items":[{"id":1,"title":"old"}]}}`)
+	msgs, err := j.parse("", &first, now)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs, "第一次请求只记录已有条目")
+
+	second := gjson.Parse(`{"data":{"items":[
+		{"id":2,"ctime":1665000000,"user":{"name":"up"},"title":"new","content":"text",
+			"pics":[{"url":"a.png"},{"url":"b.png"}],"url":"https://example.com/2"},
+		{"id":1,"title":"old"}]}}`)
+	msgs, err = j.parse("", &second, now)
+	assert.Nil(t, err)
+	if assert.Len(t, msgs, 1) {
+		msg := msgs[0]
+		assert.Equal(t, JSONPollMsg, msg.Flag)
+		assert.Equal(t, "up", msg.Author)
+		assert.Equal(t, "new", msg.Title)
+		assert.Equal(t, "text", msg.Text)
+		assert.Equal(t, []string{"a.png", "b.png"}, msg.Img)
+		assert.Equal(t, "https://example.com/2", msg.Src)
+		assert.Equal(t, int64(1665000000), msg.Times.Unix())
+	}
+
+	msgs, err = j.parse("", &second, now)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+
+	//条目暂时不在列表中或者返回空列表后再出现时不重复推送
+	for _, in := range []string{`{"data":{"items":[{"id":1,"title":"old"}]}}`, `{"data":{"items":[]}}`} {
+		r := gjson.Parse(in)
+		msgs, err = j.parse("", &r, now)
+		assert.Nil(t, err)
+		assert.Empty(t, msgs)
+	}
+	msgs, err = j.parse("", &second, now)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+}
+
+func TestJSONPollSource_Key(t *testing.T) {
+	j := NewJSONPollSource(JSONPollOption{
+		Name:  "test",
+		Paths: JSONPaths{List: "items", Title: "title", Text: "content"},
+	})
+	now := time.Now()
+	empty := gjson.Parse(`{"items":[]}`)
+	msgs, err := j.parse("", &empty, now)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+	//第一次返回空列表时不记录，之后的条目仍然作为已有条目
+	first := gjson.Parse(`{"items":[{"title":"a","content":"text","likes":1}]}`)
+	msgs, err = j.parse("", &first, now)
+	assert.Nil(t, err)
+	assert.Empty(t, msgs)
+	//计数变化不影响条目的标识
+	second := gjson.Parse(`{"items":[{"title":"b","content":"text","likes":0},{"title":"a","content":"text","likes":5}]}`)
+	msgs, err = j.parse("", &second, now)
+	assert.Nil(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "b", msgs[0].Title)
+	}
+}
+
+func TestExpireSeen(t *testing.T) {
+	now := time.Now()
+	seen := map[string]time.Time{"old": now.Add(-jsonSeenTTL - time.Minute), "new": now}
+	for i := 0; i < jsonSeenMax; i++ {
+		seen[strconv.Itoa(i)] = now.Add(-time.Hour)
+	}
+	expireSeen(seen, now)
+	assert.Len(t, seen, jsonSeenMax)
+	assert.NotContains(t, seen, "old")
+	assert.Contains(t, seen, "new")
+}
+
+func TestJSONPollSource_ParseState(t *testing.T) {
+	j := NewJSONPollSource(JSONPollOption{
+		Name: "测试",
+		Paths: JSONPaths{
+			State:  "data.live",
+			Author: "data.name",
+			Title:  "data.title",
+		},
+	})
+	now := time.Now()
+	tests := []struct {
+		name  string
+		in    string
+		title string
+	}{
+		{"case offline", `{"data":{"live":0,"name":"up"}}`, ""},
+		{"case living", `{"data":{"live":1,"name":"up","title":"room"}}`, "测试开播了"},
+		{"case still living", `{"data":{"live":1,"name":"up","title":"room"}}`, ""},
+		{"case end", `{"data":{"live":false,"name":"up"}}`, "测试下播了"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := gjson.Parse(test.in)
+			msgs, err := j.parse("1", &r, now)
+			assert.Nil(t, err)
+			if test.title == "" {
+				assert.Empty(t, msgs)
+				return
+			}
+			if assert.Len(t, msgs, 1) {
+				assert.Equal(t, test.title, msgs[0].Title)
+				assert.Equal(t, "up", msgs[0].Author)
+			}
+		})
+	}
+}
+
+func TestJSONPollSource_StateOn(t *testing.T) {
+	j := NewJSONPollSource(JSONPollOption{
+		Name:  "测试",
+		Paths: JSONPaths{State: "data.live_status", StateOn: "1"},
+	})
+	now := time.Now()
+	tests := []struct {
+		name  string
+		in    string
+		title string
+	}{
+		{"case living", `{"data":{"live_status":1}}`, "测试开播了"},
+		{"case round", `{"data":{"live_status":2}}`, "测试下播了"},
+		{"case offline", `{"data":{"live_status":0}}`, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := gjson.Parse(test.in)
+			msgs, err := j.parse("1", &r, now)
+			assert.Nil(t, err)
+			if test.title == "" {
+				assert.Empty(t, msgs)
+				return
+			}
+			if assert.Len(t, msgs, 1) {
+				assert.Equal(t, test.title, msgs[0].Title)
+			}
+		})
+	}
+}
@@ -0,0 +1,399 @@
+package forwardBot
+
+import (
+	"fmt"
+	"forwardBot/push"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxSessions      = 50 //每个直播间最多保存的直播记录数量
+	adaptiveSessions = 10 //计算通常开播时间时使用的最近直播记录数量
+)
+
+// LiveSession 一次直播的记录
+type LiveSession struct {
+	Uname      string    `json:"uname"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Titles     []string  `json:"titles"` //直播过程中使用过的标题
+	Area       string    `json:"area"`
+	PeakOnline int64     `json:"peakOnline"` //最高人气或在线人数，为0时表示没有获取到
+}
+
+// Duration 直播时长，直播还没有结束时返回0
+func (s *LiveSession) Duration() time.Duration {
+	if s.End.IsZero() {
+		return 0
+	}
+	return s.End.Sub(s.Start)
+}
+
+// LiveDebounce 开播、下播的防抖设置，零值表示不防抖
+type LiveDebounce struct {
+	Confirm   int           //连续获取到多少次未开播才认为下播，小于等于1时不需要确认
+	Grace     time.Duration //第一次获取到未开播后至少等待多久才认为下播
+	Cooldown  time.Duration //下播后多久内再次开播不推送开播消息
+	Reconnect bool          //冷却时间内再次开播时推送"直播重新连接"消息
+}
+
+// pendingEnd 等待确认的下播
+type pendingEnd struct {
+	since time.Time //第一次获取到未开播的时间
+	count int       //连续获取到未开播的次数
+}
+
+// liveTracker 记录直播间的开播状态，在开播、下播时生成消息，供各个直播间source共用
+type liveTracker struct {
+	name     string //日志中使用的名称，例如"[BiliLive]"
+	prefix   string //消息标题的前缀，例如"抖音"
+	flag     int    //消息类型
+	store    string //持久化直播记录使用的名称
+	lock     sync.Mutex
+	living   map[string]bool
+	current  map[string]*LiveSession   //正在进行的直播
+	sessions map[string][]*LiveSession //已经结束的直播，按开始时间升序
+	debounce LiveDebounce
+	pending  map[string]*pendingEnd //等待确认的下播
+}
+
+func newLiveTracker(name, prefix string, flag int) *liveTracker {
+	l := &liveTracker{
+		name:     name,
+		prefix:   prefix,
+		flag:     flag,
+		store:    "live_" + strings.ToLower(strings.Trim(name, "[]")),
+		living:   make(map[string]bool),
+		current:  make(map[string]*LiveSession),
+		sessions: make(map[string][]*LiveSession),
+		pending:  make(map[string]*pendingEnd),
+	}
+	loadState(l.store, &l.sessions)
+	//重启前正在进行的直播继续记录，不再推送开播消息
+	loadState(l.currentStore(), &l.current)
+	for id := range l.current {
+		l.living[id] = true
+	}
+	return l
+}
+
+// 持久化正在进行的直播使用的名称
+func (l *liveTracker) currentStore() string {
+	return l.store + "_current"
+}
+
+// Sessions 直播间已经结束的直播记录，按开始时间升序
+func (l *liveTracker) Sessions(id string) []LiveSession {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	sessions := make([]LiveSession, 0, len(l.sessions[id]))
+	for _, s := range l.sessions[id] {
+		sessions = append(sessions, *s)
+	}
+	return sessions
+}
+
+// Current 直播间正在进行的直播，没有开播时返回false
+func (l *liveTracker) Current(id string) (LiveSession, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	s, ok := l.current[id]
+	if !ok {
+		return LiveSession{}, false
+	}
+	return *s, true
+}
+
+// adaptiveInterval 返回用于scheduler的间隔调整函数，
+// 在根据直播记录得到的通常开播时间前后window内返回fast，否则返回0
+func (l *liveTracker) adaptiveInterval(fast, window time.Duration) func(id string, now time.Time) time.Duration {
+	return func(id string, now time.Time) time.Duration {
+		if l.nearUsualStart(id, now, window) {
+			return fast
+		}
+		return 0
+	}
+}
+
+// 判断now是否在最近的直播记录的开播时间前后window内，只比较一天中的时刻，正在直播时返回false
+func (l *liveTracker) nearUsualStart(id string, now time.Time, window time.Duration) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.living[id] {
+		return false
+	}
+	sessions := l.sessions[id]
+	if len(sessions) > adaptiveSessions {
+		sessions = sessions[len(sessions)-adaptiveSessions:]
+	}
+	for _, s := range sessions {
+		if clockDistance(s.Start, now) <= window {
+			return true
+		}
+	}
+	return false
+}
+
+// 两个时间在一天中的时刻的距离，例如23:50和00:10的距离为20分钟
+func clockDistance(a, b time.Time) time.Duration {
+	day := 24 * time.Hour
+	clock := func(t time.Time) time.Duration {
+		t = t.In(b.Location())
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+			time.Duration(t.Second())*time.Second
+	}
+	d := clock(a) - clock(b)
+	if d < 0 {
+		d = -d
+	}
+	if d > day/2 {
+		d = day - d
+	}
+	return d
+}
+
+// observeOnline 记录直播间的人气或在线人数，用于没有在直播间信息中返回人气的source
+func (l *liveTracker) observeOnline(id string, online int64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if s, ok := l.current[id]; ok && online > s.PeakOnline {
+		s.PeakOnline = online
+	}
+}
+
+// 直播中更新标题、分区和最高人气，标题或分区变化时持久化
+func (l *liveTracker) observe(id string, info *LiveInfo) {
+	s, ok := l.current[id]
+	if !ok {
+		return
+	}
+	changed := false
+	if info.Title != "" && (len(s.Titles) == 0 || s.Titles[len(s.Titles)-1] != info.Title) {
+		s.Titles = append(s.Titles, info.Title)
+		changed = true
+	}
+	if info.Area != "" && info.Area != s.Area {
+		s.Area = info.Area
+		changed = true
+	}
+	if info.Online > s.PeakOnline {
+		s.PeakOnline = info.Online
+	}
+	if changed {
+		saveState(l.currentStore(), l.current)
+	}
+}
+
+// 结束直播记录并持久化，没有正在进行的直播时返回nil
+func (l *liveTracker) finish(id string, now time.Time) *LiveSession {
+	s, ok := l.current[id]
+	if !ok {
+		return nil
+	}
+	delete(l.current, id)
+	s.End = now
+	sessions := append(l.sessions[id], s)
+	if len(sessions) > maxSessions {
+		sessions = sessions[len(sessions)-maxSessions:]
+	}
+	l.sessions[id] = sessions
+	saveState(l.store, l.sessions)
+	saveState(l.currentStore(), l.current)
+	return s
+}
+
+// 最近一次下播的时间，从持久化的直播记录中获取，重启后冷却时间仍然有效
+func (l *liveTracker) lastEnd(id string) (time.Time, bool) {
+	sessions := l.sessions[id]
+	if len(sessions) == 0 {
+		return time.Time{}, false
+	}
+	return sessions[len(sessions)-1].End, true
+}
+
+// 重新打开最近一次结束的直播记录，没有记录时开始新的直播记录
+func (l *liveTracker) reopen(id string, info *LiveInfo, start time.Time) {
+	sessions := l.sessions[id]
+	if n := len(sessions); n != 0 {
+		s := sessions[n-1]
+		s.End = time.Time{}
+		l.sessions[id] = sessions[:n-1]
+		l.current[id] = s
+		saveState(l.store, l.sessions)
+	} else {
+		l.current[id] = &LiveSession{Uname: info.Uname, Start: start}
+	}
+	l.observe(id, info)
+	saveState(l.currentStore(), l.current)
+}
+
+// SetDebounce 设置开播、下播的防抖，用于避免直播短暂中断时重复推送下播、开播消息
+func (l *liveTracker) SetDebounce(d LiveDebounce) {
+	logger.WithFields(logrus.Fields{
+		"confirm":   d.Confirm,
+		"grace":     d.Grace,
+		"cooldown":  d.Cooldown,
+		"reconnect": d.Reconnect,
+	}).Info(l.name + "设置开播状态防抖")
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.debounce = d
+}
+
+// 获取到未开播时判断是否确认下播，返回确认下播的时间
+func (l *liveTracker) confirmEnd(id string, now time.Time) (time.Time, bool) {
+	p, ok := l.pending[id]
+	if !ok {
+		p = &pendingEnd{since: now}
+		l.pending[id] = p
+	}
+	p.count++
+	if p.count < l.debounce.Confirm || now.Sub(p.since) < l.debounce.Grace {
+		logger.WithFields(logrus.Fields{
+			"id":    id,
+			"count": p.count,
+			"since": p.since,
+		}).Debug(l.name + "等待确认下播")
+		return time.Time{}, false
+	}
+	delete(l.pending, id)
+	//以第一次获取到未开播的时间作为下播时间
+	return p.since, true
+}
+
+// update 根据获取到的直播间信息更新开播状态，状态改变时返回需要推送的消息，否则返回nil
+func (l *liveTracker) update(id string, info *LiveInfo, now time.Time) *push.Msg {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	//当前开播状态和已经记录的开播状态相同，说明已经发送过消息
+	if info.LiveStatus == l.living[id] {
+		if info.LiveStatus {
+			if _, ok := l.pending[id]; ok {
+				delete(l.pending, id)
+				logger.WithField("id", id).Debug(l.name + "直播短暂中断后恢复")
+			}
+			l.observe(id, info)
+		}
+		logger.WithFields(logrus.Fields{
+			"id":     id,
+			"living": info.LiveStatus,
+		}).Debug(l.name + "开播状态未改变")
+		return nil
+	}
+	msg := &push.Msg{
+		Times:  now,
+		Flag:   l.flag,
+		Author: info.Uname,
+	}
+	if info.LiveStatus {
+		//开播
+		l.living[id] = true
+		start := info.StartTime
+		if start.IsZero() || start.After(now) {
+			start = now
+		}
+		if end, ok := l.lastEnd(id); ok && now.Sub(end) < l.debounce.Cooldown {
+			//直播短暂中断，继续记录上一次直播
+			l.reopen(id, info, start)
+			logger.WithFields(logrus.Fields{
+				"id":   id,
+				"name": info.Uname,
+				"end":  end,
+			}).Debug(l.name + "下播后很快重新开播")
+			if !l.debounce.Reconnect {
+				return nil
+			}
+			msg.Title = l.prefix + "直播重新连接"
+			msg.Text = fmt.Sprintf("直播中断后重新连接\n标题：\"%s\"", info.Title)
+			msg.Src = info.Link
+			return msg
+		}
+		l.current[id] = &LiveSession{Uname: info.Uname, Start: start}
+		l.observe(id, info)
+		saveState(l.currentStore(), l.current)
+		msg.Title = l.prefix + "开播了"
+		if info.Area != "" {
+			msg.Text = fmt.Sprintf("标题：\"%s\"\n分区：\"%s\"", info.Title, info.Area)
+		} else {
+			msg.Text = fmt.Sprintf("标题：\"%s\"", info.Title)
+		}
+		if info.Cover != "" {
+			msg.Img = []string{info.Cover}
+		}
+		msg.Src = info.Link
+		logger.WithFields(logrus.Fields{
+			"id":   id,
+			"name": info.Uname,
+		}).Debug(l.name + "开播")
+	} else {
+		//下播
+		end, ok := l.confirmEnd(id, now)
+		if !ok {
+			return nil
+		}
+		l.living[id] = false
+		msg.Title = l.prefix + "下播了"
+		if s := l.finish(id, end); s != nil {
+			msg.Text = sessionSummary(s)
+		} else {
+			msg.Text = "😭😭😭"
+		}
+		logger.WithFields(logrus.Fields{
+			"id":   id,
+			"name": info.Uname,
+		}).Debug(l.name + "下播")
+	}
+	return msg
+}
+
+// 下播消息中的直播总结
+func sessionSummary(s *LiveSession) string {
+	text := strings.Builder{}
+	text.WriteString(fmt.Sprintf("本次直播时长：%s", formatDuration(s.Duration())))
+	if len(s.Titles) != 0 {
+		text.WriteString(fmt.Sprintf("\n标题：\"%s\"", strings.Join(s.Titles, "\" → \"")))
+	}
+	if s.Area != "" {
+		text.WriteString(fmt.Sprintf("\n分区：\"%s\"", s.Area))
+	}
+	if s.PeakOnline != 0 {
+		text.WriteString(fmt.Sprintf("\n最高人气：%d", s.PeakOnline))
+	}
+	return text.String()
+}
+
+// 生成查询直播记录的回复，只包含最近的num条
+func sessionsText(id string, sessions []LiveSession, num int, loc *time.Location) string {
+	if len(sessions) == 0 {
+		return fmt.Sprintf("直播间%s没有直播记录", id)
+	}
+	if len(sessions) > num {
+		sessions = sessions[len(sessions)-num:]
+	}
+	text := strings.Builder{}
+	text.WriteString(fmt.Sprintf("直播间%s最近的直播记录：", id))
+	for i := range sessions {
+		s := &sessions[i]
+		duration := "直播中"
+		if !s.End.IsZero() {
+			duration = formatDuration(s.Duration())
+		}
+		text.WriteString(fmt.Sprintf("\n%s %s %s", s.Start.In(loc).Format("01-02 15:04"), s.Uname, duration))
+		if len(s.Titles) != 0 {
+			text.WriteString(fmt.Sprintf(" \"%s\"", s.Titles[len(s.Titles)-1]))
+		}
+	}
+	return text.String()
+}
+
+// 将时长格式化为"x小时y分钟"
+func formatDuration(d time.Duration) string {
+	h, m := int64(d.Hours()), int64(d.Minutes())%60
+	if h != 0 {
+		return fmt.Sprintf("%d小时%d分钟", h, m)
+	}
+	return fmt.Sprintf("%d分钟", m)
+}
@@ -0,0 +1,258 @@
+package forwardBot
+
+import (
+	"forwardBot/push"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestLiveTracker_Update(t *testing.T) {
+	l := newLiveTracker("[test]", "测试", BiliLiveMsg)
+	now := time.Now()
+	tests := []struct {
+		name   string
+		living bool
+		title  string
+	}{
+		{"case offline", false, ""},
+		{"case start", true, "测试开播了"},
+		{"case still living", true, ""},
+		{"case end", false, "测试下播了"},
+		{"case still offline", false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := &LiveInfo{
+				Uname:      "up",
+				LiveStatus: test.living,
+				Title:      "room",
+				Area:       "娱乐-杂谈",
+				Cover:      "cover.jpg",
+				Link:       "https://live.bilibili.com/1",
+			}
+			msg := l.update("1", info, now)
+			if test.title == "" {
+				assert.Nil(t, msg)
+				return
+			}
+			if assert.NotNil(t, msg) {
+				assert.Equal(t, test.title, msg.Title)
+				assert.Equal(t, "up", msg.Author)
+				assert.Equal(t, BiliLiveMsg, msg.Flag)
+				if test.living {
+					assert.Equal(t, "标题：\"room\"\n分区：\"娱乐-杂谈\"", msg.Text)
+					assert.Equal(t, "https://live.bilibili.com/1", msg.Src)
+				}
+			}
+		})
+	}
+}
+
+func TestLiveTracker_Session(t *testing.T) {
+	l := newLiveTracker("[test]", "测试", BiliLiveMsg)
+	start := time.Date(2022, 10, 1, 20, 0, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		after  time.Duration
+		living bool
+		title  string
+		online int64
+	}{
+		{"case start", 0, true, "开播", 100},
+		{"case same title", 10 * time.Minute, true, "开播", 300},
+		{"case change title", 30 * time.Minute, true, "换标题", 200},
+		{"case end", 95 * time.Minute, false, "", 0},
+	}
+	var msg *push.Msg
+	for _, test := range tests {
+		msg = l.update("1", &LiveInfo{
+			Uname:      "up",
+			LiveStatus: test.living,
+			Title:      test.title,
+			Area:       "娱乐-杂谈",
+			Online:     test.online,
+		}, start.Add(test.after))
+	}
+	l.observeOnline("1", 1000)
+	if assert.NotNil(t, msg) {
+		assert.Equal(t, "测试下播了", msg.Title)
+		assert.Equal(t, "本次直播时长：1小时35分钟\n标题：\"开播\" → \"换标题\"\n分区：\"娱乐-杂谈\"\n最高人气：300", msg.Text)
+	}
+	sessions := l.Sessions("1")
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, start, sessions[0].Start)
+		assert.Equal(t, 95*time.Minute, sessions[0].Duration())
+		assert.Equal(t, []string{"开播", "换标题"}, sessions[0].Titles)
+	}
+	assert.Empty(t, l.Sessions("2"))
+}
+
+func TestLiveTracker_Debounce(t *testing.T) {
+	l := newLiveTracker("[test]", "测试", BiliLiveMsg)
+	l.SetDebounce(LiveDebounce{
+		Confirm:   3,
+		Grace:     time.Minute,
+		Cooldown:  10 * time.Minute,
+		Reconnect: true,
+	})
+	start := time.Date(2022, 10, 1, 20, 0, 0, 0, time.Local)
+	tests := []struct {
+		name   string
+		after  time.Duration
+		living bool
+		title  string
+	}{
+		{"case start", 0, true, "测试开播了"},
+		{"case drop", 10 * time.Minute, false, ""},
+		{"case recover", 11 * time.Minute, true, ""},
+		{"case offline 1", 20 * time.Minute, false, ""},
+		{"case offline 2", 20*time.Minute + 10*time.Second, false, ""},
+		{"case offline 3 in grace", 20*time.Minute + 20*time.Second, false, ""},
+		{"case confirmed", 21 * time.Minute, false, "测试下播了"},
+		{"case still offline", 22 * time.Minute, false, ""},
+		{"case restart in cooldown", 25 * time.Minute, true, "测试直播重新连接"},
+		{"case end again", 40 * time.Minute, false, ""},
+		{"case end again 2", 41 * time.Minute, false, ""},
+		{"case end again 3", 42 * time.Minute, false, "测试下播了"},
+		{"case still offline 2", 50 * time.Minute, false, ""},
+		{"case restart after cooldown", 60 * time.Minute, true, "测试开播了"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg := l.update("1", &LiveInfo{
+				Uname:      "up",
+				LiveStatus: test.living,
+				Title:      "room",
+			}, start.Add(test.after))
+			if test.title == "" {
+				assert.Nil(t, msg)
+				return
+			}
+			if assert.NotNil(t, msg) {
+				assert.Equal(t, test.title, msg.Title)
+				if test.name == "case end again 3" {
+					//直播总结包含中断前的部分
+					assert.Contains(t, msg.Text, "本次直播时长：40分钟")
+				}
+			}
+		})
+	}
+	//冷却时间内重新开播继续记录上一次直播，下播时间为第一次获取到未开播的时间
+	sessions := l.Sessions("1")
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, 40*time.Minute, sessions[0].Duration())
+	}
+	current, ok := l.Current("1")
+	if assert.True(t, ok) {
+		assert.Equal(t, start.Add(60*time.Minute), current.Start)
+	}
+}
+
+func TestClockDistance(t *testing.T) {
+	day := time.Date(2022, 10, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name string
+		a    time.Time
+		b    time.Time
+		want time.Duration
+	}{
+		{"case same day", day.Add(20 * time.Hour), day.Add(21 * time.Hour), time.Hour},
+		{"case different day", day.Add(20 * time.Hour), day.Add(24*time.Hour*3 + 19*time.Hour), time.Hour},
+		{"case across midnight", day.Add(23*time.Hour + 50*time.Minute), day.Add(24*time.Hour + 10*time.Minute), 20 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, clockDistance(test.a, test.b))
+		})
+	}
+}
+
+func TestLiveTracker_NearUsualStart(t *testing.T) {
+	l := newLiveTracker("[test]", "测试", BiliLiveMsg)
+	day := time.Date(2022, 10, 1, 0, 0, 0, 0, time.Local)
+	l.sessions["1"] = []*LiveSession{{Start: day.Add(20 * time.Hour)}}
+	fn := l.adaptiveInterval(time.Minute, 30*time.Minute)
+	tests := []struct {
+		name string
+		id   string
+		now  time.Time
+		want time.Duration
+	}{
+		{"case near usual start", "1", day.Add(24*time.Hour + 19*time.Hour + 40*time.Minute), time.Minute},
+		{"case far from usual start", "1", day.Add(24*time.Hour + 12*time.Hour), 0},
+		{"case no history", "2", day.Add(20 * time.Hour), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, fn(test.id, test.now))
+		})
+	}
+	l.living["1"] = true
+	assert.Equal(t, time.Duration(0), fn("1", day.Add(20*time.Hour)))
+}
+
+func TestLiveTracker_Persist(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	SetStore(s)
+	defer SetStore(nil)
+	start := time.Date(2022, 10, 1, 20, 0, 0, 0, time.Local)
+	l := newLiveTracker("[test]", "测试", BiliLiveMsg)
+	assert.NotNil(t, l.update("1", &LiveInfo{Uname: "up", LiveStatus: true, Title: "开播"}, start))
+	_ = l.update("1", &LiveInfo{Uname: "up", LiveStatus: true, Title: "换标题"}, start.Add(time.Minute))
+
+	//重启后继续记录正在进行的直播，不再推送开播消息
+	l = newLiveTracker("[test]", "测试", BiliLiveMsg)
+	current, ok := l.Current("1")
+	if assert.True(t, ok) {
+		assert.True(t, start.Equal(current.Start))
+		assert.Equal(t, []string{"开播", "换标题"}, current.Titles)
+	}
+	assert.Nil(t, l.update("1", &LiveInfo{Uname: "up", LiveStatus: true, Title: "换标题"}, start.Add(time.Hour)))
+	msg := l.update("1", &LiveInfo{Uname: "up", LiveStatus: false}, start.Add(2*time.Hour))
+	if assert.NotNil(t, msg) {
+		assert.Contains(t, msg.Text, "本次直播时长：2小时0分钟")
+	}
+
+	l = newLiveTracker("[test]", "测试", BiliLiveMsg)
+	_, ok = l.Current("1")
+	assert.False(t, ok)
+	assert.Len(t, l.Sessions("1"), 1)
+}
+
+// 冷却时间内重启后再次开播，继续记录上一次直播
+func TestLiveTracker_RestartInCooldown(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	SetStore(s)
+	defer SetStore(nil)
+	debounce := LiveDebounce{Cooldown: 10 * time.Minute}
+	start := time.Date(2022, 10, 1, 20, 0, 0, 0, time.Local)
+	l := newLiveTracker("[test]", "测试", BiliLiveMsg)
+	l.SetDebounce(debounce)
+	assert.NotNil(t, l.update("1", &LiveInfo{Uname: "up", LiveStatus: true, Title: "room"}, start))
+	assert.NotNil(t, l.update("1", &LiveInfo{Uname: "up", LiveStatus: false}, start.Add(time.Hour)))
+
+	l = newLiveTracker("[test]", "测试", BiliLiveMsg)
+	l.SetDebounce(debounce)
+	assert.Nil(t, l.update("1", &LiveInfo{Uname: "up", LiveStatus: true, Title: "room"}, start.Add(65*time.Minute)))
+	current, ok := l.Current("1")
+	if assert.True(t, ok) {
+		assert.True(t, start.Equal(current.Start))
+	}
+	assert.Empty(t, l.Sessions("1"))
+}
+
+func TestSessionsText(t *testing.T) {
+	start := time.Date(2022, 10, 1, 20, 0, 0, 0, time.Local)
+	sessions := []LiveSession{
+		{Uname: "up", Start: start, End: start.Add(time.Hour), Titles: []string{"a"}},
+		{Uname: "up", Start: start.AddDate(0, 0, 1), End: start.AddDate(0, 0, 1).Add(95 * time.Minute), Titles: []string{"b", "c"}},
+		{Uname: "up", Start: start.AddDate(0, 0, 2)},
+	}
+	assert.Equal(t, "直播间1没有直播记录", sessionsText("1", nil, 5, time.Local))
+	assert.Equal(t, "直播间1最近的直播记录：\n"+
+		"10-02 20:00 up 1小时35分钟 \"c\"\n"+
+		"10-03 20:00 up 直播中", sessionsText("1", sessions, 2, time.Local))
+}
@@ -14,4 +14,6 @@ type Msg struct {
 	Text   string    //消息内容
 	Img    []string  //消息中的图片
 	Src    string    //消息出处
+	Refs   []string  //消息引用的其他内容的链接，例如转发的原动态，不推送，只用于去重
+	Digest bool      //是否是合并多条消息得到的汇总，再次汇总时不再合并
 }
@@ -189,6 +189,9 @@ func escapeCQCode(src string) string {
 }
 
 func parseCQCode(msg string) *CQCode {
+	if len(msg) < 2 || msg[0] != '[' || msg[len(msg)-1] != ']' {
+		return nil
+	}
 	//去除首尾的'['和']'
 	msg = msg[1 : len(msg)-1]
 	if !strings.HasPrefix(msg, "CQ:") {
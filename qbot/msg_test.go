@@ -89,10 +89,19 @@ func TestParseCQCode(t *testing.T) {
 				"qq": "114514",
 			},
 		}},
+		{"test case empty", "", nil},
+		{"test case single bracket", "[", nil},
+		{"test case not closed", "[CQ:at,qq=114514", nil},
+		{"test case empty CQCode", "[]", nil},
+		{"test case bad data", "[CQ:at,qq]", nil},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			got := parseCQCode(test.in)
+			if test.out == nil {
+				assert.Nil(t, got)
+				return
+			}
 			assert.Equal(t, test.out.Types, got.Types)
 			assert.Equal(t, test.out.Data, got.Data)
 		})
@@ -0,0 +1,113 @@
+package forwardBot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"forwardBot/push"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	OverflowBlock      = "block"      //队列满时阻塞，直到sink发送完一条消息，期间所有sink都收不到新消息
+	OverflowDropOldest = "dropOldest" //队列满时丢弃最早的消息
+	OverflowSpill      = "spill"      //队列满时把消息写入磁盘，队列有空位后按顺序读取
+
+	defaultQueueCapacity = 100
+)
+
+// QueueOption sink消息队列的设置
+type QueueOption struct {
+	Capacity int    //内存中最多保存的消息数量，为0时使用默认值
+	Overflow string //队列满时的处理方式，为空时使用OverflowBlock，发送慢的sink会阻塞其他sink
+	SpillDir string //OverflowSpill时保存消息的目录
+}
+
+// 文件名中不能使用的字符
+var unsafeFileChars = regexp.MustCompile(`[^\w.-]+`)
+
+// spillQueue 保存在文件中的消息队列，每行一条json格式的消息
+type spillQueue struct {
+	path   string
+	count  int   //文件中没有读取的消息数量
+	offset int64 //下一条消息在文件中的位置
+}
+
+// 打开保存在dir中的队列，文件已经存在时继续读取其中的消息
+func newSpillQueue(dir, name string) (*spillQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "create spill dir fail")
+	}
+	q := &spillQueue{path: filepath.Join(dir, unsafeFileChars.ReplaceAllString(name, "_")+".jsonl")}
+	data, err := os.ReadFile(q.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "read spill file fail")
+	}
+	q.count = bytes.Count(data, []byte{'\n'})
+	return q, nil
+}
+
+func (q *spillQueue) push(msg *push.Msg) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "marshal fail")
+	}
+	f, err := os.OpenFile(q.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open spill file fail")
+	}
+	defer f.Close()
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		return errors.Wrap(err, "write spill file fail")
+	}
+	q.count++
+	return nil
+}
+
+// 读取最早的一条消息，全部读取后删除文件
+// 文件被删除或者内容不完整时丢弃文件中剩余的消息并返回错误，避免一直读取失败
+func (q *spillQueue) pop() (*push.Msg, error) {
+	if q.count == 0 {
+		return nil, io.EOF
+	}
+	line, err := q.readLine()
+	if err != nil {
+		q.reset()
+		return nil, err
+	}
+	q.offset += int64(len(line))
+	q.count--
+	if q.count == 0 {
+		q.reset()
+	}
+	msg := &push.Msg{}
+	return msg, errors.Wrap(json.Unmarshal(line, msg), "unmarshal fail")
+}
+
+// 读取offset处的一行，不包含换行符时返回错误
+func (q *spillQueue) readLine() ([]byte, error) {
+	f, err := os.Open(q.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open spill file fail")
+	}
+	defer f.Close()
+	if _, err = f.Seek(q.offset, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "seek spill file fail")
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "read spill file fail")
+	}
+	return line, nil
+}
+
+// 清空队列并删除文件
+func (q *spillQueue) reset() {
+	q.count = 0
+	q.offset = 0
+	_ = os.Remove(q.path)
+}
@@ -0,0 +1,171 @@
+package forwardBot
+
+import (
+	"forwardBot/push"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 取出队列中所有的消息标题
+func drainTitles(w *sinkWorker) []string {
+	var titles []string
+	for {
+		w.lock.Lock()
+		empty := len(w.queue) == 0 && (w.spill == nil || w.spill.count == 0)
+		w.lock.Unlock()
+		if empty {
+			return titles
+		}
+		msg, _ := w.next()
+		titles = append(titles, msg.Title)
+		w.done()
+	}
+}
+
+func TestSinkWorker_Overflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		overflow string
+		titles   []string
+		depth    int
+		spilled  int
+		dropped  int64
+	}{
+		{"case drop oldest", OverflowDropOldest, []string{"2", "3", "4"}, 3, 0, 2},
+		{"case spill", OverflowSpill, []string{"0", "1", "2", "3", "4"}, 5, 2, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := newSinkWorker("test", nil, QueueOption{
+				Capacity: 3,
+				Overflow: test.overflow,
+				SpillDir: t.TempDir(),
+			})
+			for i := 0; i < 5; i++ {
+				w.push(&push.Msg{Title: strconv.Itoa(i)})
+			}
+			status := w.snapshot()
+			assert.Equal(t, test.depth, status.Depth)
+			assert.Equal(t, test.spilled, status.Spilled)
+			assert.Equal(t, test.dropped, status.Dropped)
+			assert.Equal(t, test.titles, drainTitles(w))
+			assert.Equal(t, int64(len(test.titles)), w.snapshot().Delivered)
+		})
+	}
+}
+
+func TestSinkWorker_SpillOrder(t *testing.T) {
+	dir := t.TempDir()
+	w := newSinkWorker("*test.Sink", nil, QueueOption{Capacity: 1, Overflow: OverflowSpill, SpillDir: dir})
+	w.push(&push.Msg{Title: "0"})
+	w.push(&push.Msg{Title: "1"})
+	//取出内存中的消息后，新消息仍然要排在磁盘中的消息之后
+	msg, _ := w.next()
+	w.done()
+	assert.Equal(t, "0", msg.Title)
+	w.push(&push.Msg{Title: "2"})
+
+	//重新打开时继续读取磁盘中的消息
+	w = newSinkWorker("*test.Sink", nil, QueueOption{Capacity: 1, Overflow: OverflowSpill, SpillDir: dir})
+	assert.Equal(t, 2, w.snapshot().Spilled)
+	assert.Equal(t, []string{"1", "2"}, drainTitles(w))
+	assert.Equal(t, 0, w.snapshot().Depth)
+}
+
+func TestSinkWorker_Block(t *testing.T) {
+	w := newSinkWorker("test", nil, QueueOption{Capacity: 1})
+	w.push(&push.Msg{Title: "0"})
+	pushed := make(chan struct{})
+	go func() {
+		w.push(&push.Msg{Title: "1"})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatal("队列满时没有阻塞")
+	case <-time.After(20 * time.Millisecond):
+	}
+	msg, _ := w.next()
+	assert.Equal(t, "0", msg.Title)
+	<-pushed
+	w.done()
+	w.close()
+	assert.Equal(t, []*push.Msg{{Title: "1"}}, w.pending())
+}
+
+func TestBot_Ordered(t *testing.T) {
+	sink := &slowSink{delay: time.Millisecond, ch: make(chan *push.Msg, 20)}
+	b := NewBot(0)
+	b.AppendSink(sink)
+	go b.sinks[0].run(b)
+	for i := 0; i < 20; i++ {
+		b.dispatch(&push.Msg{Title: strconv.Itoa(i)})
+	}
+	b.sinks[0].wait()
+	for i := 0; i < 20; i++ {
+		assert.Equal(t, strconv.Itoa(i), (<-sink.ch).Title)
+	}
+	b.sinks[0].close()
+}
+
+func TestSinkWorker_SpillFileLost(t *testing.T) {
+	w := newSinkWorker("test", nil, QueueOption{Capacity: 1, Overflow: OverflowSpill, SpillDir: t.TempDir()})
+	for i := 0; i < 3; i++ {
+		w.push(&push.Msg{Title: strconv.Itoa(i)})
+	}
+	assert.Equal(t, 2, w.snapshot().Spilled)
+	assert.Nil(t, os.Remove(w.spill.path))
+	msg, _ := w.next()
+	w.done()
+	assert.Equal(t, "0", msg.Title)
+
+	//磁盘中的消息丢失后等待新的消息，不会一直重试读取
+	got := make(chan *push.Msg)
+	go func() {
+		msg, _ := w.next()
+		got <- msg
+	}()
+	select {
+	case msg := <-got:
+		t.Fatalf("unexpected msg %v", msg)
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Equal(t, 0, w.snapshot().Spilled)
+	w.push(&push.Msg{Title: "3"})
+	select {
+	case msg := <-got:
+		assert.Equal(t, "3", msg.Title)
+	case <-time.After(time.Second):
+		t.Fatal("next blocked")
+	}
+	w.done()
+}
+
+func TestSinkWorker_SpillTruncated(t *testing.T) {
+	w := newSinkWorker("test", nil, QueueOption{Capacity: 1, Overflow: OverflowSpill, SpillDir: t.TempDir()})
+	for i := 0; i < 3; i++ {
+		w.push(&push.Msg{Title: strconv.Itoa(i)})
+	}
+	data, err := os.ReadFile(w.spill.path)
+	assert.Nil(t, err)
+	//最后一行不完整
+	assert.Nil(t, os.WriteFile(w.spill.path, data[:len(data)-5], 0644))
+	w.close()
+	msgs := w.pending()
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, "0", msgs[0].Title)
+		assert.Equal(t, "1", msgs[1].Title)
+	}
+	assert.Equal(t, 0, w.snapshot().Depth)
+}
+
+func TestSinkStatusText(t *testing.T) {
+	status := []SinkStatus{
+		{Name: "a", Depth: 5, Spilled: 2, Delivered: 10},
+		{Name: "b", Dropped: 3},
+	}
+	assert.Equal(t, "a 等待发送5条 已发送10条 磁盘中2条\nb 等待发送0条 已发送0条 丢弃3条", sinkStatusText(status))
+}
@@ -0,0 +1,222 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"forwardBot/push"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 没有设置紧急消息类型时，免打扰时段内仍然发送开播和告警消息
+var defaultUrgentFlags = []int{BiliLiveMsg, TikTokLiveMsg, DouyuLiveMsg, HuyaLiveMsg, BotAlertMsg}
+
+// QuietHours 每天的免打扰时段，Start大于End时跨过零点，例如22:00-08:00
+type QuietHours struct {
+	Start time.Duration `json:"start"` //距离零点的时间
+	End   time.Duration `json:"end"`
+}
+
+// ParseQuietHours 解析15:04格式的开始和结束时间
+func ParseQuietHours(start, end string) (QuietHours, error) {
+	var q QuietHours
+	for _, v := range []struct {
+		s string
+		d *time.Duration
+	}{{start, &q.Start}, {end, &q.End}} {
+		t, err := time.Parse("15:04", v.s)
+		if err != nil {
+			return q, errors.Wrap(err, "parse quiet hours fail")
+		}
+		*v.d = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if q.Start == q.End {
+		return q, errors.New(fmt.Sprintf("quiet hours start and end are the same: %s", start))
+	}
+	return q, nil
+}
+
+func (q QuietHours) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", int(q.Start.Hours()), int(q.Start.Minutes())%60,
+		int(q.End.Hours()), int(q.End.Minutes())%60)
+}
+
+// 返回包含t的免打扰时段，t不在时段内时返回下一个时段
+func (q QuietHours) window(t time.Time, loc *time.Location) (start, end time.Time) {
+	length := q.End - q.Start
+	if length <= 0 {
+		length += 24 * time.Hour
+	}
+	local := t.In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -1)
+	for {
+		start = day.Add(q.Start)
+		end = start.Add(length)
+		if end.After(t) {
+			return start, end
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+}
+
+// 返回t是否在免打扰时段内
+func (q QuietHours) contains(t time.Time, loc *time.Location) bool {
+	start, _ := q.window(t, loc)
+	return !t.Before(start)
+}
+
+// 把消息类型转换为集合，为空时使用默认的紧急消息类型
+func urgentSet(flags []int) map[int]bool {
+	if flags == nil {
+		flags = defaultUrgentFlags
+	}
+	set := make(map[int]bool, len(flags))
+	for _, flag := range flags {
+		set[flag] = true
+	}
+	return set
+}
+
+// QuietOption QuietSink的设置
+type QuietOption struct {
+	Name     string         //名称，用于日志和持久化，多个QuietSink的名称不能相同
+	Start    string         //免打扰开始时间，格式为15:04
+	End      string         //免打扰结束时间，格式为15:04
+	Location *time.Location //时区，为nil时使用本地时区
+	Urgent   []int          //免打扰时段内仍然直接发送的消息类型，为nil时使用开播和告警消息
+}
+
+var _ Sink = (*QuietSink)(nil)
+var _ SinkRunner = (*QuietSink)(nil)
+
+// QuietSink 免打扰时段内暂存非紧急的消息，时段结束后合并成汇总发送给sink
+type QuietSink struct {
+	sink   Sink
+	opt    QuietOption
+	hours  QuietHours
+	urgent map[int]bool
+	lock   sync.Mutex
+	held   []*push.Msg
+}
+
+func NewQuietSink(sink Sink, opt QuietOption) (*QuietSink, error) {
+	hours, err := ParseQuietHours(opt.Start, opt.End)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Name == "" {
+		opt.Name = "quiet"
+	}
+	if opt.Location == nil {
+		opt.Location = time.Local
+	}
+	q := &QuietSink{
+		sink:   sink,
+		opt:    opt,
+		hours:  hours,
+		urgent: urgentSet(opt.Urgent),
+	}
+	logger.WithFields(logrus.Fields{
+		"name":     opt.Name,
+		"hours":    hours.String(),
+		"location": opt.Location.String(),
+		"urgent":   opt.Urgent,
+	}).Info("[Quiet]创建免打扰sink")
+	loadState(q.storeName(), &q.held)
+	return q, nil
+}
+
+func (q *QuietSink) Name() string {
+	return "[Quiet]" + q.opt.Name
+}
+
+func (q *QuietSink) storeName() string {
+	return "quiet_" + q.opt.Name
+}
+
+// Receive 紧急消息直接发送，免打扰时段内暂存其余消息，收到Run的唤醒消息时发送暂存的消息
+func (q *QuietSink) Receive(msg *push.Msg) error {
+	if target, ok := wakeTarget(msg); ok {
+		if target != q.Name() {
+			return forwardWake(q.sink, msg)
+		}
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		now := time.Now()
+		if q.hours.contains(now, q.opt.Location) {
+			return nil
+		}
+		return q.flush(now)
+	}
+	if q.urgent[msg.Flag] {
+		return q.sink.Receive(msg)
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	if q.hours.contains(now, q.opt.Location) {
+		q.held = append(q.held, msg)
+		saveState(q.storeName(), q.held)
+		return nil
+	}
+	//时段已经结束但Run还没有发送暂存的消息，先发送暂存的消息保证顺序
+	if err := q.flush(now); err != nil {
+		logger.WithFields(logrus.Fields{
+			"name": q.opt.Name,
+			"err":  err,
+		}).Error("[Quiet]发送暂存的消息失败")
+	}
+	return q.sink.Receive(msg)
+}
+
+// Run 每个免打扰时段结束时唤醒sink发送暂存的消息，暂存的消息在sink的队列中发送，同时运行被包装的sink
+func (q *QuietSink) Run(ctx context.Context, wake func(target string)) {
+	if r, ok := q.sink.(SinkRunner); ok {
+		go r.Run(ctx, wake)
+	}
+	for {
+		_, end := q.hours.window(time.Now(), q.opt.Location)
+		timer := time.NewTimer(time.Until(end))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			wake(q.Name())
+		}
+	}
+}
+
+// 把暂存的消息合并成汇总发送，发送失败的类型的消息继续暂存，必须持有锁
+func (q *QuietSink) flush(now time.Time) error {
+	if len(q.held) == 0 {
+		return nil
+	}
+	logger.WithFields(logrus.Fields{
+		"name":      q.opt.Name,
+		"len(held)": len(q.held),
+	}).Info("[Quiet]免打扰结束，发送暂存的消息")
+	failed := make(map[int]bool)
+	var errs []string
+	for _, msg := range digestMsgs(q.held, now) {
+		if err := q.sink.Receive(msg); err != nil {
+			failed[msg.Flag] = true
+			errs = append(errs, err.Error())
+		}
+	}
+	var rest []*push.Msg
+	for _, msg := range q.held {
+		if failed[msg.Flag] {
+			rest = append(rest, msg)
+		}
+	}
+	q.held = rest
+	saveState(q.storeName(), q.held)
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
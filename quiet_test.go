@@ -0,0 +1,243 @@
+package forwardBot
+
+import (
+	"forwardBot/push"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		name    string
+		start   string
+		end     string
+		want    string
+		wantErr bool
+	}{
+		{"case normal", "13:00", "14:30", "13:00-14:30", false},
+		{"case overnight", "23:00", "08:00", "23:00-08:00", false},
+		{"case same", "08:00", "08:00", "", true},
+		{"case invalid", "8点", "9点", "", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := ParseQuietHours(test.start, test.end)
+			if test.wantErr {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, test.want, q.String())
+		})
+	}
+}
+
+func TestQuietHours_Window(t *testing.T) {
+	loc := time.FixedZone("UTC+8", 8*60*60)
+	overnight, _ := ParseQuietHours("23:00", "08:00")
+	noon, _ := ParseQuietHours("12:00", "14:00")
+	date := func(day, hour, min int) time.Time {
+		return time.Date(2022, 10, day, hour, min, 0, 0, loc)
+	}
+	tests := []struct {
+		name  string
+		hours QuietHours
+		t     time.Time
+		start time.Time
+		end   time.Time
+		quiet bool
+	}{
+		{"case overnight after midnight", overnight, date(2, 3, 0), date(1, 23, 0), date(2, 8, 0), true},
+		{"case overnight before midnight", overnight, date(1, 23, 30), date(1, 23, 0), date(2, 8, 0), true},
+		{"case overnight day", overnight, date(2, 8, 0), date(2, 23, 0), date(3, 8, 0), false},
+		{"case noon", noon, date(1, 13, 0), date(1, 12, 0), date(1, 14, 0), true},
+		{"case before noon", noon, date(1, 9, 0), date(1, 12, 0), date(1, 14, 0), false},
+		{"case after noon", noon, date(1, 15, 0), date(2, 12, 0), date(2, 14, 0), false},
+		//其他时区的时间按照设置的时区计算
+		{"case utc", overnight, time.Date(2022, 10, 1, 20, 0, 0, 0, time.UTC), date(1, 23, 0), date(2, 8, 0), true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start, end := test.hours.window(test.t, loc)
+			assert.True(t, test.start.Equal(start), "start want %v, got %v", test.start, start)
+			assert.True(t, test.end.Equal(end), "end want %v, got %v", test.end, end)
+			assert.Equal(t, test.quiet, test.hours.contains(test.t, loc))
+		})
+	}
+}
+
+func TestQuietSink_Receive(t *testing.T) {
+	sink := &recordSink{ch: make(chan *push.Msg, 10)}
+	q, err := NewQuietSink(sink, QuietOption{Start: "00:00", End: "12:00"})
+	assert.Nil(t, err)
+	//设置为包含当前时间的时段
+	local := time.Now()
+	offset := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	q.hours = QuietHours{Start: offset, End: (offset + time.Hour) % (24 * time.Hour)}
+
+	live := &push.Msg{Flag: BiliLiveMsg, Author: "a", Title: "B站开播了"}
+	assert.Nil(t, q.Receive(live))
+	assert.Equal(t, live, <-sink.ch)
+	assert.Nil(t, q.Receive(&push.Msg{Flag: BiliDynMsg, Author: "a", Title: "发布动态"}))
+	assert.Nil(t, q.Receive(&push.Msg{Flag: BiliDynMsg, Author: "b", Title: "转发动态"}))
+	assert.Empty(t, sink.ch)
+	assert.Len(t, q.held, 2)
+
+	//时段结束后先发送暂存的消息
+	q.hours = QuietHours{Start: (offset + time.Hour) % (24 * time.Hour), End: (offset + 2*time.Hour) % (24 * time.Hour)}
+	weibo := &push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博"}
+	assert.Nil(t, q.Receive(weibo))
+	assert.Len(t, sink.ch, 2)
+	assert.Equal(t, "消息汇总（2条）", (<-sink.ch).Title)
+	assert.Equal(t, weibo, <-sink.ch)
+	assert.Empty(t, q.held)
+}
+
+// 包含当前时间的免打扰时段
+func quietNow() QuietHours {
+	local := time.Now()
+	offset := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	return QuietHours{Start: offset, End: (offset + time.Hour) % (24 * time.Hour)}
+}
+
+// 不包含当前时间的免打扰时段
+func quietLater() QuietHours {
+	q := quietNow()
+	return QuietHours{Start: (q.Start + time.Hour) % (24 * time.Hour), End: (q.Start + 2*time.Hour) % (24 * time.Hour)}
+}
+
+func TestQuietSink_Wake(t *testing.T) {
+	sink := &failSink{recordSink: recordSink{ch: make(chan *push.Msg, 10)}, flag: WeiboMsg}
+	q, err := NewQuietSink(sink, QuietOption{Start: "00:00", End: "12:00"})
+	assert.Nil(t, err)
+	q.hours = quietNow()
+	weibo := &push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博"}
+	assert.Nil(t, q.Receive(weibo))
+	assert.Nil(t, q.Receive(&push.Msg{Flag: BiliDynMsg, Author: "a", Title: "发布动态"}))
+	//时段内的唤醒和其他sink的唤醒不发送暂存的消息
+	assert.Nil(t, q.Receive(wakeMsg(q.Name())))
+	q.hours = quietLater()
+	assert.Nil(t, q.Receive(wakeMsg("other")))
+	assert.Empty(t, sink.ch)
+	assert.NotNil(t, q.Receive(wakeMsg(q.Name())))
+	assert.Equal(t, "消息汇总（1条）", (<-sink.ch).Title)
+	//发送失败的消息继续暂存
+	assert.Equal(t, []*push.Msg{weibo}, q.held)
+}
+
+func TestQuietSink_Nested(t *testing.T) {
+	sink := &recordSink{ch: make(chan *push.Msg, 10)}
+	q, err := NewQuietSink(sink, QuietOption{Name: "nested", Start: "00:00", End: "12:00"})
+	assert.Nil(t, err)
+	q.hours = quietNow()
+	d, err := NewDigestSink(q, DigestOption{Name: "nested"})
+	assert.Nil(t, err)
+	text := "这是一条很长很长很长很长很长很长很长很长很长很长很长很长很长很长很长的动态内容"
+	assert.Nil(t, d.Receive(&push.Msg{Flag: BiliDynMsg, Author: "a", Title: "发布动态", Text: text}))
+	d.lock.Lock()
+	assert.Nil(t, d.flush(time.Now()))
+	d.lock.Unlock()
+	//汇总在免打扰时段内被暂存，结束后原样发送，不会被再次汇总
+	q.hours = quietLater()
+	assert.Nil(t, d.Receive(wakeMsg(q.Name())))
+	msg := <-sink.ch
+	assert.Equal(t, "消息汇总（1条）", msg.Title)
+	assert.Equal(t, "1. [01-01 00:00] a 发布动态\n   "+truncate(text, digestTextLen), msg.Text)
+	assert.Empty(t, sink.ch)
+}
+
+func TestCQBotSink_Quiet(t *testing.T) {
+	c := NewCQBotSink("", "", 1)
+	c.quiet = make(map[uint64]*cqChannelQuiet)
+	var gId, cId uint64 = 1, 2
+	c.table[gId] = make([]uint64, AllMsgNum)
+	for i := range c.table[gId] {
+		c.table[gId][i] = cId
+	}
+	var sent []string
+	fail := true
+	c.send = func(gId, cId uint64, msg string) error {
+		//发送时不持有quietLock
+		assert.True(t, c.quietLock.TryLock())
+		c.quietLock.Unlock()
+		if fail {
+			return errors.New("send fail")
+		}
+		sent = append(sent, msg)
+		return nil
+	}
+	c.quiet[cId] = &cqChannelQuiet{GuildId: gId, Hours: quietNow()}
+	assert.Nil(t, c.Receive(&push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博"}))
+	assert.Len(t, c.quiet[cId].Held, 1)
+	assert.Empty(t, c.releasable(time.Now()))
+
+	//发送失败时继续暂存
+	c.quiet[cId].Hours = quietLater()
+	assert.Equal(t, []uint64{cId}, c.releasable(time.Now()))
+	assert.Nil(t, c.Receive(wakeMsg(cqWakeTarget)))
+	assert.Len(t, c.quiet[cId].Held, 1)
+	fail = false
+	assert.Nil(t, c.Receive(wakeMsg(cqWakeTarget)))
+	assert.Empty(t, c.quiet[cId].Held)
+	if assert.Len(t, sent, 1) {
+		assert.Contains(t, sent[0], "消息汇总（1条）")
+	}
+
+	//取消免打扰后唤醒sink在队列中发送暂存的消息
+	var woken []string
+	c.wake = func(target string) {
+		woken = append(woken, target)
+	}
+	c.quiet[cId].Hours = quietNow()
+	assert.Nil(t, c.Receive(&push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博"}))
+	c.CancelQuiet(gId, cId)
+	assert.Equal(t, []string{cqWakeTarget}, woken)
+	assert.Nil(t, c.Receive(wakeMsg(cqWakeTarget)))
+	assert.NotContains(t, c.quiet, cId)
+	assert.Len(t, sent, 3)
+}
+
+// 告警消息不经过队列直接调用Receive时，暂存的消息只发送一次
+func TestCQBotSink_ConcurrentRelease(t *testing.T) {
+	c := NewCQBotSink("", "", 1)
+	c.quiet = make(map[uint64]*cqChannelQuiet)
+	var gId, cId uint64 = 1, 2
+	c.table[gId] = make([]uint64, AllMsgNum)
+	for i := range c.table[gId] {
+		c.table[gId][i] = cId
+	}
+	var lock sync.Mutex
+	var sent []string
+	started, unblock := make(chan struct{}), make(chan struct{})
+	c.send = func(gId, cId uint64, msg string) error {
+		if strings.Contains(msg, "消息汇总") {
+			close(started)
+			<-unblock
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		sent = append(sent, msg)
+		return nil
+	}
+	c.quiet[cId] = &cqChannelQuiet{GuildId: gId, Hours: quietNow()}
+	assert.Nil(t, c.Receive(&push.Msg{Flag: WeiboMsg, Author: "a", Title: "发布微博"}))
+	assert.Nil(t, c.Receive(&push.Msg{Flag: WeiboMsg, Author: "b", Title: "发布微博"}))
+	c.quiet[cId].Hours = quietLater()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.Nil(t, c.Receive(wakeMsg(cqWakeTarget)))
+	}()
+	<-started
+	//发送暂存消息期间收到告警
+	assert.Nil(t, c.Receive(&push.Msg{Flag: BotAlertMsg, Author: "bot", Title: "告警"}))
+	close(unblock)
+	<-done
+	assert.Len(t, sent, 2)
+	assert.Empty(t, c.quiet[cId].Held)
+}
@@ -0,0 +1,283 @@
+package req
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	biliNavUrl     = "https://api.bilibili.com/x/web-interface/nav"
+	biliSpiUrl     = "https://api.bilibili.com/x/frontend/finger/spi"
+	biliReferer    = "https://www.bilibili.com/"
+	wbiKeyTTL      = 6 * time.Hour //mixin key的缓存时间
+	riskBackoffMin = time.Minute   //触发风控后第一次等待的时间
+	riskBackoffMax = 30 * time.Minute
+	buvidRetryGap  = time.Minute //获取buvid失败后等待多久再重试
+)
+
+const (
+	RiskCodeVerify   = -352 //风控校验失败
+	RiskCodeBlocked  = -412 //请求被拦截
+	RiskCodeFrequent = -799 //请求过于频繁
+)
+
+var (
+	// 打乱img_key和sub_key的顺序表
+	mixinKeyEncTab = []int{
+		46, 47, 18, 2, 53, 8, 23, 32, 15, 50, 10, 31, 58, 3, 45, 35, 27, 43, 5, 49,
+		33, 9, 42, 19, 29, 28, 14, 39, 12, 38, 41, 13, 37, 48, 7, 16, 24, 55, 40,
+		61, 26, 17, 0, 1, 60, 51, 30, 4, 22, 25, 54, 21, 56, 59, 6, 63, 57, 62, 11,
+		36, 20, 34, 44, 52,
+	}
+	// 签名前需要从参数值中去掉的字符
+	wbiFilter = strings.NewReplacer("!", "", "'", "", "(", "", ")", "", "*", "")
+)
+
+// RiskError b站风控错误，出现后BiliClient会在一段时间内拒绝请求
+type RiskError struct {
+	Code  int
+	Msg   string
+	Until time.Time //在此之前不再发送请求
+}
+
+func (e *RiskError) Error() string {
+	return fmt.Sprintf("bili risk control, code=%d, msg=%s, until=%s",
+		e.Code, e.Msg, e.Until.Format("15:04:05"))
+}
+
+// IsRiskError 判断是否是b站风控错误
+func IsRiskError(err error) bool {
+	var r *RiskError
+	return errors.As(err, &r)
+}
+
+// riskState 一个接口的风控退避状态
+type riskState struct {
+	backoff time.Duration //下一次触发风控时等待的时间
+	until   time.Time
+	err     *RiskError
+}
+
+// BiliClient 请求b站接口的客户端，负责wbi签名、buvid cookie和风控退避
+// 风控退避按接口分别记录，一个接口触发风控不影响其他接口
+type BiliClient struct {
+	*C
+	lock       sync.Mutex            //保护risks
+	risks      map[string]*riskState //每个接口的风控状态，key为host+path
+	keyLock    sync.Mutex            //保护mixinKey和keyTime，同一时间只有一个请求获取mixin key
+	mixinKey   string
+	keyTime    time.Time  //mixin key获取的时间
+	buvidLock  sync.Mutex //保护hasBuvid和buvidRetry，同一时间只有一个请求获取buvid
+	hasBuvid   bool
+	buvidRetry time.Time //获取buvid失败后，在此之前不再重试
+	navUrl     string
+	spiUrl     string
+}
+
+func NewBili(timeout int) *BiliClient {
+	return &BiliClient{
+		C:      New(timeout),
+		risks:  make(map[string]*riskState),
+		navUrl: biliNavUrl,
+		spiUrl: biliSpiUrl,
+	}
+}
+
+// 接口的标识，忽略参数
+func endpoint(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return u.Host + u.Path
+}
+
+// Get 发送GET请求，sign为true时对参数进行wbi签名
+// 返回的code为风控错误码或者http状态码为412时返回*RiskError
+func (b *BiliClient) Get(link string, params D, sign bool) (*bytes.Buffer, error) {
+	now := time.Now()
+	api := endpoint(link)
+	if err := b.blocked(api, now); err != nil {
+		return nil, err
+	}
+	b.ensureBuvid(now)
+	if sign {
+		key, err := b.wbiKey(now)
+		if err != nil {
+			return nil, errors.Wrap(err, "get wbi key fail")
+		}
+		params = signWbi(params, key, now)
+	}
+	buf, err := b.C.Get(link, params, nil, E{"Referer", biliReferer})
+	var status *StatusError
+	if errors.As(err, &status) && status.Code == 412 {
+		return nil, b.risk(api, RiskCodeBlocked, status.Status, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if code, msg, ok := riskCode(buf.Bytes()); ok {
+		return nil, b.risk(api, code, msg, now)
+	}
+	b.lock.Lock()
+	delete(b.risks, api)
+	b.lock.Unlock()
+	return buf, nil
+}
+
+// 接口处于风控退避期间时返回错误
+func (b *BiliClient) blocked(api string, now time.Time) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if r, ok := b.risks[api]; ok && now.Before(r.until) {
+		return r.err
+	}
+	return nil
+}
+
+// 记录接口的风控错误，等待时间每次翻倍，同时让mixin key和buvid在下次请求时重新获取
+func (b *BiliClient) risk(api string, code int, msg string, now time.Time) error {
+	b.lock.Lock()
+	r, ok := b.risks[api]
+	if !ok {
+		r = &riskState{backoff: riskBackoffMin}
+		b.risks[api] = r
+	}
+	r.until = now.Add(r.backoff)
+	r.err = &RiskError{Code: code, Msg: msg, Until: r.until}
+	r.backoff *= 2
+	if r.backoff > riskBackoffMax {
+		r.backoff = riskBackoffMax
+	}
+	err := r.err
+	b.lock.Unlock()
+
+	b.keyLock.Lock()
+	b.keyTime = time.Time{}
+	b.keyLock.Unlock()
+	b.buvidLock.Lock()
+	b.hasBuvid = false
+	b.buvidRetry = time.Time{}
+	b.buvidLock.Unlock()
+	return err
+}
+
+// 获取buvid3和buvid4，失败时不影响请求，等待buvidRetryGap后再重试
+// 获取期间其他请求等待获取结果，不会重复获取
+func (b *BiliClient) ensureBuvid(now time.Time) {
+	b.buvidLock.Lock()
+	defer b.buvidLock.Unlock()
+	if b.hasBuvid || now.Before(b.buvidRetry) {
+		return
+	}
+	buf, err := b.C.Get(b.spiUrl, nil, nil, E{"Referer", biliReferer})
+	if err != nil {
+		b.buvidRetry = now.Add(buvidRetryGap)
+		return
+	}
+	data := gjson.GetBytes(buf.Bytes(), "data")
+	b3, b4 := data.Get("b_3").String(), data.Get("b_4").String()
+	if b3 == "" {
+		b.buvidRetry = now.Add(buvidRetryGap)
+		return
+	}
+	b.C.SetCookies("buvid3", b3)
+	if b4 != "" {
+		b.C.SetCookies("buvid4", b4)
+	}
+	b.hasBuvid = true
+}
+
+// 获取mixin key，超过wbiKeyTTL后重新获取，获取期间其他请求等待获取结果
+func (b *BiliClient) wbiKey(now time.Time) (string, error) {
+	b.keyLock.Lock()
+	defer b.keyLock.Unlock()
+	if b.mixinKey != "" && now.Sub(b.keyTime) < wbiKeyTTL {
+		return b.mixinKey, nil
+	}
+	buf, err := b.C.Get(b.navUrl, nil, nil, E{"Referer", biliReferer})
+	if err != nil {
+		return "", err
+	}
+	//未登录时code为-101，但是仍然会返回wbi_img
+	wbi := gjson.GetBytes(buf.Bytes(), "data.wbi_img")
+	imgUrl, subUrl := wbi.Get("img_url").String(), wbi.Get("sub_url").String()
+	if imgUrl == "" || subUrl == "" {
+		return "", errors.New("不存在data.wbi_img字段")
+	}
+	b.mixinKey = mixinKey(wbiKeyName(imgUrl) + wbiKeyName(subUrl))
+	b.keyTime = now
+	return b.mixinKey, nil
+}
+
+// 从"https://i0.hdslb.com/bfs/wbi/xxx.png"中获取"xxx"
+func wbiKeyName(link string) string {
+	name := path.Base(link)
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+// 按照mixinKeyEncTab打乱img_key+sub_key，取前32位
+func mixinKey(raw string) string {
+	key := strings.Builder{}
+	for _, i := range mixinKeyEncTab {
+		if i < len(raw) {
+			key.WriteByte(raw[i])
+		}
+	}
+	s := key.String()
+	if len(s) > 32 {
+		s = s[:32]
+	}
+	return s
+}
+
+// 对参数进行wbi签名，返回添加了wts和w_rid的参数
+func signWbi(params D, key string, now time.Time) D {
+	signed := make(D, 0, len(params)+2)
+	for i := range params {
+		signed = append(signed, E{params[i].Name, wbiFilter.Replace(fmt.Sprint(params[i].Value))})
+	}
+	signed = append(signed, E{"wts", strconv.FormatInt(now.Unix(), 10)})
+	sort.SliceStable(signed, func(i, j int) bool {
+		return signed[i].Name < signed[j].Name
+	})
+	query := strings.Builder{}
+	for i := range signed {
+		if i != 0 {
+			query.WriteByte('&')
+		}
+		query.WriteString(wbiEscape(signed[i].Name))
+		query.WriteByte('=')
+		query.WriteString(wbiEscape(signed[i].Value.(string)))
+	}
+	hash := md5.Sum([]byte(query.String() + key))
+	return append(signed, E{"w_rid", hex.EncodeToString(hash[:])})
+}
+
+// 与js的encodeURIComponent一致，空格编码为%20
+func wbiEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// 判断响应是否是风控错误
+func riskCode(body []byte) (code int, msg string, ok bool) {
+	r := gjson.ParseBytes(body)
+	code = int(r.Get("code").Int())
+	switch code {
+	case RiskCodeVerify, RiskCodeBlocked, RiskCodeFrequent:
+		return code, r.Get("message").String(), true
+	}
+	return 0, "", false
+}
@@ -0,0 +1,108 @@
+package req
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignWbi(t *testing.T) {
+	key := mixinKey(wbiKeyName("https://i0.hdslb.com/bfs/wbi/7cd084941338484aae1ad9425b84077c.png") +
+		wbiKeyName("https://i0.hdslb.com/bfs/wbi/4932caff0ff746eab6f01bf08b70ac45.png"))
+	assert.Equal(t, "ea1db124af3c7062474693fa704f4ff8", key)
+	signed := signWbi(D{
+		{"foo", "114"},
+		{"bar", "514"},
+		{"zab", 1919810},
+	}, key, time.Unix(1702204169, 0))
+	assert.Equal(t, D{
+		{"bar", "514"},
+		{"foo", "114"},
+		{"wts", "1702204169"},
+		{"zab", "1919810"},
+		{"w_rid", "8f6f2b5b3d485fe1886cec6a0be8c5d4"},
+	}, signed)
+}
+
+func TestRiskCode(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code int
+		ok   bool
+	}{
+		{"case ok", `{"code":0,"message":"0"}`, 0, false},
+		{"case not login", `{"code":-101,"message":"账号未登录"}`, 0, false},
+		{"case verify", `{"code":-352,"message":"风控校验失败"}`, RiskCodeVerify, true},
+		{"case blocked", `{"code":-412,"message":"请求被拦截"}`, RiskCodeBlocked, true},
+		{"case frequent", `{"code":-799,"message":"请求过于频繁，请稍后再试"}`, RiskCodeFrequent, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			code, _, ok := riskCode([]byte(test.body))
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.code, code)
+		})
+	}
+}
+
+func TestBiliClient_Risk(t *testing.T) {
+	b := NewBili(1)
+	now := time.Now()
+	dyn := endpoint("https://api.bilibili.com/x/polymer/web-dynamic/v1/feed/space?host_mid=1")
+	live := endpoint("https://api.live.bilibili.com/room/v1/Room/get_status_info_by_uids")
+	err := b.risk(dyn, RiskCodeVerify, "风控校验失败", now)
+	assert.True(t, IsRiskError(err))
+	assert.Equal(t, err, b.blocked(dyn, now.Add(time.Second)))
+	assert.Equal(t, err, b.blocked(endpoint("https://api.bilibili.com/x/polymer/web-dynamic/v1/feed/space?host_mid=2"),
+		now.Add(time.Second)))
+	//其他接口不受影响
+	assert.Nil(t, b.blocked(live, now.Add(time.Second)))
+	assert.Nil(t, b.blocked(dyn, now.Add(riskBackoffMin)))
+	//连续触发风控时等待时间翻倍，不超过riskBackoffMax
+	for i := 0; i < 10; i++ {
+		_ = b.risk(dyn, RiskCodeVerify, "", now)
+	}
+	assert.Equal(t, riskBackoffMax, b.risks[dyn].backoff)
+	assert.NotNil(t, b.blocked(dyn, now.Add(riskBackoffMax-time.Second)))
+}
+
+func TestBiliClient_EnsureBuvid(t *testing.T) {
+	var calls int32
+	fail := int32(1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0,"data":{"b_3":"b3","b_4":"b4"}}`))
+	}))
+	defer server.Close()
+	b := NewBili(1)
+	b.spiUrl = server.URL
+	now := time.Now()
+	//失败后在buvidRetryGap内不再重试
+	b.ensureBuvid(now)
+	b.ensureBuvid(now.Add(time.Second))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.False(t, b.hasBuvid)
+
+	atomic.StoreInt32(&fail, 0)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.ensureBuvid(now.Add(buvidRetryGap))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.True(t, b.hasBuvid)
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/andybalholm/brotli"
@@ -28,9 +29,21 @@ var (
 	defaultClient = New(5)
 )
 
+// StatusError 响应的状态码不是200
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("request fail, status=%s", e.Status)
+}
+
 type C struct {
-	client  *http.Client
-	cookies map[string]string
+	client      *http.Client
+	lock        sync.RWMutex
+	cookies     map[string]string
+	saveCookies bool //是否保存响应中Set-Cookie设置的cookie
 }
 
 func New(timeout int) *C {
@@ -40,12 +53,61 @@ func New(timeout int) *C {
 }
 
 func (c *C) SetCookies(name, value string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	if c.cookies == nil {
 		c.cookies = make(map[string]string)
 	}
 	c.cookies[name] = value
 }
 
+// Cookie 获取cookie的值
+func (c *C) Cookie(name string) (string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	v, ok := c.cookies[name]
+	return v, ok
+}
+
+// ClearCookies 删除指定的cookie，names为空时删除全部cookie
+func (c *C) ClearCookies(names ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(names) == 0 {
+		c.cookies = nil
+		return
+	}
+	for _, name := range names {
+		delete(c.cookies, name)
+	}
+}
+
+// SaveRespCookies 设置是否保存响应中Set-Cookie设置的cookie，用于cookie会过期刷新的网站
+func (c *C) SaveRespCookies(save bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.saveCookies = save
+}
+
+// 保存响应中的cookie，值为空或者已过期的cookie会被删除
+func (c *C) storeCookies(resp *http.Response) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.saveCookies {
+		return
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Value == "" || cookie.MaxAge < 0 {
+			delete(c.cookies, cookie.Name)
+			continue
+		}
+		if c.cookies == nil {
+			c.cookies = make(map[string]string)
+		}
+		c.cookies[cookie.Name] = cookie.Value
+	}
+}
+
 // 选择对应的解压算法解压响应体
 func compress(resp *http.Response) (reader io.Reader) {
 	contentEncoding := resp.Header.Get("Content-Encoding")
@@ -83,6 +145,7 @@ func (c *C) request(method, link string, params D, body io.Reader,
 	for i := range headers {
 		req.Header.Set(headers[i].Name, fmt.Sprint(headers[i].Value))
 	}
+	c.lock.RLock()
 	for k := range c.cookies {
 		req.AddCookie(&http.Cookie{
 			Name:  k,
@@ -90,14 +153,16 @@ func (c *C) request(method, link string, params D, body io.Reader,
 			Path:  "/",
 		})
 	}
+	c.lock.RUnlock()
 	resp, err := c.client.Do(req)
 	if err != nil {
 		return nil, errors.Wrap(err, "request error")
 	}
 	defer resp.Body.Close()
+	c.storeCookies(resp)
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New(fmt.Sprintf("request fail, status=%s", resp.Status))
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
 	}
 
 	reader := compress(resp)
@@ -0,0 +1,38 @@
+package req
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestC_SaveRespCookies(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "__ac_nonce", Value: "0632"})
+		http.SetCookie(w, &http.Cookie{Name: "expired", Value: "1", MaxAge: -1})
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	c := New(5)
+	c.SetCookies("expired", "0")
+	_, err := c.Get(server.URL, nil, nil)
+	assert.Nil(t, err)
+	_, ok := c.Cookie("__ac_nonce")
+	assert.False(t, ok, "未开启时不保存响应中的cookie")
+
+	c.SaveRespCookies(true)
+	_, err = c.Get(server.URL, nil, nil)
+	assert.Nil(t, err)
+	v, ok := c.Cookie("__ac_nonce")
+	assert.True(t, ok)
+	assert.Equal(t, "0632", v)
+	_, ok = c.Cookie("expired")
+	assert.False(t, ok)
+
+	c.ClearCookies("__ac_nonce")
+	_, ok = c.Cookie("__ac_nonce")
+	assert.False(t, ok)
+}
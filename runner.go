@@ -0,0 +1,223 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"forwardBot/push"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sourceRestartDelay = 10 * time.Second //source意外退出后第一次重新启动前等待的时间
+	sourceRestartMax   = 10 * time.Minute //连续意外退出时等待时间的上限
+)
+
+// SourceStatus source的运行状态
+type SourceStatus struct {
+	Name     string
+	Running  bool
+	Started  time.Time //最近一次启动的时间
+	Restarts int       //意外退出后重新启动的次数
+	Panics   int       //发生panic的次数，包括source中其他goroutine的panic
+	Emitted  int64     //发送的消息数量
+	LastEmit time.Time //最近一次发送消息的时间
+	SourceHealth
+}
+
+// sourceRunner 管理一个source的启动和停止，source意外退出时重新启动
+type sourceRunner struct {
+	name    string
+	source  Source
+	lock    sync.Mutex
+	cancel  context.CancelFunc //为nil时没有运行
+	done    chan struct{}      //runner退出时关闭
+	delay   time.Duration      //意外退出后第一次重新启动前等待的时间，之后每次翻倍
+	maxWait time.Duration      //等待时间的上限，运行超过该时间后退出时重置等待时间
+	onPanic panicHandler       //发生panic时的回调，可以为nil
+	status  SourceStatus
+}
+
+func newSourceRunner(name string, source Source) *sourceRunner {
+	return &sourceRunner{
+		name:    name,
+		source:  source,
+		delay:   sourceRestartDelay,
+		maxWait: sourceRestartMax,
+		status:  SourceStatus{Name: name},
+	}
+}
+
+// 获取source或sink的名称，没有实现Named时使用类型名称
+func nameOf(s any) string {
+	if n, ok := s.(Named); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", s)
+}
+
+// 启动source，已经在运行时返回错误
+func (r *sourceRunner) start(ctx context.Context, ch chan<- *push.Msg) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.cancel != nil {
+		return errors.New("source is running")
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+	r.status.Running = true
+	go r.run(ctx, ch, r.done)
+	logger.WithField("name", r.name).Info("启动source")
+	return nil
+}
+
+// 停止source并等待退出，没有运行时返回错误
+func (r *sourceRunner) stop() error {
+	r.lock.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel = nil
+	r.lock.Unlock()
+	if cancel == nil {
+		return errors.New("source is not running")
+	}
+	cancel()
+	<-done
+	logger.WithField("name", r.name).Info("停止source")
+	return nil
+}
+
+// 运行source直到ctx结束，Send意外返回或者panic时退避后重新启动
+func (r *sourceRunner) run(ctx context.Context, ch chan<- *push.Msg, done chan struct{}) {
+	defer close(done)
+	defer func() {
+		r.lock.Lock()
+		r.status.Running = false
+		r.lock.Unlock()
+	}()
+	delay := r.delay
+	for {
+		start := time.Now()
+		r.lock.Lock()
+		r.status.Started = start
+		r.lock.Unlock()
+		r.send(ctx, ch)
+		if ctx.Err() != nil {
+			return
+		}
+		//运行了一段时间，说明不是连续失败，重置等待时间
+		if time.Since(start) > r.maxWait {
+			delay = r.delay
+		}
+		logger.WithFields(logrus.Fields{
+			"name":  r.name,
+			"delay": delay,
+		}).Warn("source意外退出，稍后重新启动")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > r.maxWait {
+			delay = r.maxWait
+		}
+		r.lock.Lock()
+		r.status.Restarts++
+		r.lock.Unlock()
+	}
+}
+
+// 调用source的Send方法，通过中间的channel统计发送的消息，Send发生panic时恢复并返回
+func (r *sourceRunner) send(ctx context.Context, ch chan<- *push.Msg) {
+	ctx, cancel := context.WithCancel(withPanicHandler(ctx, r.panicked))
+	defer cancel()
+	out := make(chan *push.Msg)
+	stop := make(chan struct{})
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for {
+			select {
+			case <-stop:
+				return
+			case msg, ok := <-out:
+				if !ok {
+					return
+				}
+				r.lock.Lock()
+				r.status.Emitted++
+				r.status.LastEmit = time.Now()
+				r.lock.Unlock()
+				ch <- msg
+			}
+		}
+	}()
+	defer func() {
+		if v := recover(); v != nil {
+			//Send启动的goroutine可能还在发送消息，不能关闭out，通过cancel让它们退出
+			reportPanic(ctx, r.name, v, debug.Stack())
+			close(stop)
+		} else {
+			close(out)
+		}
+		<-forwarded
+	}()
+	r.source.Send(ctx, out)
+}
+
+// 记录panic并通知回调
+func (r *sourceRunner) panicked(name string, v any) {
+	r.lock.Lock()
+	r.status.Panics++
+	r.lock.Unlock()
+	if r.onPanic != nil {
+		r.onPanic(name, v)
+	}
+}
+
+// 获取source的运行状态
+func (r *sourceRunner) snapshot() SourceStatus {
+	r.lock.Lock()
+	status := r.status
+	r.lock.Unlock()
+	if h, ok := r.source.(HealthReporter); ok {
+		status.SourceHealth = h.Health()
+	}
+	return status
+}
+
+// 生成source运行状态的文字说明，每个source一行
+func sourceStatusText(status []SourceStatus) string {
+	text := strings.Builder{}
+	for i := range status {
+		s := &status[i]
+		if i != 0 {
+			text.WriteByte('\n')
+		}
+		state := "已停止"
+		if s.Running {
+			state = "运行中"
+		}
+		text.WriteString(fmt.Sprintf("%s %s 消息%d条 重启%d次", s.Name, state, s.Emitted, s.Restarts))
+		if s.Panics != 0 {
+			text.WriteString(fmt.Sprintf(" panic%d次", s.Panics))
+		}
+		if len(s.Failing) != 0 {
+			ids := make([]string, 0, len(s.Failing))
+			for id := range s.Failing {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			for j, id := range ids {
+				ids[j] = fmt.Sprintf("%s连续失败%d次", id, s.Failing[id])
+			}
+			text.WriteString(" " + strings.Join(ids, "，"))
+		}
+	}
+	return text.String()
+}
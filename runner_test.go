@@ -0,0 +1,213 @@
+package forwardBot
+
+import (
+	"context"
+	"forwardBot/push"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 发送一条消息后返回的source，用于测试意外退出
+type exitSource struct {
+	health
+	runs int32
+}
+
+func (e *exitSource) Name() string {
+	return "exit"
+}
+
+func (e *exitSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	atomic.AddInt32(&e.runs, 1)
+	e.fail(1, errors.New("test"))
+	ch <- &push.Msg{Title: "test"}
+}
+
+// 在Send和调度的goroutine中panic的source
+type panicSource struct {
+	sched *scheduler
+}
+
+func (p *panicSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	each(ctx, p.sched, []int{1, 2}, func(id int) {
+		panic("poll")
+	})
+	ch <- &push.Msg{Title: "test"}
+	panic("send")
+}
+
+// 接收消息时panic的sink
+type panicSink struct{}
+
+func (panicSink) Receive(msg *push.Msg) error {
+	panic("receive")
+}
+
+// 记录收到的消息的sink
+type recordSink struct {
+	ch chan *push.Msg
+}
+
+func (r *recordSink) Receive(msg *push.Msg) error {
+	r.ch <- msg
+	return nil
+}
+
+// 一直运行到ctx结束的source
+type blockSource struct{}
+
+func (blockSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	<-ctx.Done()
+}
+
+func TestSourceName(t *testing.T) {
+	assert.Equal(t, "exit", nameOf(&exitSource{}))
+	assert.Equal(t, "forwardBot.blockSource", nameOf(blockSource{}))
+	b := NewBot(1)
+	b.AppendSource(blockSource{}, blockSource{})
+	assert.Equal(t, "forwardBot.blockSource#2", b.sources[1].name)
+	//监控都有自己的名称，不使用类型名称
+	assert.Equal(t, "[BiliLive]", nameOf(NewBiliLiveSource(nil)))
+	assert.Equal(t, "[Weibo]", nameOf(NewWeiboSource(nil, nil)))
+}
+
+func TestSourceRunner_Restart(t *testing.T) {
+	s := &exitSource{}
+	r := newSourceRunner("exit", s)
+	r.delay = 10 * time.Millisecond
+	ch := make(chan *push.Msg, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, r.start(ctx, ch))
+	assert.NotNil(t, r.start(ctx, ch))
+	time.Sleep(55 * time.Millisecond)
+	assert.Nil(t, r.stop())
+	assert.NotNil(t, r.stop())
+
+	status := r.snapshot()
+	runs := atomic.LoadInt32(&s.runs)
+	assert.GreaterOrEqual(t, runs, int32(3))
+	assert.False(t, status.Running)
+	assert.Equal(t, int(runs-1), status.Restarts)
+	assert.Equal(t, int64(runs), status.Emitted)
+	assert.Equal(t, int(runs), status.Errors)
+	assert.Len(t, ch, int(runs))
+}
+
+func TestBot_StopSource(t *testing.T) {
+	b := NewBot(1)
+	b.AppendSource(blockSource{})
+	name := "forwardBot.blockSource"
+	assert.NotNil(t, b.StartSource(name))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+	time.Sleep(10 * time.Millisecond)
+	assert.True(t, b.Status()[0].Running)
+	assert.Nil(t, b.StopSource(name))
+	assert.False(t, b.Status()[0].Running)
+	assert.Nil(t, b.StartSource(name))
+	assert.NotNil(t, b.StopSource("unknown"))
+}
+
+// 只有管理员可以停止监控
+func TestCQBotSink_Manage(t *testing.T) {
+	b := NewBot(1)
+	b.AppendSource(&exitSource{})
+	c := NewCQBotSink("", "", 1)
+	c.SetBot(b)
+	c.SetAdmins([]uint64{100})
+	var reply string
+	c.send = func(gId, cId uint64, msg string) error {
+		reply = msg
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.Run(ctx)
+	time.Sleep(10 * time.Millisecond)
+
+	c.Manage(1, 2, 200, CQBotCmdSourceStop, []string{"exit"})
+	assert.Equal(t, "只有管理员可以使用该指令", reply)
+	assert.True(t, b.Status()[0].Running)
+	c.Manage(1, 2, 200, CQBotCmdStatus, nil)
+	assert.Equal(t, "只有管理员可以使用该指令", reply)
+	c.Manage(1, 2, 100, CQBotCmdSourceStop, []string{"exit"})
+	assert.Equal(t, "exit 停止监控成功", reply)
+	assert.False(t, b.Status()[0].Running)
+	//没有设置管理员时不允许任何人使用
+	c.SetAdmins(nil)
+	c.Manage(1, 2, 100, CQBotCmdSourceStart, []string{"exit"})
+	assert.Equal(t, "只有管理员可以使用该指令", reply)
+	assert.False(t, b.Status()[0].Running)
+}
+
+func TestSourceRunner_Panic(t *testing.T) {
+	s := &panicSource{sched: newScheduler("[test]", "panic.test", time.Second)}
+	SetHostInterval("panic.test", 0)
+	r := newSourceRunner("panic", s)
+	r.delay = 10 * time.Millisecond
+	r.maxWait = 40 * time.Millisecond
+	var panics int32
+	r.onPanic = func(name string, v any) {
+		atomic.AddInt32(&panics, 1)
+	}
+	ch := make(chan *push.Msg, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Nil(t, r.start(ctx, ch))
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, r.stop())
+
+	status := r.snapshot()
+	//每次运行时两个轮询和Send各panic一次
+	assert.GreaterOrEqual(t, status.Restarts, 1)
+	assert.GreaterOrEqual(t, status.Panics, (status.Restarts+1)*3)
+	assert.Equal(t, int32(status.Panics), atomic.LoadInt32(&panics))
+	assert.GreaterOrEqual(t, status.Emitted, int64(status.Restarts+1))
+}
+
+func TestBot_Receive(t *testing.T) {
+	b := NewBot(1)
+	admin := &recordSink{ch: make(chan *push.Msg, 1)}
+	b.SetAdminSink(admin)
+	b.receive(newSinkWorker("forwardBot.panicSink", panicSink{}, QueueOption{}), &push.Msg{})
+	assert.Equal(t, map[string]int{"forwardBot.panicSink": 1}, b.SinkPanics())
+	select {
+	case msg := <-admin.ch:
+		assert.Equal(t, BotAlertMsg, msg.Flag)
+		assert.Equal(t, "forwardBot.panicSink发生panic：receive", msg.Text)
+	case <-time.After(time.Second):
+		t.Error("没有收到告警消息")
+	}
+}
+
+func TestHealth(t *testing.T) {
+	h := &health{}
+	for i := 0; i < 3; i++ {
+		h.fail(1, errors.New("broken"))
+		h.succeed(2)
+	}
+	status := h.Health()
+	//其他ID的成功不会清零一直失败的ID的连续失败次数
+	assert.Equal(t, 3, status.Errors)
+	assert.Equal(t, map[string]int{"1": 3}, status.Failing)
+	assert.EqualError(t, status.LastError, "broken")
+	assert.False(t, status.LastSuccess.IsZero())
+	h.succeed(1)
+	status = h.Health()
+	assert.Equal(t, 0, status.Errors)
+	assert.Empty(t, status.Failing)
+}
+
+func TestSourceStatusText(t *testing.T) {
+	status := []SourceStatus{
+		{Name: "a", Running: true, Emitted: 3, SourceHealth: SourceHealth{Failing: map[string]int{"2": 1, "1": 5}}},
+		{Name: "b", Restarts: 1, Panics: 2},
+	}
+	assert.Equal(t, "a 运行中 消息3条 重启0次 1连续失败5次，2连续失败1次\n"+
+		"b 已停止 消息0条 重启1次 panic2次", sourceStatusText(status))
+}
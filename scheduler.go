@@ -0,0 +1,329 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers = 4 //每个source同时进行的请求数量
+)
+
+// SchedulerOption 轮询的并发和随机偏移设置
+type SchedulerOption struct {
+	Workers int           //每个source同时进行的请求数量，为0时使用默认值
+	Jitter  time.Duration //每次轮询时间的随机偏移，避免所有请求集中在同一时刻
+}
+
+var (
+	schedulerOpt = SchedulerOption{Workers: defaultWorkers}
+	//每个主机的最小请求间隔
+	hostLimiters     = make(map[string]*hostLimiter)
+	hostLimitersLock sync.Mutex
+)
+
+// SetSchedulerOption 设置轮询的并发和随机偏移，必须在创建source之前调用
+func SetSchedulerOption(opt SchedulerOption) {
+	if opt.Workers <= 0 {
+		opt.Workers = defaultWorkers
+	}
+	if opt.Jitter < 0 {
+		opt.Jitter = 0
+	}
+	logger.WithFields(logrus.Fields{
+		"workers": opt.Workers,
+		"jitter":  opt.Jitter,
+	}).Info("设置轮询并发")
+	schedulerOpt = opt
+}
+
+// SetHostInterval 设置对同一个主机的最小请求间隔，默认为waitInterval
+func SetHostInterval(host string, gap time.Duration) {
+	logger.WithFields(logrus.Fields{
+		"host": host,
+		"gap":  gap,
+	}).Info("设置主机请求间隔")
+	limiter(host).setGap(gap)
+}
+
+// hostLimiter 限制对同一个主机的请求频率，两次请求之间至少间隔gap
+type hostLimiter struct {
+	lock sync.Mutex
+	gap  time.Duration
+	next time.Time //下一次可以请求的时间
+}
+
+func limiter(host string) *hostLimiter {
+	hostLimitersLock.Lock()
+	defer hostLimitersLock.Unlock()
+	h, ok := hostLimiters[host]
+	if !ok {
+		h = &hostLimiter{gap: waitInterval}
+		hostLimiters[host] = h
+	}
+	return h
+}
+
+func (h *hostLimiter) setGap(gap time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.gap = gap
+}
+
+// 等待到可以请求的时间，ctx结束时返回false
+func (h *hostLimiter) wait(ctx context.Context) bool {
+	h.lock.Lock()
+	now := time.Now()
+	at := h.next
+	if at.Before(now) {
+		at = now
+	}
+	h.next = at.Add(h.gap)
+	h.lock.Unlock()
+	if !at.After(now) {
+		return true
+	}
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// scheduler 以有限的并发轮询多个id，每个id有独立的时间表，
+// 上一次轮询没有结束时跳过错过的轮询，不会重叠
+type scheduler struct {
+	name      string //日志中使用的名称
+	host      string //请求的主机，用于限制请求频率
+	lock      sync.RWMutex
+	interval  time.Duration
+	intervals map[string]time.Duration                     //单独设置了间隔的id
+	adaptive  func(id string, now time.Time) time.Duration //根据时间调整间隔，返回0时不调整
+	jitter    time.Duration
+	sem       chan struct{}
+}
+
+func newScheduler(name, host string, interval time.Duration) *scheduler {
+	return &scheduler{
+		name:     name,
+		host:     host,
+		interval: interval,
+		jitter:   schedulerOpt.Jitter,
+		sem:      make(chan struct{}, schedulerOpt.Workers),
+	}
+}
+
+// 设置默认的轮询间隔和单独设置间隔的id，间隔小于等于0时不修改
+func (s *scheduler) setInterval(d time.Duration, intervals map[string]time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if d > 0 {
+		s.interval = d
+	}
+	s.intervals = make(map[string]time.Duration, len(intervals))
+	for id, v := range intervals {
+		if v > 0 {
+			s.intervals[id] = v
+		}
+	}
+	logger.WithFields(logrus.Fields{
+		"interval":  s.interval,
+		"intervals": s.intervals,
+	}).Info(s.name + "设置轮询间隔")
+}
+
+// 设置根据时间调整间隔的函数，返回的间隔比设置的间隔短时使用返回的间隔
+func (s *scheduler) setAdaptive(fn func(id string, now time.Time) time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.adaptive = fn
+}
+
+// 获取id在now时的轮询间隔
+func (s *scheduler) intervalOf(id any, now time.Time) time.Duration {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	key := fmt.Sprint(id)
+	d := s.interval
+	if v, ok := s.intervals[key]; ok {
+		d = v
+	}
+	if s.adaptive != nil {
+		if v := s.adaptive(key, now); v > 0 && v < d {
+			d = v
+		}
+	}
+	return d
+}
+
+// 获取并发名额并等待主机的请求间隔，ctx结束时返回false
+func (s *scheduler) acquire(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case s.sem <- struct{}{}:
+	}
+	if !limiter(s.host).wait(ctx) {
+		<-s.sem
+		return false
+	}
+	return true
+}
+
+func (s *scheduler) release() {
+	<-s.sem
+}
+
+// 下一次轮询的基准时间，已经错过的轮询会被跳过，返回跳过的次数
+func next(base, now time.Time, interval time.Duration) (time.Time, int) {
+	next := base.Add(interval)
+	skipped := 0
+	for !next.After(now) {
+		next = next.Add(interval)
+		skipped++
+	}
+	return next, skipped
+}
+
+// 在基准时间上加上随机偏移，偏移不超过间隔的一半，保证轮询的顺序不变
+func (s *scheduler) jittered(base time.Time, interval time.Duration) time.Time {
+	jitter := s.jitter
+	if jitter > interval/2 {
+		jitter = interval / 2
+	}
+	if jitter <= 0 {
+		return base
+	}
+	return base.Add(time.Duration(rand.Int63n(int64(2*jitter))) - jitter)
+}
+
+func (s *scheduler) loop(ctx context.Context, id any, base time.Time,
+	intervalOf func(now time.Time) time.Duration, fn func(now time.Time)) {
+	timer := time.NewTimer(time.Until(s.jittered(base, intervalOf(base))))
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-timer.C:
+			fn(now)
+			var skipped int
+			d := intervalOf(time.Now())
+			base, skipped = next(base, time.Now(), d)
+			if skipped != 0 {
+				logger.WithFields(logrus.Fields{
+					"id":      id,
+					"skipped": skipped,
+				}).Warn(s.name + "轮询耗时超过间隔，跳过本次轮询")
+			}
+			timer.Reset(time.Until(s.jittered(base, d)))
+		}
+	}
+}
+
+// schedule 为每个id启动独立的轮询，第一次轮询的时间在一个间隔内随机分布，ctx结束后返回
+// poll发生panic时只跳过本次轮询，没有id时等待ctx结束，避免被当作意外退出而不断重启
+func schedule[T any](ctx context.Context, s *scheduler, ids []T, poll func(id T, now time.Time)) {
+	if len(ids) == 0 {
+		<-ctx.Done()
+		return
+	}
+	wg := sync.WaitGroup{}
+	for i := range ids {
+		id := ids[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			intervalOf := func(now time.Time) time.Duration {
+				return s.intervalOf(id, now)
+			}
+			first := time.Now().Add(time.Duration(rand.Int63n(int64(intervalOf(time.Now())))))
+			s.loop(ctx, id, first, intervalOf, func(now time.Time) {
+				if !s.acquire(ctx) {
+					return
+				}
+				defer s.release()
+				defer recoverPanic(ctx, s.name)
+				poll(id, now)
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+// batch 用于一次请求获取多个id的source，每个id仍然按照自己的间隔轮询，
+// 每次把已经到时间的id合并成一批交给poll，间隔相同的id总是在同一批中，ctx结束后返回
+// poll执行时间超过间隔时跳过错过的轮询，poll不会重叠，没有id时和schedule一样等待ctx结束
+func batch[T any](ctx context.Context, s *scheduler, ids []T, poll func(due []T, now time.Time)) {
+	if len(ids) == 0 {
+		<-ctx.Done()
+		return
+	}
+	start := time.Now()
+	bases := make([]time.Time, len(ids)) //每个id下一次轮询的基准时间
+	for i, id := range ids {
+		bases[i] = start.Add(s.intervalOf(id, start))
+	}
+	for {
+		earliest := 0
+		for i := range bases {
+			if bases[i].Before(bases[earliest]) {
+				earliest = i
+			}
+		}
+		base := bases[earliest]
+		timer := time.NewTimer(time.Until(s.jittered(base, s.intervalOf(ids[earliest], base))))
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now = <-timer.C:
+		}
+		var due []T
+		var index []int
+		for i := range ids {
+			if !bases[i].After(base) {
+				due = append(due, ids[i])
+				index = append(index, i)
+			}
+		}
+		poll(due, now)
+		for _, i := range index {
+			var skipped int
+			bases[i], skipped = next(bases[i], time.Now(), s.intervalOf(ids[i], time.Now()))
+			if skipped != 0 {
+				logger.WithFields(logrus.Fields{
+					"id":      ids[i],
+					"skipped": skipped,
+				}).Warn(s.name + "轮询耗时超过间隔，跳过本次轮询")
+			}
+		}
+	}
+}
+
+// each 以有限的并发对每个id执行一次poll，全部结束后返回
+func each[T any](ctx context.Context, s *scheduler, ids []T, poll func(id T)) {
+	wg := sync.WaitGroup{}
+	for i := range ids {
+		if !s.acquire(ctx) {
+			break
+		}
+		id := ids[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.release()
+			defer recoverPanic(ctx, s.name)
+			poll(id)
+		}()
+	}
+	wg.Wait()
+}
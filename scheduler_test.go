@@ -0,0 +1,157 @@
+package forwardBot
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	base := time.Date(2022, 10, 1, 20, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		now     time.Duration
+		next    time.Duration
+		skipped int
+	}{
+		{"case in time", time.Second, 10 * time.Second, 0},
+		{"case just missed", 10 * time.Second, 20 * time.Second, 1},
+		{"case missed several", 35 * time.Second, 40 * time.Second, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			next, skipped := next(base, base.Add(test.now), 10*time.Second)
+			assert.Equal(t, base.Add(test.next), next)
+			assert.Equal(t, test.skipped, skipped)
+		})
+	}
+}
+
+func TestScheduler_IntervalOf(t *testing.T) {
+	s := &scheduler{interval: 10 * time.Minute}
+	s.setInterval(0, map[string]time.Duration{"2": time.Minute, "3": 0})
+	s.setAdaptive(func(id string, now time.Time) time.Duration {
+		if id == "1" || id == "2" {
+			return 5 * time.Minute
+		}
+		return 0
+	})
+	tests := []struct {
+		name     string
+		id       any
+		interval time.Duration
+	}{
+		{"case adaptive shorter", int64(1), 5 * time.Minute},
+		{"case account shorter than adaptive", 2, time.Minute},
+		{"case zero account interval ignored", "3", 10 * time.Minute},
+		{"case default", "4", 10 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.interval, s.intervalOf(test.id, time.Now()))
+		})
+	}
+}
+
+func TestHostLimiter_Wait(t *testing.T) {
+	h := &hostLimiter{gap: 20 * time.Millisecond}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		assert.True(t, h.wait(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.next = time.Now().Add(time.Hour)
+	assert.False(t, h.wait(ctx))
+}
+
+func TestSchedule(t *testing.T) {
+	s := &scheduler{
+		name:     "[test]",
+		host:     "scheduler.test",
+		interval: 20 * time.Millisecond,
+		sem:      make(chan struct{}, 2),
+	}
+	SetHostInterval(s.host, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var running, maxRunning int32
+	lock := sync.Mutex{}
+	busy := make(map[int]bool)
+	overlap := false
+	polls := make(map[int]int)
+	schedule(ctx, s, []int{1, 2, 3, 4}, func(id int, now time.Time) {
+		lock.Lock()
+		if busy[id] {
+			overlap = true
+		}
+		busy[id] = true
+		polls[id]++
+		lock.Unlock()
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		//轮询耗时超过间隔
+		time.Sleep(30 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		lock.Lock()
+		busy[id] = false
+		lock.Unlock()
+	})
+	assert.False(t, overlap)
+	assert.LessOrEqual(t, maxRunning, int32(2))
+	for _, id := range []int{1, 2, 3, 4} {
+		assert.Greater(t, polls[id], 0)
+	}
+
+	//没有id时等待ctx结束，不会作为意外退出
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	schedule(ctx, s, []int{}, func(id int, now time.Time) {
+		t.Error("没有id时不应该轮询")
+	})
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+// 批量轮询时每个id按自己的间隔轮询，间隔相同的id在同一批中
+func TestBatch(t *testing.T) {
+	s := &scheduler{
+		name:      "[test]",
+		interval:  20 * time.Millisecond,
+		intervals: map[string]time.Duration{"3": 100 * time.Millisecond},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 330*time.Millisecond)
+	defer cancel()
+	polls := make(map[int]int)
+	batch(ctx, s, []int{1, 2, 3}, func(due []int, now time.Time) {
+		assert.Contains(t, due, 1)
+		assert.Contains(t, due, 2)
+		for _, id := range due {
+			polls[id]++
+		}
+	})
+	assert.Equal(t, polls[1], polls[2])
+	//间隔较长的id轮询次数更少，不会使用批次中最短的间隔
+	assert.GreaterOrEqual(t, polls[1], 8)
+	assert.GreaterOrEqual(t, polls[3], 2)
+	assert.LessOrEqual(t, polls[3], 3)
+
+	//没有id时等待ctx结束，不会作为意外退出
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	batch(ctx, s, []int{}, func(due []int, now time.Time) {
+		t.Error("没有id时不应该轮询")
+	})
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
@@ -17,6 +17,36 @@ type Sink interface {
 	Receive(msg *push.Msg) error
 }
 
+// SinkRunner 需要定时发送消息的sink，例如定时发送汇总，Bot启动时在单独的goroutine中调用Run
+// 到时间时调用wake把唤醒消息加入sink的队列，唤醒消息和普通消息一样按顺序交给Receive处理，
+// 定时发送的消息不会和其他消息乱序，发生panic时也会被恢复
+type SinkRunner interface {
+	Run(ctx context.Context, wake func(target string))
+}
+
+const wakeMsgFlag = -1 //唤醒消息的类型，不会被推送
+
+// 生成唤醒target的消息
+func wakeMsg(target string) *push.Msg {
+	return &push.Msg{Times: time.Now(), Flag: wakeMsgFlag, Author: target}
+}
+
+// 消息是唤醒消息时返回唤醒的目标
+func wakeTarget(msg *push.Msg) (string, bool) {
+	if msg.Flag != wakeMsgFlag {
+		return "", false
+	}
+	return msg.Author, true
+}
+
+// 把不是发给自己的唤醒消息交给被包装的sink，被包装的sink不需要定时运行时忽略
+func forwardWake(sink Sink, msg *push.Msg) error {
+	if _, ok := sink.(SinkRunner); !ok {
+		return nil
+	}
+	return sink.Receive(msg)
+}
+
 var _ Sink = (*PushSink)(nil)
 
 type PushSink struct {
@@ -39,20 +69,90 @@ func (p *PushSink) Receive(msg *push.Msg) error {
 }
 
 const (
-	CQBotCmdHelp             = "/啵啵"
-	CQBotCmdAll              = "/订阅全部"
-	CQBotCmdAllCancel        = "/取消订阅"
-	CQBotCmdBiliLive         = "/b站开播"
-	CQBotCmdBiliLiveCancel   = "/取消b站开播"
-	CQBotCmdBiliDyn          = "/b站动态"
-	CQBotCmdBiliDynCancel    = "/取消b站动态"
-	CQBotCmdTiktokLive       = "/抖音开播"
-	CQBotCmdTiktokLiveCancel = "/取消抖音开播"
-	CQBotCmdPushTest         = "/推送测试"
+	CQBotCmdHelp               = "/啵啵"
+	CQBotCmdAll                = "/订阅全部"
+	CQBotCmdAllCancel          = "/取消订阅"
+	CQBotCmdBiliLive           = "/b站开播"
+	CQBotCmdBiliLiveCancel     = "/取消b站开播"
+	CQBotCmdBiliDyn            = "/b站动态"
+	CQBotCmdBiliDynCancel      = "/取消b站动态"
+	CQBotCmdTiktokLive         = "/抖音开播"
+	CQBotCmdTiktokLiveCancel   = "/取消抖音开播"
+	CQBotCmdJSONPoll           = "/自定义推送"
+	CQBotCmdJSONPollCancel     = "/取消自定义推送"
+	CQBotCmdWeibo              = "/微博动态"
+	CQBotCmdWeiboCancel        = "/取消微博动态"
+	CQBotCmdDouyuLive          = "/斗鱼开播"
+	CQBotCmdDouyuLiveCancel    = "/取消斗鱼开播"
+	CQBotCmdHuyaLive           = "/虎牙开播"
+	CQBotCmdHuyaLiveCancel     = "/取消虎牙开播"
+	CQBotCmdBiliInfo           = "/b站直播间变更"
+	CQBotCmdBiliInfoCancel     = "/取消b站直播间变更"
+	CQBotCmdBiliSC             = "/b站醒目留言"
+	CQBotCmdBiliSCCancel       = "/取消b站醒目留言"
+	CQBotCmdBiliGuard          = "/b站上舰"
+	CQBotCmdBiliGuardCancel    = "/取消b站上舰"
+	CQBotCmdBiliVideo          = "/b站投稿"
+	CQBotCmdBiliVideoCancel    = "/取消b站投稿"
+	CQBotCmdBiliFollower       = "/b站粉丝"
+	CQBotCmdBiliFollowerCancel = "/取消b站粉丝"
+	CQBotCmdTiktokPost         = "/抖音作品"
+	CQBotCmdTiktokPostCancel   = "/取消抖音作品"
+	CQBotCmdBotAlert           = "/bot告警"
+	CQBotCmdBotAlertCancel     = "/取消bot告警"
+	CQBotCmdQuiet              = "/免打扰"
+	CQBotCmdQuietCancel        = "/取消免打扰"
+	CQBotCmdPushTest           = "/推送测试"
+	CQBotCmdLiveSessions       = "/直播记录"
+	CQBotCmdStatus             = "/运行状态"
+	CQBotCmdSourceStart        = "/启动监控"
+	CQBotCmdSourceStop         = "/停止监控"
 )
-const AllMsgNum = 3
+const AllMsgNum = 14
+
+const (
+	cqQuietStoreName     = "cqbot_quiet"
+	cqQuietCheckInterval = time.Minute    //检查频道免打扰时段是否结束的间隔
+	cqSessionNum         = 5              //直播记录指令最多回复的记录数量
+	cqWakeTarget         = "[CQBot]quiet" //发送频道暂存消息的唤醒消息
+)
+
+// cqBotSubCmd 订阅某一类消息的指令
+type cqBotSubCmd struct {
+	sub    string //订阅指令
+	cancel string //取消订阅指令
+	desc   string //指令说明
+	flag   int    //消息类型
+}
+
+var cqBotSubCmds = []cqBotSubCmd{
+	{CQBotCmdBiliLive, CQBotCmdBiliLiveCancel, "订阅b站开播消息", BiliLiveMsg},
+	{CQBotCmdBiliDyn, CQBotCmdBiliDynCancel, "订阅b站动态更新消息", BiliDynMsg},
+	{CQBotCmdTiktokLive, CQBotCmdTiktokLiveCancel, "订阅抖音开播消息", TikTokLiveMsg},
+	{CQBotCmdJSONPoll, CQBotCmdJSONPollCancel, "订阅自定义接口的消息", JSONPollMsg},
+	{CQBotCmdWeibo, CQBotCmdWeiboCancel, "订阅微博更新消息", WeiboMsg},
+	{CQBotCmdDouyuLive, CQBotCmdDouyuLiveCancel, "订阅斗鱼开播消息", DouyuLiveMsg},
+	{CQBotCmdHuyaLive, CQBotCmdHuyaLiveCancel, "订阅虎牙开播消息", HuyaLiveMsg},
+	{CQBotCmdBiliInfo, CQBotCmdBiliInfoCancel, "订阅b站直播间标题、分区、封面变更消息", BiliLiveInfoMsg},
+	{CQBotCmdBiliSC, CQBotCmdBiliSCCancel, "订阅b站直播间醒目留言消息", BiliSuperChatMsg},
+	{CQBotCmdBiliGuard, CQBotCmdBiliGuardCancel, "订阅b站直播间上舰消息", BiliGuardMsg},
+	{CQBotCmdBiliVideo, CQBotCmdBiliVideoCancel, "订阅b站视频投稿和播放量里程碑消息", BiliVideoMsg},
+	{CQBotCmdBiliFollower, CQBotCmdBiliFollowerCancel, "订阅b站粉丝数里程碑和日变化消息", BiliFollowerMsg},
+	{CQBotCmdTiktokPost, CQBotCmdTiktokPostCancel, "订阅抖音作品更新消息", TikTokPostMsg},
+	{CQBotCmdBotAlert, CQBotCmdBotAlertCancel, "订阅bot运行异常的告警消息", BotAlertMsg},
+}
+
+// cqChannelQuiet 频道的免打扰设置和暂存的消息
+type cqChannelQuiet struct {
+	GuildId  uint64      `json:"guildId"`
+	Hours    QuietHours  `json:"hours"`
+	Held     []*push.Msg `json:"held"`
+	Canceled bool        `json:"canceled"` //已经取消免打扰，暂存的消息发送完成后删除
+	sending  bool        //正在发送暂存的消息，其他goroutine不再重复发送
+}
 
 var _ Sink = (*CQBotSink)(nil)
+var _ SinkRunner = (*CQBotSink)(nil)
 
 type CQBotSink struct {
 	bot       *qbot.CQBot
@@ -60,6 +160,15 @@ type CQBotSink struct {
 	bufSize   int
 	lock      sync.RWMutex
 	heartbeat int64 //上一次收到心跳包的时间
+	quietLock sync.Mutex
+	quiet     map[uint64]*cqChannelQuiet //设置了免打扰的频道
+	location  *time.Location             //免打扰时段使用的时区
+	urgent    map[int]bool               //免打扰时段内仍然发送的消息类型
+	owner     *Bot                       //查询直播记录、运行状态的bot，为nil时不支持查询
+	admins    map[uint64]bool            //可以查询运行状态和启停监控的用户，为空时不允许任何人使用
+	wake      func(target string)        //唤醒sink发送暂存的消息，Run之前为nil
+	//发送频道消息，测试时可以替换
+	send func(gId, cId uint64, msg string) error
 }
 
 func NewCQBotSink(host, token string, bufSize int) *CQBotSink {
@@ -83,19 +192,44 @@ func NewCQBotSink(host, token string, bufSize int) *CQBotSink {
 		}
 		return true
 	})
-	return &CQBotSink{
-		bot:     qbot.NewCQBot(host, token),
-		table:   make(map[uint64][]uint64),
-		bufSize: bufSize,
+	c := &CQBotSink{
+		bot:      qbot.NewCQBot(host, token),
+		table:    make(map[uint64][]uint64),
+		bufSize:  bufSize,
+		quiet:    make(map[uint64]*cqChannelQuiet),
+		location: time.Local,
+		urgent:   urgentSet(nil),
 	}
+	c.send = c.bot.SendGuildMsg
+	loadState(cqQuietStoreName, &c.quiet)
+	return c
 }
 
-func (c *CQBotSink) Receive(msg *push.Msg) error {
-	logger.Info("CQBot发送消息")
-	if len(c.table) == 0 {
-		logger.Info("无频道订阅消息")
-		return nil
+// SetQuietOption 设置频道免打扰时段使用的时区和免打扰时段内仍然发送的消息类型，urgent为nil时使用开播和告警消息
+func (c *CQBotSink) SetQuietOption(loc *time.Location, urgent []int) {
+	c.quietLock.Lock()
+	defer c.quietLock.Unlock()
+	if loc != nil {
+		c.location = loc
+	}
+	c.urgent = urgentSet(urgent)
+}
+
+// SetBot 设置查询直播记录、运行状态和启停source使用的bot
+func (c *CQBotSink) SetBot(b *Bot) {
+	c.owner = b
+}
+
+// SetAdmins 设置可以使用运行状态、启动监控和停止监控指令的用户，必须在Listen之前调用
+func (c *CQBotSink) SetAdmins(ids []uint64) {
+	c.admins = make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		c.admins[id] = true
 	}
+}
+
+// 生成发送到频道的消息内容
+func cqMsgText(msg *push.Msg) string {
 	text := strings.Builder{}
 	text.WriteString(msg.Times.Format("2006-01-02 15:04"))
 	text.WriteByte('\n')
@@ -114,7 +248,26 @@ func (c *CQBotSink) Receive(msg *push.Msg) error {
 		}
 		text.WriteString(img.String())
 	}
-	msgContent := text.String()
+	return text.String()
+}
+
+func (c *CQBotSink) Receive(msg *push.Msg) error {
+	if target, ok := wakeTarget(msg); ok {
+		if target == cqWakeTarget {
+			now := time.Now()
+			for _, cId := range c.releasable(now) {
+				c.release(cId, now)
+			}
+		}
+		return nil
+	}
+	logger.Info("CQBot发送消息")
+	if len(c.table) == 0 {
+		logger.Info("无频道订阅消息")
+		return nil
+	}
+	msgContent := cqMsgText(msg)
+	now := time.Now()
 	var err error
 	c.lock.RLock()
 	defer c.lock.RUnlock()
@@ -136,7 +289,12 @@ func (c *CQBotSink) Receive(msg *push.Msg) error {
 			}).Debug("当前频道未订阅该消息")
 			continue
 		}
-		err = c.bot.SendGuildMsg(gId, cId, msgContent)
+		if c.hold(cId, msg, now) {
+			continue
+		}
+		//时段已经结束但还有暂存的消息时先发送暂存的消息，保证顺序
+		c.release(cId, now)
+		err = c.send(gId, cId, msgContent)
 		if err != nil {
 			logger.WithFields(logrus.Fields{
 				"guildId":   gId,
@@ -148,6 +306,183 @@ func (c *CQBotSink) Receive(msg *push.Msg) error {
 	return nil
 }
 
+// 频道在免打扰时段内时暂存非紧急的消息并返回true
+func (c *CQBotSink) hold(cId uint64, msg *push.Msg, now time.Time) bool {
+	c.quietLock.Lock()
+	defer c.quietLock.Unlock()
+	q := c.quiet[cId]
+	if q == nil || q.Canceled || c.urgent[msg.Flag] || !q.Hours.contains(now, c.location) {
+		return false
+	}
+	q.Held = append(q.Held, msg)
+	saveState(cqQuietStoreName, c.quiet)
+	logger.WithFields(logrus.Fields{
+		"guildId":   q.GuildId,
+		"channelId": cId,
+		"flag":      msg.Flag,
+	}).Debug("频道免打扰中，暂存消息")
+	return true
+}
+
+// 频道的免打扰时段已经结束或者被取消时，把暂存的消息合并成汇总发送，发送失败的类型的消息继续暂存
+// 告警消息不经过sink的队列直接调用Receive，可能在多个goroutine中同时调用，
+// 发送时不持有quietLock，由sending保证同一批消息只发送一次，发送期间hold只会在末尾追加消息
+func (c *CQBotSink) release(cId uint64, now time.Time) {
+	c.quietLock.Lock()
+	q := c.quiet[cId]
+	if q == nil || q.sending || (!q.Canceled && q.Hours.contains(now, c.location)) {
+		c.quietLock.Unlock()
+		return
+	}
+	gId, msgs := q.GuildId, q.Held
+	if len(msgs) == 0 {
+		if q.Canceled {
+			delete(c.quiet, cId)
+			saveState(cqQuietStoreName, c.quiet)
+		}
+		c.quietLock.Unlock()
+		return
+	}
+	q.sending = true
+	c.quietLock.Unlock()
+
+	logger.WithFields(logrus.Fields{
+		"guildId":   gId,
+		"channelId": cId,
+		"len(held)": len(msgs),
+	}).Info("频道免打扰结束，发送暂存的消息")
+	failed := make(map[int]bool)
+	for _, msg := range digestMsgs(msgs, now) {
+		if err := c.send(gId, cId, cqMsgText(msg)); err != nil {
+			failed[msg.Flag] = true
+			logger.WithFields(logrus.Fields{
+				"guildId":   gId,
+				"channelId": cId,
+				"err":       err,
+			}).Error("发送频道消息失败")
+		}
+	}
+
+	c.quietLock.Lock()
+	defer c.quietLock.Unlock()
+	var rest []*push.Msg
+	for _, msg := range msgs {
+		if failed[msg.Flag] {
+			rest = append(rest, msg)
+		}
+	}
+	q.Held = append(rest, q.Held[len(msgs):]...)
+	q.sending = false
+	if q.Canceled && len(q.Held) == 0 && c.quiet[cId] == q {
+		delete(c.quiet, cId)
+	}
+	saveState(cqQuietStoreName, c.quiet)
+}
+
+// 需要发送暂存消息的频道
+func (c *CQBotSink) releasable(now time.Time) []uint64 {
+	c.quietLock.Lock()
+	defer c.quietLock.Unlock()
+	var cIds []uint64
+	for cId, q := range c.quiet {
+		if len(q.Held) != 0 && (q.Canceled || !q.Hours.contains(now, c.location)) {
+			cIds = append(cIds, cId)
+		}
+	}
+	return cIds
+}
+
+// Run 定时检查频道的免打扰时段，有需要发送的暂存消息时唤醒sink，暂存的消息在sink的队列中发送
+func (c *CQBotSink) Run(ctx context.Context, wake func(target string)) {
+	c.quietLock.Lock()
+	c.wake = wake
+	c.quietLock.Unlock()
+	ticker := time.NewTicker(cqQuietCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if len(c.releasable(now)) != 0 {
+				wake(cqWakeTarget)
+			}
+		}
+	}
+}
+
+// SetQuiet 设置频道的免打扰时段，params为开始和结束时间，为空时返回当前的设置
+func (c *CQBotSink) SetQuiet(gId, cId uint64, params []string) {
+	c.quietLock.Lock()
+	defer c.quietLock.Unlock()
+	var reply string
+	switch len(params) {
+	case 0:
+		if q := c.quiet[cId]; q != nil && !q.Canceled {
+			reply = fmt.Sprintf("当前频道的免打扰时段为%s，暂存%d条消息", q.Hours, len(q.Held))
+		} else {
+			reply = fmt.Sprintf("当前频道未设置免打扰，设置示例：%s 23:00 08:00", CQBotCmdQuiet)
+		}
+	case 2:
+		hours, err := ParseQuietHours(params[0], params[1])
+		if err != nil {
+			reply = fmt.Sprintf("时间格式错误，设置示例：%s 23:00 08:00", CQBotCmdQuiet)
+			break
+		}
+		if q := c.quiet[cId]; q != nil {
+			q.Hours = hours
+			q.Canceled = false
+		} else {
+			c.quiet[cId] = &cqChannelQuiet{GuildId: gId, Hours: hours}
+		}
+		saveState(cqQuietStoreName, c.quiet)
+		reply = fmt.Sprintf("设置成功，%s期间开播等紧急消息以外的消息将在免打扰结束后汇总发送", hours)
+	default:
+		reply = fmt.Sprintf("参数错误，设置示例：%s 23:00 08:00", CQBotCmdQuiet)
+	}
+	if err := c.bot.SendGuildMsg(gId, cId, reply); err != nil {
+		logger.WithFields(logrus.Fields{
+			"guildId":   gId,
+			"channelId": cId,
+			"err":       err,
+		}).Error("发送频道消息失败")
+	}
+}
+
+// CancelQuiet 取消频道的免打扰，唤醒sink在队列中发送暂存的消息
+func (c *CQBotSink) CancelQuiet(gId, cId uint64) {
+	c.quietLock.Lock()
+	q := c.quiet[cId]
+	canceled := q != nil && !q.Canceled
+	held := false //是否有需要发送的暂存消息
+	if canceled {
+		if held = len(q.Held) != 0; held {
+			q.Canceled = true
+		} else {
+			delete(c.quiet, cId)
+		}
+		saveState(cqQuietStoreName, c.quiet)
+	}
+	wake := c.wake
+	c.quietLock.Unlock()
+	var err error
+	if canceled {
+		if wake != nil && held {
+			wake(cqWakeTarget)
+		}
+		err = c.send(gId, cId, "取消成功")
+	} else {
+		err = c.send(gId, cId, "当前频道未设置免打扰")
+	}
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"guildId":   gId,
+			"channelId": cId,
+			"err":       err,
+		}).Error("发送频道消息失败")
+	}
+}
+
 func (c *CQBotSink) Listen(ctx context.Context) error {
 	logger.Info("CQBot监听消息")
 	err := c.bot.Connect(ctx)
@@ -210,52 +545,128 @@ func (c *CQBotSink) Listen(ctx context.Context) error {
 						"qq": strconv.FormatUint(msg.SenderId, 10),
 					},
 				}
-				content := fmt.Sprintf("%s当前可用指令：\n"+
+				content := strings.Builder{}
+				content.WriteString(fmt.Sprintf("%s当前可用指令：\n"+
 					"%s 订阅所有消息\n"+
-					"%s 取消消息订阅\n"+
-					"%s 订阅b站开播消息\n"+
-					"%s\n"+
-					"%s 订阅b站动态更新消息\n"+
-					"%s\n"+
-					"%s 订阅抖音开播消息\n"+
-					"%s\n"+
-					"%s", at.String(), CQBotCmdAll, CQBotCmdAllCancel,
-					CQBotCmdBiliLive, CQBotCmdBiliLiveCancel,
-					CQBotCmdBiliDyn, CQBotCmdBiliDynCancel,
-					CQBotCmdTiktokLive, CQBotCmdTiktokLiveCancel,
-					CQBotCmdPushTest)
-				_ = c.bot.SendGuildMsg(gId, cId, content)
+					"%s 取消消息订阅\n", at.String(), CQBotCmdAll, CQBotCmdAllCancel))
+				for _, sub := range cqBotSubCmds {
+					content.WriteString(fmt.Sprintf("%s %s\n%s\n", sub.sub, sub.desc, sub.cancel))
+				}
+				content.WriteString(fmt.Sprintf("%s 23:00 08:00 设置免打扰时段，期间开播等紧急消息以外的消息在结束后汇总发送\n%s\n",
+					CQBotCmdQuiet, CQBotCmdQuietCancel))
+				content.WriteString(fmt.Sprintf("%s 房间号 查询最近的直播记录\n", CQBotCmdLiveSessions))
+				content.WriteString(fmt.Sprintf("%s 查询各个监控的运行状态和消息队列（仅管理员）\n"+
+					"%s 名称 启动被停止的监控（仅管理员）\n%s 名称 停止监控（仅管理员）\n",
+					CQBotCmdStatus, CQBotCmdSourceStart, CQBotCmdSourceStop))
+				content.WriteString(CQBotCmdPushTest)
+				_ = c.bot.SendGuildMsg(gId, cId, content.String())
 			case CQBotCmdAll:
 				c.SubscribeAll(gId, cId)
 			case CQBotCmdAllCancel:
 				c.UnsubscribeAll(gId, cId)
-			case CQBotCmdBiliDyn:
-				c.Subscribe(gId, cId, BiliDynMsg)
-			case CQBotCmdBiliLive:
-				c.Subscribe(gId, cId, BiliLiveMsg)
-			case CQBotCmdTiktokLive:
-				c.Subscribe(gId, cId, TikTokLiveMsg)
-			case CQBotCmdTiktokLiveCancel:
-				c.Unsubscribe(gId, cId, TikTokLiveMsg)
-			case CQBotCmdBiliLiveCancel:
-				c.Unsubscribe(gId, cId, BiliLiveMsg)
-			case CQBotCmdBiliDynCancel:
-				c.Unsubscribe(gId, cId, BiliDynMsg)
+			case CQBotCmdQuiet:
+				c.SetQuiet(gId, cId, cmd.Params)
+			case CQBotCmdQuietCancel:
+				c.CancelQuiet(gId, cId)
+			case CQBotCmdLiveSessions:
+				c.LiveSessions(gId, cId, cmd.Params)
+			case CQBotCmdStatus, CQBotCmdSourceStart, CQBotCmdSourceStop:
+				c.Manage(gId, cId, msg.SenderId, cmd.Cmd, cmd.Params)
 			case CQBotCmdPushTest:
 				if testSource.running {
 					testType := 0
-					if len(cmd.Params) == 1 && len(cmd.Params[0]) != 0 {
-						testType = int(cmd.Params[0][0]) - '0'
+					if len(cmd.Params) == 1 {
+						testType, _ = strconv.Atoi(cmd.Params[0])
 					}
 					go testSource.Test(testType)
 				}
 			default:
-				logger.WithField("cmd", cmd.Cmd).Info("不支持的指令")
+				if !c.handleSubCmd(gId, cId, cmd.Cmd) {
+					logger.WithField("cmd", cmd.Cmd).Info("不支持的指令")
+				}
 			}
 		}
 	}
 }
 
+// LiveSessions 回复直播间最近的直播记录
+func (c *CQBotSink) LiveSessions(gId, cId uint64, params []string) {
+	var reply string
+	switch {
+	case c.owner == nil:
+		reply = "不支持查询直播记录"
+	case len(params) != 1:
+		reply = fmt.Sprintf("参数错误，查询示例：%s 22625027", CQBotCmdLiveSessions)
+	default:
+		c.quietLock.Lock()
+		loc := c.location
+		c.quietLock.Unlock()
+		reply = sessionsText(params[0], c.owner.LiveSessions(params[0]), cqSessionNum, loc)
+	}
+	if err := c.bot.SendGuildMsg(gId, cId, reply); err != nil {
+		logger.WithFields(logrus.Fields{
+			"guildId":   gId,
+			"channelId": cId,
+			"err":       err,
+		}).Error("发送频道消息失败")
+	}
+}
+
+// Manage 回复运行状态，或者启动、停止名称为params[0]的source，只有管理员可以使用
+func (c *CQBotSink) Manage(gId, cId, senderId uint64, cmd string, params []string) {
+	var reply string
+	switch {
+	case c.owner == nil:
+		reply = "不支持查询运行状态"
+	case !c.admins[senderId]:
+		logger.WithFields(logrus.Fields{
+			"guildId":   gId,
+			"channelId": cId,
+			"senderId":  senderId,
+			"cmd":       cmd,
+		}).Warn("非管理员使用管理指令")
+		reply = "只有管理员可以使用该指令"
+	case cmd == CQBotCmdStatus:
+		reply = sourceStatusText(c.owner.Status()) + "\n" + sinkStatusText(c.owner.SinkStatus())
+	case len(params) != 1:
+		reply = fmt.Sprintf("参数错误，示例：%s [BiliLive]，名称见%s", cmd, CQBotCmdStatus)
+	default:
+		var err error
+		if cmd == CQBotCmdSourceStart {
+			err = c.owner.StartSource(params[0])
+		} else {
+			err = c.owner.StopSource(params[0])
+		}
+		if err != nil {
+			reply = fmt.Sprintf("%s失败：%s", strings.TrimPrefix(cmd, "/"), err)
+		} else {
+			reply = fmt.Sprintf("%s %s成功", params[0], strings.TrimPrefix(cmd, "/"))
+		}
+	}
+	if err := c.send(gId, cId, reply); err != nil {
+		logger.WithFields(logrus.Fields{
+			"guildId":   gId,
+			"channelId": cId,
+			"err":       err,
+		}).Error("发送频道消息失败")
+	}
+}
+
+// 处理订阅某一类消息的指令，cmd不是订阅指令时返回false
+func (c *CQBotSink) handleSubCmd(gId, cId uint64, cmd string) bool {
+	for _, sub := range cqBotSubCmds {
+		switch cmd {
+		case sub.sub:
+			c.Subscribe(gId, cId, sub.flag)
+			return true
+		case sub.cancel:
+			c.Unsubscribe(gId, cId, sub.flag)
+			return true
+		}
+	}
+	return false
+}
+
 func (c *CQBotSink) SubscribeAll(gId, cId uint64) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
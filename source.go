@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"forwardBot/push"
 	"github.com/sirupsen/logrus"
+	"sync"
 	"time"
 )
 
@@ -13,9 +14,93 @@ type Source interface {
 	Send(ctx context.Context, ch chan<- *push.Msg)
 }
 
+// Named 可以提供名称的source，没有实现时使用类型名称
+type Named interface {
+	Name() string
+}
+
+// HealthReporter 可以报告请求结果的source，Bot通过它获取最近一次成功的时间和连续失败次数
+type HealthReporter interface {
+	Health() SourceHealth
+}
+
+// SourceHealth source最近的请求结果，多个ID的结果合并在一起
+type SourceHealth struct {
+	LastSuccess time.Time      //最近一次请求成功的时间
+	LastError   error          //最近一次请求失败的错误
+	LastErrorAt time.Time      //最近一次请求失败的时间
+	Errors      int            //连续失败次数最多的ID的连续失败次数，该ID请求成功时清零
+	Failing     map[string]int //正在连续失败的ID和连续失败的次数
+}
+
+// health 按ID记录请求结果，嵌入source中实现HealthReporter
+type health struct {
+	healthLock sync.Mutex
+	ids        map[string]*SourceHealth
+}
+
+// 获取ID的请求结果，必须持有锁
+func (h *health) get(id any) *SourceHealth {
+	if h.ids == nil {
+		h.ids = make(map[string]*SourceHealth)
+	}
+	key := fmt.Sprint(id)
+	s, ok := h.ids[key]
+	if !ok {
+		s = new(SourceHealth)
+		h.ids[key] = s
+	}
+	return s
+}
+
+// 记录ID的一次成功的请求
+func (h *health) succeed(id any) {
+	h.healthLock.Lock()
+	defer h.healthLock.Unlock()
+	s := h.get(id)
+	s.LastSuccess = time.Now()
+	s.Errors = 0
+}
+
+// 记录ID的一次失败的请求
+func (h *health) fail(id any, err error) {
+	h.healthLock.Lock()
+	defer h.healthLock.Unlock()
+	s := h.get(id)
+	s.LastError = err
+	s.LastErrorAt = time.Now()
+	s.Errors++
+}
+
+// Health 合并所有ID的请求结果，一个ID一直失败时不会被其他ID的成功掩盖
+func (h *health) Health() SourceHealth {
+	h.healthLock.Lock()
+	defer h.healthLock.Unlock()
+	var status SourceHealth
+	for id, s := range h.ids {
+		if s.LastSuccess.After(status.LastSuccess) {
+			status.LastSuccess = s.LastSuccess
+		}
+		if s.LastErrorAt.After(status.LastErrorAt) {
+			status.LastError = s.LastError
+			status.LastErrorAt = s.LastErrorAt
+		}
+		if s.Errors == 0 {
+			continue
+		}
+		if status.Failing == nil {
+			status.Failing = make(map[string]int)
+		}
+		status.Failing[id] = s.Errors
+		status.Errors = max(status.Errors, s.Errors)
+	}
+	return status
+}
+
 var testSource = &CustomSource{ch: make(chan *push.Msg, 10)}
 
 var _ Source = (*CustomSource)(nil)
+var _ Named = (*CustomSource)(nil)
 
 // CustomSource 自定义source，用于测试推送系统
 type CustomSource struct {
@@ -23,6 +108,10 @@ type CustomSource struct {
 	running bool
 }
 
+func (c *CustomSource) Name() string {
+	return "[CustomSource]"
+}
+
 func (c *CustomSource) Send(ctx context.Context, ch chan<- *push.Msg) {
 	c.running = true
 	for {
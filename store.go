@@ -0,0 +1,107 @@
+package forwardBot
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store 持久化存储，以name区分不同的数据
+type Store interface {
+	// Load 读取数据到v中，数据不存在时不修改v并返回nil
+	Load(name string, v any) error
+	// Save 保存数据
+	Save(name string, v any) error
+}
+
+var (
+	//持久化存储，为nil时不持久化
+	store Store
+)
+
+// SetStore 设置持久化存储，必须在创建source之前调用
+func SetStore(s Store) {
+	store = s
+}
+
+// 从持久化存储中读取数据，失败时只记录日志
+func loadState(name string, v any) {
+	if store == nil {
+		return
+	}
+	if err := store.Load(name, v); err != nil {
+		logger.WithFields(logrus.Fields{
+			"name": name,
+			"err":  err,
+		}).Error("读取持久化数据失败")
+	}
+}
+
+// 保存数据到持久化存储中，失败时只记录日志
+func saveState(name string, v any) {
+	if store == nil {
+		return
+	}
+	if err := store.Save(name, v); err != nil {
+		logger.WithFields(logrus.Fields{
+			"name": name,
+			"err":  err,
+		}).Error("保存持久化数据失败")
+	}
+}
+
+var _ Store = (*FileStore)(nil)
+
+// FileStore 将数据以json格式保存在目录中，每个name对应一个文件
+type FileStore struct {
+	dir  string
+	lock sync.Mutex
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "create store dir fail")
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Dir 数据保存的目录
+func (f *FileStore) Dir() string {
+	return f.dir
+}
+
+func (f *FileStore) path(name string) string {
+	return filepath.Join(f.dir, name+".json")
+}
+
+func (f *FileStore) Load(name string, v any) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	data, err := os.ReadFile(f.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "read file fail")
+	}
+	return errors.Wrap(json.Unmarshal(data, v), "unmarshal fail")
+}
+
+func (f *FileStore) Save(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "marshal fail")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	//先写入临时文件再重命名，避免写入过程中退出导致文件损坏
+	tmp := f.path(name) + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrap(err, "write file fail")
+	}
+	return errors.Wrap(os.Rename(tmp, f.path(name)), "rename file fail")
+}
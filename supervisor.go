@@ -0,0 +1,51 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"forwardBot/push"
+	"github.com/sirupsen/logrus"
+	"runtime/debug"
+	"time"
+)
+
+// panicKey ctx中保存panic回调的key，source的goroutine发生panic时通过它通知runner
+type panicKey struct{}
+
+// panicHandler 发生panic时的回调
+type panicHandler func(name string, v any)
+
+// 在ctx中设置panic回调
+func withPanicHandler(ctx context.Context, fn panicHandler) context.Context {
+	return context.WithValue(ctx, panicKey{}, fn)
+}
+
+// recoverPanic 从panic中恢复并记录堆栈，必须直接通过defer调用
+func recoverPanic(ctx context.Context, name string) {
+	if v := recover(); v != nil {
+		reportPanic(ctx, name, v, debug.Stack())
+	}
+}
+
+// 记录panic的堆栈并调用ctx中的回调
+func reportPanic(ctx context.Context, name string, v any, stack []byte) {
+	logger.WithFields(logrus.Fields{
+		"name":  name,
+		"panic": v,
+		"stack": string(stack),
+	}).Error("发生panic")
+	if fn, ok := ctx.Value(panicKey{}).(panicHandler); ok {
+		fn(name, v)
+	}
+}
+
+// 发生panic时生成的告警消息
+func alertMsg(name string, v any) *push.Msg {
+	return &push.Msg{
+		Times:  time.Now(),
+		Flag:   BotAlertMsg,
+		Author: "Bot",
+		Title:  "运行异常",
+		Text:   fmt.Sprintf("%s发生panic：%v", name, v),
+	}
+}
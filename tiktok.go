@@ -10,6 +10,8 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,113 +19,311 @@ const (
 	startFlag          = `<script id="RENDER_DATA" type="application/json">`
 	endFlag            = `</script>`
 	tiktokLiveUrl      = "https://live.douyin.com/"
+	tiktokHost         = "live.douyin.com"
 	tiktokLiveShareUrl = "https://webcast.amemv.com/douyin/webcast/reflow/"
+	tiktokTtwidUrl     = "https://ttwid.bytedance.com/ttwid/union/register/"
+	tiktokEnterUrl     = "https://live.douyin.com/webcast/room/web/enter/"
+	tiktokMaxRetry     = 2 //没有获取到RENDER_DATA时的重试次数
+)
+
+const (
+	TiktokBackendAPI  = "api"  //通过webcast接口获取直播间信息，失败时使用网页
+	TiktokBackendHTML = "html" //从网页的RENDER_DATA中获取直播间信息
+)
+
+var (
+	ErrNoRenderData = errors.New("not exists RENDER_DATA") //页面中没有直播间数据，通常是cookies过期
 )
 
 var _ Source = (*TiktokLiveSource)(nil)
+var _ Named = (*TiktokLiveSource)(nil)
 
 type TiktokLiveSource struct {
-	client *req.C
-	living map[string]bool
-	users  []string
+	*liveTracker
+	health
+	client    *req.C
+	users     []string
+	backend   string
+	signature string //配置的__ac_signature，刷新cookies后恢复
+	sched     *scheduler
+	//多个直播间同时请求时共用client，刷新cookies时持有写锁，请求时持有读锁
+	cookieLock sync.RWMutex
+	cookieGen  int //cookies刷新的次数，多个直播间同时重试时只刷新一次
+	//请求地址，测试时可以替换
+	liveUrl  string
+	enterUrl string
+	ttwidUrl string
 }
 
+// NewTiktokLiveSource nonce和signature可以为空，为空时会自动获取需要的cookies
 func NewTiktokLiveSource(nonce, signature string, users []string) *TiktokLiveSource {
 	logger.WithFields(logrus.Fields{
 		"users": users,
 	}).Info("[tiktok]监控抖音直播间开播状态")
 	ts := new(TiktokLiveSource)
 	ts.client = req.New(10)
-	ts.client.SetCookies("__ac_nonce", nonce)
-	ts.client.SetCookies("__ac_signature", signature)
-	ts.client.SetCookies("__ac_referer", "https://live.douyin.com/")
-	ts.living = make(map[string]bool)
+	ts.client.SaveRespCookies(true)
+	if nonce != "" {
+		ts.client.SetCookies("__ac_nonce", nonce)
+	}
+	if signature != "" {
+		ts.client.SetCookies("__ac_signature", signature)
+	}
+	ts.signature = signature
+	ts.client.SetCookies("__ac_referer", tiktokLiveUrl)
+	ts.liveTracker = newLiveTracker("[tiktok]", "抖音", TikTokLiveMsg)
 	ts.users = users
+	ts.backend = TiktokBackendAPI
+	ts.sched = newScheduler("[tiktok]", tiktokHost, interval)
+	ts.liveUrl = tiktokLiveUrl
+	ts.enterUrl = tiktokEnterUrl
+	ts.ttwidUrl = tiktokTtwidUrl
 	return ts
 }
 
+// SetBackend 设置获取直播间信息的方式，TiktokBackendAPI或TiktokBackendHTML
+func (t *TiktokLiveSource) SetBackend(backend string) {
+	if backend != TiktokBackendAPI && backend != TiktokBackendHTML {
+		logger.WithField("backend", backend).Warn("[tiktok]不支持的获取方式，使用api")
+		backend = TiktokBackendAPI
+	}
+	logger.WithField("backend", backend).Info("[tiktok]设置获取直播间信息的方式")
+	t.backend = backend
+}
+
+func (t *TiktokLiveSource) Name() string {
+	return "[tiktok]"
+}
+
 func (t *TiktokLiveSource) Send(ctx context.Context, ch chan<- *push.Msg) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			logger.Info("[tiktok]停止监控抖音直播间")
-			return
-		case now := <-ticker.C:
-			for _, id := range t.users {
-				info, err := t.getLiveInfo(id)
-				if err != nil {
-					logger.WithFields(logrus.Fields{
-						"id":  id,
-						"err": err,
-					}).Error("[tiktok]获取抖音开播状态失败")
-					continue
-				}
-				if info.LiveStatus == t.living[id] {
-					logger.WithFields(logrus.Fields{
-						"id":     id,
-						"living": info.LiveStatus,
-					}).Debug("[tiktok]开播状态未改变")
-					info.Reset()
-					liveInfoPool.Put(info)
-					continue
-				}
-				t.living[id] = info.LiveStatus
-				msg := &push.Msg{
-					Times:  now,
-					Flag:   TikTokLiveMsg,
-					Author: info.Uname,
-				}
-				if info.LiveStatus {
-					//开播
-					logger.WithFields(logrus.Fields{
-						"id":   id,
-						"name": info.Uname,
-					}).Debug("[tiktok]抖音开播了")
-					msg.Title = "抖音开播了"
-					msg.Text = fmt.Sprintf("标题：\"%s\"", info.Title)
-					msg.Img = []string{info.Cover}
-					msg.Src = fmt.Sprintf("%s%s", tiktokLiveShareUrl, info.RoomIdStr)
-				} else {
-					//下播
-					logger.WithFields(logrus.Fields{
-						"id":   id,
-						"name": info.Uname,
-					}).Debug("[tiktok]抖音下播了")
-					msg.Title = "抖音下播了"
-					msg.Text = "😭😭😭"
-				}
-				ch <- msg
-				info.Reset()
-				liveInfoPool.Put(info)
-				time.Sleep(waitInterval)
-			}
-		}
+	schedule(ctx, t.sched, t.users, func(id string, now time.Time) {
+		t.poll(id, now, ch)
+	})
+	logger.Info("[tiktok]停止监控抖音直播间")
+}
+
+// SetInterval 设置轮询间隔，users为单独设置间隔的直播间，必须在Send方法之前调用
+func (t *TiktokLiveSource) SetInterval(d time.Duration, users map[string]time.Duration) {
+	t.sched.setInterval(d, users)
+}
+
+// SetAdaptive 在主播通常开播的时间前后使用更短的轮询间隔，必须在Send方法之前调用
+func (t *TiktokLiveSource) SetAdaptive(fast, window time.Duration) {
+	t.sched.setAdaptive(t.adaptiveInterval(fast, window))
+}
+
+// 获取一个直播间的开播状态并推送
+func (t *TiktokLiveSource) poll(id string, now time.Time, ch chan<- *push.Msg) {
+	info, err := t.getLiveInfo(id)
+	if err != nil {
+		t.fail(id, err)
+		logger.WithFields(logrus.Fields{
+			"id":  id,
+			"err": err,
+		}).Error("[tiktok]获取抖音开播状态失败")
+		return
+	}
+	t.succeed(id)
+	msg := t.update(id, info, now)
+	info.Reset()
+	liveInfoPool.Put(info)
+	if msg != nil {
+		ch <- msg
 	}
 }
 
 func (t *TiktokLiveSource) getLiveInfo(id string) (info *LiveInfo, err error) {
-	resp, err := t.client.Get(tiktokLiveUrl+id, nil, nil)
+	if t.backend == TiktokBackendHTML {
+		return t.getLiveInfoByHtml(id)
+	}
+	info, err = t.getLiveInfoByApi(id)
+	if err == nil {
+		return info, nil
+	}
+	logger.WithFields(logrus.Fields{
+		"id":  id,
+		"err": err,
+	}).Warn("[tiktok]通过接口获取直播间信息失败，使用网页获取")
+	return t.getLiveInfoByHtml(id)
+}
+
+// 通过webcast接口获取直播间信息
+func (t *TiktokLiveSource) getLiveInfoByApi(id string) (*LiveInfo, error) {
+	if err := t.ttwid(); err != nil {
+		return nil, err
+	}
+	t.cookieLock.RLock()
+	defer t.cookieLock.RUnlock()
+	resp, err := t.client.Get(t.enterUrl, req.D{
+		{"aid", 6383},
+		{"app_name", "douyin_web"},
+		{"live_id", 1},
+		{"device_platform", "web"},
+		{"language", "zh-CN"},
+		{"enter_from", "web_live"},
+		{"cookie_enabled", "true"},
+		{"browser_language", "zh-CN"},
+		{"browser_platform", "Win32"},
+		{"browser_name", "Chrome"},
+		{"browser_version", "105.0.0.0"},
+		{"web_rid", id},
+	}, nil, req.E{Name: "Referer", Value: t.liveUrl + id})
 	if err != nil {
 		return nil, errors.Wrap(err, "request fail")
 	}
-	b := resp.Bytes()
-	var start, end int
-	start = bytes.Index(b, []byte(startFlag))
+	result, err := checkResp(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "read tiktok resp data fail")
+	}
+	return parseTiktokEnter(id, result)
+}
+
+// 解析webcast/room/web/enter接口的响应
+func parseTiktokEnter(id string, result *gjson.Result) (*LiveInfo, error) {
+	if code := result.Get("status_code").Int(); code != 0 {
+		return nil, errors.New(fmt.Sprintf("status_code=%d,msg=%s", code, result.Get("data.message").String()))
+	}
+	data := result.Get("data")
+	user := data.Get("user")
+	if !user.IsObject() {
+		logger.WithFields(logrus.Fields{
+			"id":   id,
+			"resp": result.String(),
+		}).Error("[tiktok]获取data.user失败")
+		return nil, errors.New("not exists data.user object")
+	}
+	info := liveInfoPool.Get().(*LiveInfo)
+	info.MidStr = user.Get("id_str").String()
+	info.Uname = user.Get("nickname").String()
+	//未开播时data.data可能为空
+	room := data.Get("data.0")
+	//与网页中的roomId相同，是移动端使用的id
+	info.RoomIdStr = room.Get("id_str").String()
+	if info.RoomIdStr == "" {
+		info.RoomIdStr = data.Get("enter_room_id").String()
+	}
+	info.Link = tiktokLiveShareUrl + info.RoomIdStr
+	//2为开播
+	info.LiveStatus = room.Get("status").Int() == 2
+	if info.LiveStatus {
+		info.Title = room.Get("title").String()
+		info.Cover = room.Get("cover.url_list.0").String()
+	}
+	return info, nil
+}
+
+// 从直播间网页的RENDER_DATA中获取直播间信息
+func (t *TiktokLiveSource) getLiveInfoByHtml(id string) (info *LiveInfo, err error) {
+	var jsonStr string
+	t.cookieLock.RLock()
+	gen := t.cookieGen
+	t.cookieLock.RUnlock()
+	for i := 0; i <= tiktokMaxRetry; i++ {
+		if i == 1 {
+			//ttwid可能已经过期，重新获取，使用第一次请求的响应中设置的__ac_nonce
+			t.refreshCookies(gen)
+		}
+		jsonStr, err = t.renderData(id)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrNoRenderData) {
+			return nil, err
+		}
+		logger.WithFields(logrus.Fields{
+			"id":    id,
+			"retry": i,
+		}).Debug("[tiktok]没有获取到RENDER_DATA")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseTiktokRenderData(id, jsonStr)
+}
+
+// 获取ttwid，没有ttwid时抖音不会返回数据，link为注册ttwid的地址
+func ensureTtwid(client *req.C, link string) error {
+	if _, ok := client.Cookie("ttwid"); ok {
+		return nil
+	}
+	body := req.D{
+		{"region", "cn"},
+		{"aid", 1768},
+		{"needFid", false},
+		{"service", "www.ixigua.com"},
+		{"migrate_info", req.D{{"ticket", ""}, {"source", "node"}}},
+		{"cbUrlProtocol", "https"},
+		{"union", true},
+	}
+	_, err := client.Post(link, nil, strings.NewReader(body.Json()),
+		req.E{Name: "Content-Type", Value: "application/json"})
+	if err != nil {
+		return errors.Wrap(err, "register ttwid fail")
+	}
+	if _, ok := client.Cookie("ttwid"); !ok {
+		return errors.New("register ttwid fail, no ttwid in resp")
+	}
+	return nil
+}
+
+// 获取ttwid，获取期间其他直播间的请求等待获取结果
+func (t *TiktokLiveSource) ttwid() error {
+	t.cookieLock.Lock()
+	defer t.cookieLock.Unlock()
+	return ensureTtwid(t.client, t.ttwidUrl)
+}
+
+// 重新获取可能过期的ttwid，保留最近一次响应设置的__ac_nonce，配置了__ac_signature时恢复配置的值
+// gen为请求前的刷新次数，其他直播间已经刷新过cookies时不再刷新
+func (t *TiktokLiveSource) refreshCookies(gen int) {
+	t.cookieLock.Lock()
+	defer t.cookieLock.Unlock()
+	if t.cookieGen != gen {
+		return
+	}
+	t.cookieGen++
+	t.client.ClearCookies("ttwid")
+	if t.signature != "" {
+		t.client.SetCookies("__ac_signature", t.signature)
+	}
+	if err := ensureTtwid(t.client, t.ttwidUrl); err != nil {
+		logger.WithField("err", err).Warn("[tiktok]获取ttwid失败")
+	}
+}
+
+// 请求直播间页面，返回RENDER_DATA中的json，页面中没有RENDER_DATA时返回ErrNoRenderData
+func (t *TiktokLiveSource) renderData(id string) (string, error) {
+	if err := t.ttwid(); err != nil {
+		logger.WithField("err", err).Warn("[tiktok]获取ttwid失败")
+	}
+	t.cookieLock.RLock()
+	defer t.cookieLock.RUnlock()
+	resp, err := t.client.Get(t.liveUrl+id, nil, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "request fail")
+	}
+	return findRenderData(resp.Bytes())
+}
+
+// 从页面中截取RENDER_DATA并解码
+func findRenderData(b []byte) (string, error) {
+	start := bytes.Index(b, []byte(startFlag))
 	if start < 0 {
-		return nil, errors.New("get info fail(start < 0), signature maybe error")
+		return "", ErrNoRenderData
 	}
 	b = b[start+len(startFlag):]
-	end = bytes.Index(b, []byte(endFlag))
+	end := bytes.Index(b, []byte(endFlag))
 	if end < 0 {
-		return nil, errors.New("get info fail(end < 0), signature maybe error")
+		return "", ErrNoRenderData
 	}
-	b = b[:end]
-	jsonStr, err := url.QueryUnescape(string(b))
+	jsonStr, err := url.QueryUnescape(string(b[:end]))
 	if err != nil {
-		return nil, errors.Wrap(err, "unescape url fail")
+		return "", errors.Wrap(err, "unescape url fail")
 	}
+	return jsonStr, nil
+}
+
+func parseTiktokRenderData(id, jsonStr string) (info *LiveInfo, err error) {
 	roomInfo := gjson.Get(jsonStr, "app.initialState.roomStore.roomInfo")
 	if !roomInfo.Exists() {
 		logger.WithFields(logrus.Fields{
@@ -165,6 +365,7 @@ func (t *TiktokLiveSource) getLiveInfo(id string) (info *LiveInfo, err error) {
 	//这里的roomId和传入的id会不同，这里的roomId是移动端使用的id，
 	//pc网页端有一个web_rid，传入的参数id即是web_rid
 	info.RoomIdStr = roomInfo.Get("roomId").String()
+	info.Link = tiktokLiveShareUrl + info.RoomIdStr
 
 	if isLiving {
 		info.Title = room.Get("title").String()
@@ -2,9 +2,16 @@ package forwardBot
 
 import (
 	"bytes"
+	"fmt"
 	"forwardBot/req"
+	"github.com/stretchr/testify/assert"
 	"github.com/tidwall/gjson"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -41,3 +48,157 @@ func TestGetLiveInfo(t *testing.T) {
 	}
 	t.Log(roomInfo.String())
 }
+
+func TestFindRenderData(t *testing.T) {
+	data := url.QueryEscape(`{"app":{"initialState":{"roomStore":{"roomInfo":{"roomId":"7150000000000000000",` +
+		`"room":{"status":2,"title":"直播中","cover":{"url_list":["cover.jpg"]}},` +
+		`"anchor":{"id_str":"100","nickname":"主播"}}}}}}`)
+	tests := []struct {
+		name string
+		page string
+		err  error
+	}{
+		{"case nonce page", `<html><script>window.__ac_nonce="0632"</script></html>`, ErrNoRenderData},
+		{"case no end", `<html>` + startFlag + data, ErrNoRenderData},
+		{"case ok", `<html>` + startFlag + data + endFlag + `</html>`, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			jsonStr, err := findRenderData([]byte(test.page))
+			assert.Equal(t, test.err, err)
+			if err != nil {
+				return
+			}
+			info, err := parseTiktokRenderData("804284713107", jsonStr)
+			if assert.Nil(t, err) {
+				assert.True(t, info.LiveStatus)
+				assert.Equal(t, "主播", info.Uname)
+				assert.Equal(t, "直播中", info.Title)
+				assert.Equal(t, "cover.jpg", info.Cover)
+				assert.Equal(t, tiktokLiveShareUrl+"7150000000000000000", info.Link)
+			}
+		})
+	}
+}
+
+func TestParseTiktokEnter(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		err    bool
+		living bool
+		roomId string
+		title  string
+	}{
+		{"case living", "enter_living.json", false, true, "7150000000000000000", "晚上好"},
+		{"case offline", "enter_offline.json", false, false, "7150000000000000001", ""},
+		{"case error", "enter_error.json", true, false, "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/tiktok/" + test.file)
+			if !assert.Nil(t, err) {
+				return
+			}
+			result := gjson.ParseBytes(data)
+			info, err := parseTiktokEnter("804284713107", &result)
+			if test.err {
+				assert.NotNil(t, err)
+				return
+			}
+			if assert.Nil(t, err) {
+				assert.Equal(t, test.living, info.LiveStatus)
+				assert.Equal(t, "主播", info.Uname)
+				assert.Equal(t, "100", info.MidStr)
+				assert.Equal(t, test.roomId, info.RoomIdStr)
+				assert.Equal(t, test.title, info.Title)
+				assert.Equal(t, tiktokLiveShareUrl+test.roomId, info.Link)
+			}
+		})
+	}
+}
+
+// 使用本地服务器模拟第一次请求没有RENDER_DATA，刷新cookies后重试的情况
+func TestTiktokLiveSource_Retry(t *testing.T) {
+	data := url.QueryEscape(`{"app":{"initialState":{"roomStore":{"roomInfo":{"roomId":"1",` +
+		`"room":{"status":2,"title":"直播中"},"anchor":{"id_str":"100","nickname":"主播"}}}}}}`)
+	var ttwid, pages int32
+	var cookies []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ttwid":
+			n := atomic.AddInt32(&ttwid, 1)
+			http.SetCookie(w, &http.Cookie{Name: "ttwid", Value: fmt.Sprintf("t%d", n)})
+		case "/enter":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/live/1":
+			received := make(map[string]string)
+			for _, c := range r.Cookies() {
+				received[c.Name] = c.Value
+			}
+			cookies = append(cookies, received)
+			if atomic.AddInt32(&pages, 1) == 1 {
+				http.SetCookie(w, &http.Cookie{Name: "__ac_nonce", Value: "n1"})
+				_, _ = w.Write([]byte(`<html><script>window.__ac_nonce="n1"</script></html>`))
+				return
+			}
+			_, _ = w.Write([]byte(`<html>` + startFlag + data + endFlag + `</html>`))
+		}
+	}))
+	defer server.Close()
+
+	source := NewTiktokLiveSource("", "sig", []string{"1"})
+	source.liveUrl = server.URL + "/live/"
+	source.enterUrl = server.URL + "/enter"
+	source.ttwidUrl = server.URL + "/ttwid"
+	//接口失败时使用网页获取
+	info, err := source.getLiveInfo("1")
+	if assert.Nil(t, err) {
+		assert.True(t, info.LiveStatus)
+		assert.Equal(t, "主播", info.Uname)
+	}
+	if assert.Len(t, cookies, 2) {
+		assert.Equal(t, "t1", cookies[0]["ttwid"])
+		//重试时使用新的ttwid和响应中设置的__ac_nonce，保留配置的__ac_signature
+		assert.Equal(t, "t2", cookies[1]["ttwid"])
+		assert.Equal(t, "n1", cookies[1]["__ac_nonce"])
+		assert.Equal(t, "sig", cookies[1]["__ac_signature"])
+	}
+}
+
+func TestTiktokLiveSource_ConcurrentRetry(t *testing.T) {
+	data := url.QueryEscape(`{"app":{"initialState":{"roomStore":{"roomInfo":{"roomId":"1",` +
+		`"room":{"status":4},"anchor":{"id_str":"100","nickname":"主播"}}}}}}`)
+	var ttwid int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ttwid" {
+			n := atomic.AddInt32(&ttwid, 1)
+			http.SetCookie(w, &http.Cookie{Name: "ttwid", Value: fmt.Sprintf("t%d", n)})
+			return
+		}
+		//第一个ttwid已经过期
+		if c, err := r.Cookie("ttwid"); err != nil || c.Value == "t1" {
+			_, _ = w.Write([]byte(`<html></html>`))
+			return
+		}
+		_, _ = w.Write([]byte(`<html>` + startFlag + data + endFlag + `</html>`))
+	}))
+	defer server.Close()
+
+	users := []string{"1", "2", "3", "4"}
+	source := NewTiktokLiveSource("", "", users)
+	source.liveUrl = server.URL + "/live/"
+	source.ttwidUrl = server.URL + "/ttwid"
+	wg := sync.WaitGroup{}
+	for _, id := range users {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			_, err := source.getLiveInfoByHtml(id)
+			assert.Nil(t, err)
+		}(id)
+	}
+	wg.Wait()
+	//多个直播间同时重试时只刷新一次ttwid
+	assert.Equal(t, int32(2), atomic.LoadInt32(&ttwid))
+}
@@ -0,0 +1,225 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"forwardBot/push"
+	"forwardBot/req"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	tiktokPostUrl       = "https://www.douyin.com/aweme/v1/web/aweme/post/"
+	tiktokVideoPrefix   = "https://www.douyin.com/video/"
+	tiktokUserPrefix    = "https://www.douyin.com/user/"
+	tiktokPostHost      = "www.douyin.com"
+	tiktokPostStoreName = "tiktok_post"
+	tiktokPostCount     = 18 //每次获取的作品数量
+	tiktokSeenMax       = 50 //每个用户最多记录的作品id数量
+)
+
+// TiktokPost 抖音作品
+type TiktokPost struct {
+	AwemeId string
+	Author  string
+	Desc    string
+	Cover   string
+	Images  []string //图文作品的图片
+	Link    string
+	Created time.Time
+}
+
+var _ Source = (*TiktokPostSource)(nil)
+var _ Named = (*TiktokPostSource)(nil)
+
+// TiktokPostSource 获取抖音用户发布的作品
+type TiktokPostSource struct {
+	health
+	client *req.C
+	users  []string            //用户的sec_uid
+	seen   map[string][]string //每个用户已经推送过的作品id，新的在前
+	lock   sync.Mutex          //保护seen
+	sched  *scheduler
+}
+
+func NewTiktokPostSource(users []string) *TiktokPostSource {
+	logger.WithFields(logrus.Fields{
+		"users": users,
+	}).Info("[tiktokPost]监控抖音作品")
+	t := &TiktokPostSource{
+		client: req.New(10),
+		users:  users,
+		seen:   make(map[string][]string),
+		sched:  newScheduler("[tiktokPost]", tiktokPostHost, interval),
+	}
+	t.client.SaveRespCookies(true)
+	loadState(tiktokPostStoreName, &t.seen)
+	return t
+}
+
+func (t *TiktokPostSource) Name() string {
+	return "[tiktokPost]"
+}
+
+func (t *TiktokPostSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	schedule(ctx, t.sched, t.users, func(id string, now time.Time) {
+		t.poll(id, ch)
+	})
+	logger.Info("[tiktokPost]停止监控抖音作品")
+}
+
+// SetInterval 设置轮询间隔，users为单独设置间隔的sec_uid，必须在Send方法之前调用
+func (t *TiktokPostSource) SetInterval(d time.Duration, users map[string]time.Duration) {
+	t.sched.setInterval(d, users)
+}
+
+func (t *TiktokPostSource) poll(id string, ch chan<- *push.Msg) {
+	posts, err := t.getPosts(id)
+	if err != nil {
+		t.fail(id, err)
+		logger.WithFields(logrus.Fields{
+			"id":  id,
+			"err": err,
+		}).Error("[tiktokPost]获取抖音作品失败")
+		return
+	}
+	t.succeed(id)
+	news := t.filter(id, posts)
+	for _, post := range news {
+		logger.WithFields(logrus.Fields{
+			"id":      id,
+			"awemeId": post.AwemeId,
+		}).Debug("[tiktokPost]新作品")
+		ch <- postMsg(post)
+	}
+}
+
+// 获取用户最近发布的作品
+func (t *TiktokPostSource) getPosts(secUid string) ([]*TiktokPost, error) {
+	if err := ensureTtwid(t.client, tiktokTtwidUrl); err != nil {
+		logger.WithField("err", err).Warn("[tiktokPost]获取ttwid失败")
+	}
+	resp, err := t.client.Get(tiktokPostUrl, req.D{
+		{"aid", 6383},
+		{"device_platform", "webapp"},
+		{"sec_user_id", secUid},
+		{"max_cursor", 0},
+		{"count", tiktokPostCount},
+		{"cookie_enabled", "true"},
+		{"browser_language", "zh-CN"},
+		{"browser_platform", "Win32"},
+		{"browser_name", "Chrome"},
+		{"browser_version", "105.0.0.0"},
+	}, nil, req.E{Name: "Referer", Value: tiktokUserPrefix + secUid})
+	if err != nil {
+		return nil, errors.Wrap(err, "request fail")
+	}
+	result, err := checkResp(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "read tiktok resp data fail")
+	}
+	return parseAwemeList(secUid, result)
+}
+
+// 解析作品列表，列表按发布时间倒序，置顶的作品在最前面
+func parseAwemeList(secUid string, result *gjson.Result) ([]*TiktokPost, error) {
+	if code := result.Get("status_code").Int(); code != 0 {
+		return nil, errors.New(fmt.Sprintf("status_code=%d,msg=%s", code, result.Get("status_msg").String()))
+	}
+	list := result.Get("aweme_list")
+	if !list.IsArray() {
+		logger.WithFields(logrus.Fields{
+			"secUid": secUid,
+			"resp":   result.String(),
+		}).Error("[tiktokPost]获取aweme_list失败")
+		return nil, errors.New("not exists aweme_list")
+	}
+	posts := make([]*TiktokPost, 0, len(list.Array()))
+	for _, item := range list.Array() {
+		post := &TiktokPost{
+			AwemeId: item.Get("aweme_id").String(),
+			Author:  item.Get("author.nickname").String(),
+			Desc:    item.Get("desc").String(),
+			Cover:   item.Get("video.cover.url_list.0").String(),
+			Link:    item.Get("share_url").String(),
+			Created: time.Unix(item.Get("create_time").Int(), 0),
+		}
+		if post.AwemeId == "" {
+			continue
+		}
+		for _, img := range item.Get("images").Array() {
+			post.Images = append(post.Images, img.Get("url_list.0").String())
+		}
+		if post.Link == "" {
+			post.Link = tiktokVideoPrefix + post.AwemeId
+		}
+		posts = append(posts, post)
+	}
+	return posts, nil
+}
+
+// 过滤出没有推送过的作品，按发布时间升序返回，第一次获取时只记录
+func (t *TiktokPostSource) filter(secUid string, posts []*TiktokPost) (news []*TiktokPost) {
+	if len(posts) == 0 {
+		//被风控时可能返回空列表，不作为第一次获取的记录
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	seen, inited := t.seen[secUid]
+	known := make(map[string]bool, len(seen))
+	for _, id := range seen {
+		known[id] = true
+	}
+	//本次获取到的作品都放在前面，一直出现在列表中的置顶作品不会被挤出记录后再次推送
+	ids := make([]string, 0, len(posts)+len(seen))
+	fetched := make(map[string]bool, len(posts))
+	for _, post := range posts {
+		if fetched[post.AwemeId] {
+			continue
+		}
+		fetched[post.AwemeId] = true
+		ids = append(ids, post.AwemeId)
+		if inited && !known[post.AwemeId] {
+			news = append(news, post)
+		}
+	}
+	for _, id := range seen {
+		if !fetched[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > tiktokSeenMax {
+		ids = ids[:tiktokSeenMax]
+	}
+	t.seen[secUid] = ids
+	saveState(tiktokPostStoreName, t.seen)
+	//置顶的作品不一定是最新的，按发布时间排序
+	sort.Slice(news, func(i, j int) bool {
+		return news[i].Created.Before(news[j].Created)
+	})
+	return news
+}
+
+func postMsg(post *TiktokPost) *push.Msg {
+	msg := &push.Msg{
+		Times:  post.Created,
+		Flag:   TikTokPostMsg,
+		Author: post.Author,
+		Title:  "发布视频",
+		Text:   post.Desc,
+		Src:    post.Link,
+	}
+	if len(post.Images) != 0 {
+		msg.Title = "发布图文"
+		msg.Img = post.Images
+	} else if post.Cover != "" {
+		msg.Img = []string{post.Cover}
+	}
+	return msg
+}
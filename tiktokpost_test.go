@@ -0,0 +1,73 @@
+package forwardBot
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseAwemeList(t *testing.T) {
+	data, err := os.ReadFile("testdata/tiktok/post.json")
+	if !assert.Nil(t, err) {
+		return
+	}
+	result := gjson.ParseBytes(data)
+	posts, err := parseAwemeList("MS4wLjABAAAA", &result)
+	if !assert.Nil(t, err) || !assert.Len(t, posts, 3) {
+		return
+	}
+	msg := postMsg(posts[1])
+	assert.Equal(t, TikTokPostMsg, msg.Flag)
+	assert.Equal(t, "发布图文", msg.Title)
+	assert.Equal(t, "主播", msg.Author)
+	assert.Equal(t, "图文作品 #日常", msg.Text)
+	assert.Equal(t, []string{"https://p3-sign.douyinpic.com/img1.jpeg", "https://p3-sign.douyinpic.com/img2.jpeg"}, msg.Img)
+	assert.Equal(t, tiktokVideoPrefix+"7150000000000000003", msg.Src)
+
+	msg = postMsg(posts[2])
+	assert.Equal(t, "发布视频", msg.Title)
+	assert.Equal(t, []string{"https://p3-sign.douyinpic.com/cover2.jpeg"}, msg.Img)
+	assert.Equal(t, "https://www.iesdouyin.com/share/video/7150000000000000002/", msg.Src)
+}
+
+func TestTiktokPostSource_Filter(t *testing.T) {
+	s := &TiktokPostSource{seen: make(map[string][]string)}
+	newPost := func(id string, created int64) *TiktokPost {
+		return &TiktokPost{AwemeId: id, Created: time.Unix(created, 0)}
+	}
+	top := newPost("1", 100)
+	tests := []struct {
+		name  string
+		posts []*TiktokPost
+		news  []string
+	}{
+		{"case empty not inited", nil, nil},
+		{"case first", []*TiktokPost{top, newPost("3", 300), newPost("2", 200)}, nil},
+		{"case no update", []*TiktokPost{top, newPost("3", 300), newPost("2", 200)}, nil},
+		{"case new posts", []*TiktokPost{top, newPost("5", 500), newPost("4", 400), newPost("3", 300)}, []string{"4", "5"}},
+		{"case deleted", []*TiktokPost{top, newPost("4", 400), newPost("3", 300)}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ids []string
+			for _, post := range s.filter("user", test.posts) {
+				ids = append(ids, post.AwemeId)
+			}
+			assert.Equal(t, test.news, ids)
+		})
+	}
+
+	//置顶的作品一直出现在列表中，发布很多新作品后也不会再次推送
+	for i := 0; i < tiktokSeenMax; i++ {
+		id := fmt.Sprint(1000 + i)
+		news := s.filter("user", []*TiktokPost{top, newPost(id, int64(1000+i))})
+		if assert.Len(t, news, 1) {
+			assert.Equal(t, id, news[0].AwemeId)
+		}
+	}
+	assert.Equal(t, "1", s.seen["user"][0])
+	assert.Len(t, s.seen["user"], tiktokSeenMax)
+}
@@ -0,0 +1,284 @@
+package forwardBot
+
+import (
+	"context"
+	"fmt"
+	"forwardBot/push"
+	"forwardBot/req"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	weiboContainerUrl = "https://m.weibo.cn/api/container/getIndex"
+	weiboExtendUrl    = "https://m.weibo.cn/statuses/extend"
+	weiboPostUrl      = "https://m.weibo.cn/detail/"
+	weiboTimeLayout   = "Mon Jan 02 15:04:05 -0700 2006"
+	weiboHost         = "m.weibo.cn"
+)
+
+var (
+	weiboBrRegexp  = regexp.MustCompile(`<br\s*/?>`)
+	weiboTagRegexp = regexp.MustCompile(`<[^>]*>`)
+)
+
+var _ Source = (*WeiboSource)(nil)
+var _ Named = (*WeiboSource)(nil)
+
+// WeiboSource 获取微博用户的新微博
+type WeiboSource struct {
+	health
+	client    *req.C
+	uid       []int64
+	lastTable map[int64]int64 //每个用户已经推送过的最新微博id
+	lock      sync.Mutex      //保护lastTable
+	sched     *scheduler
+	//获取长微博的全文，测试时可以替换
+	longText func(id string) (string, error)
+}
+
+// WeiboPost 一条微博
+type WeiboPost struct {
+	Id     int64
+	Author string
+	Text   string
+	Img    []string
+	Src    string
+	Times  time.Time
+	Repost bool //是否是转发
+}
+
+func NewWeiboSource(uid []int64, cookies map[string]string) *WeiboSource {
+	logger.WithFields(logrus.Fields{
+		"uid": uid,
+	}).Info("[Weibo]监控微博更新")
+	w := &WeiboSource{
+		client:    req.New(10),
+		uid:       uid,
+		lastTable: make(map[int64]int64),
+		sched:     newScheduler("[Weibo]", weiboHost, interval),
+	}
+	w.longText = w.getLongText
+	for k, v := range cookies {
+		w.client.SetCookies(k, v)
+	}
+	return w
+}
+
+func (w *WeiboSource) Name() string {
+	return "[Weibo]"
+}
+
+func (w *WeiboSource) Send(ctx context.Context, ch chan<- *push.Msg) {
+	schedule(ctx, w.sched, w.uid, func(id int64, now time.Time) {
+		w.poll(id, ch)
+	})
+	logger.Info("[Weibo]停止微博监控")
+}
+
+// SetInterval 设置轮询间隔，users为单独设置间隔的uid，必须在Send方法之前调用
+func (w *WeiboSource) SetInterval(d time.Duration, users map[string]time.Duration) {
+	w.sched.setInterval(d, users)
+}
+
+// 获取一个用户的新微博并推送
+func (w *WeiboSource) poll(id int64, ch chan<- *push.Msg) {
+	posts, err := w.timeline(id)
+	if err != nil {
+		w.fail(id, err)
+		logger.WithFields(logrus.Fields{
+			"id":  id,
+			"err": err,
+		}).Error("[Weibo]获取微博失败")
+		return
+	}
+	w.succeed(id)
+	if len(posts) == 0 {
+		logger.WithField("id", id).Debug("[Weibo]无新微博")
+	}
+	for _, post := range posts {
+		title := "发布微博"
+		if post.Repost {
+			title = "转发微博"
+		}
+		logger.WithFields(logrus.Fields{
+			"id":   id,
+			"name": post.Author,
+			"src":  post.Src,
+		}).Debug("[Weibo]更新微博")
+		ch <- &push.Msg{
+			Times:  post.Times,
+			Flag:   WeiboMsg,
+			Author: post.Author,
+			Title:  title,
+			Text:   post.Text,
+			Img:    post.Img,
+			Src:    post.Src,
+		}
+	}
+}
+
+// 获取用户的微博，第一次获取时只记录最新的微博id
+func (w *WeiboSource) timeline(uid int64) (posts []*WeiboPost, err error) {
+	resp, err := w.client.Get(weiboContainerUrl, req.D{
+		{"type", "uid"},
+		{"value", uid},
+		{"containerid", fmt.Sprintf("107603%d", uid)},
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	result, err := checkResp(resp)
+	if err != nil {
+		return nil, errors.Wrap(err, "read weibo resp data fail")
+	}
+	if ok := result.Get("ok").Int(); ok != 1 {
+		return nil, errors.New(fmt.Sprintf("ok=%d,msg=%s", ok, result.Get("msg").String()))
+	}
+	cards := result.Get("data.cards")
+	if !cards.IsArray() {
+		logger.WithFields(logrus.Fields{
+			"uid":  uid,
+			"resp": result.String(),
+		}).Error("[Weibo]获取data.cards失败")
+		return nil, errors.New("不存在data.cards字段")
+	}
+	return w.filter(uid, cards.Array()), nil
+}
+
+// 按微博id过滤出新的微博再解析，已经推送过的微博不会请求长微博的全文
+func (w *WeiboSource) filter(uid int64, cards []gjson.Result) (posts []*WeiboPost) {
+	w.lock.Lock()
+	last, inited := w.lastTable[uid]
+	w.lock.Unlock()
+	newest := last
+	for _, card := range cards {
+		//9为微博，其他类型的卡片不处理
+		if card.Get("card_type").Int() != 9 {
+			continue
+		}
+		mblog := card.Get("mblog")
+		id, err := strconv.ParseInt(mblog.Get("id").String(), 10, 64)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"mblog": mblog.String(),
+				"err":   err,
+			}).Warn("[Weibo]解析微博id失败")
+			continue
+		}
+		newest = max(newest, id)
+		//置顶的微博id较小，会被自然过滤
+		if !inited || id <= last {
+			continue
+		}
+		posts = append(posts, w.parsePost(id, &mblog))
+	}
+	w.lock.Lock()
+	w.lastTable[uid] = newest
+	w.lock.Unlock()
+	return posts
+}
+
+func (w *WeiboSource) parsePost(id int64, mblog *gjson.Result) *WeiboPost {
+	var err error
+	post := &WeiboPost{
+		Id:     id,
+		Author: mblog.Get("user.screen_name").String(),
+		Src:    fmt.Sprintf("%s%d", weiboPostUrl, id),
+	}
+	post.Times, err = time.Parse(weiboTimeLayout, mblog.Get("created_at").String())
+	if err != nil {
+		post.Times = time.Now()
+	}
+	text := w.postText(mblog)
+	retweeted := mblog.Get("retweeted_status")
+	if retweeted.Exists() {
+		post.Repost = true
+		origAuthor := retweeted.Get("user.screen_name").String()
+		if origAuthor == "" {
+			//原微博已被删除
+			post.Text = fmt.Sprintf("%s\n转发自：%s", text, w.postText(&retweeted))
+			return post
+		}
+		post.Text = fmt.Sprintf("%s \n转发自：@%s\n%s", text, origAuthor, w.postText(&retweeted))
+		post.Img = weiboImages(&retweeted)
+		return post
+	}
+	post.Text = text
+	post.Img = weiboImages(mblog)
+	return post
+}
+
+// 获取微博内容，包括展开后的长微博和视频卡片
+func (w *WeiboSource) postText(mblog *gjson.Result) string {
+	text := mblog.Get("text").String()
+	if mblog.Get("isLongText").Bool() {
+		long, err := w.longText(mblog.Get("id").String())
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"id":  mblog.Get("id").String(),
+				"err": err,
+			}).Warn("[Weibo]获取长微博失败")
+		} else {
+			text = long
+		}
+	}
+	text = weiboPlainText(text)
+	pageInfo := mblog.Get("page_info")
+	if pageInfo.Get("type").String() == "video" {
+		title := pageInfo.Get("title").String()
+		if title == "" {
+			title = pageInfo.Get("page_title").String()
+		}
+		text = fmt.Sprintf("%s\n视频：%s", text, title)
+	}
+	return text
+}
+
+func (w *WeiboSource) getLongText(id string) (string, error) {
+	resp, err := w.client.Get(weiboExtendUrl, req.D{{"id", id}}, nil)
+	if err != nil {
+		return "", err
+	}
+	result, err := checkResp(resp)
+	if err != nil {
+		return "", errors.Wrap(err, "read weibo resp data fail")
+	}
+	content := result.Get("data.longTextContent")
+	if !content.Exists() {
+		return "", errors.New("不存在data.longTextContent字段")
+	}
+	return content.String(), nil
+}
+
+// 微博中的图片，视频微博使用视频封面
+func weiboImages(mblog *gjson.Result) []string {
+	var img []string
+	for _, pic := range mblog.Get("pics").Array() {
+		src := pic.Get("large.url").String()
+		if src == "" {
+			src = pic.Get("url").String()
+		}
+		img = append(img, src)
+	}
+	if len(img) == 0 {
+		if cover := mblog.Get("page_info.page_pic.url").String(); cover != "" {
+			img = append(img, cover)
+		}
+	}
+	return img
+}
+
+// 去除微博内容中的html标签
+func weiboPlainText(text string) string {
+	text = weiboBrRegexp.ReplaceAllString(text, "\n")
+	text = weiboTagRegexp.ReplaceAllString(text, "")
+	r := strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", "\"", "&amp;", "&", "&nbsp;", " ")
+	return strings.TrimSpace(r.Replace(text))
+}
@@ -0,0 +1,92 @@
+package forwardBot
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"testing"
+)
+
+func TestWeiboPlainText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{"case br", "第一行<br />第二行", "第一行\n第二行"},
+		{"case link", `看<a href="https://m.weibo.cn/search?containerid=1">#话题#</a>`, "看#话题#"},
+		{"case escape", "a &amp; b &lt;c&gt;", "a & b <c>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.out, weiboPlainText(test.in))
+		})
+	}
+}
+
+func TestWeiboSource_ParsePost(t *testing.T) {
+	w := NewWeiboSource(nil, nil)
+	mblog := gjson.Parse(`{
+		"id": "4823456789012345",
+		"created_at": "Sat Oct 15 20:03:12 +0800 2022",
+		"text": "转发<br />一下",
+		"user": {"screen_name": "主播"},
+		"retweeted_status": {
+			"id": "4823456789000000",
+			"text": "原微博",
+			"user": {"screen_name": "原作者"},
+			"pics": [{"url": "small.jpg", "large": {"url": "large.jpg"}}]
+		}
+	}`)
+	post := w.parsePost(4823456789012345, &mblog)
+	if assert.NotNil(t, post) {
+		assert.True(t, post.Repost)
+		assert.Equal(t, "主播", post.Author)
+		assert.Equal(t, "转发\n一下 \n转发自：@原作者\n原微博", post.Text)
+		assert.Equal(t, []string{"large.jpg"}, post.Img)
+		assert.Equal(t, "https://m.weibo.cn/detail/4823456789012345", post.Src)
+		assert.Equal(t, int64(1665835392), post.Times.Unix())
+	}
+
+	video := gjson.Parse(`{
+		"id": "4823456789012346",
+		"created_at": "Sat Oct 15 20:03:12 +0800 2022",
+		"text": "新视频",
+		"user": {"screen_name": "主播"},
+		"page_info": {"type": "video", "title": "视频标题", "page_pic": {"url": "cover.jpg"}}
+	}`)
+	post = w.parsePost(4823456789012346, &video)
+	if assert.NotNil(t, post) {
+		assert.False(t, post.Repost)
+		assert.Equal(t, "新视频\n视频：视频标题", post.Text)
+		assert.Equal(t, []string{"cover.jpg"}, post.Img)
+	}
+}
+
+// 只请求新微博的长微博全文
+func TestWeiboSource_Filter(t *testing.T) {
+	w := NewWeiboSource(nil, nil)
+	var fetched []string
+	w.longText = func(id string) (string, error) {
+		fetched = append(fetched, id)
+		return "全文", nil
+	}
+	cards := func(ids ...string) []gjson.Result {
+		var result []gjson.Result
+		for _, id := range ids {
+			result = append(result, gjson.Parse(`{"card_type": 9, "mblog": {"id": "`+id+`",
+				"text": "摘要", "isLongText": true, "user": {"screen_name": "主播"}}}`))
+		}
+		return result
+	}
+	//第一次获取时只记录
+	assert.Empty(t, w.filter(1, cards("100", "99")))
+	assert.Empty(t, fetched)
+	posts := w.filter(1, cards("1", "101", "100", "99"))
+	if assert.Len(t, posts, 1) {
+		assert.Equal(t, int64(101), posts[0].Id)
+		assert.Equal(t, "全文", posts[0].Text)
+	}
+	assert.Equal(t, []string{"101"}, fetched)
+	assert.Empty(t, w.filter(1, cards("101", "100")))
+	assert.Equal(t, []string{"101"}, fetched)
+}